@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
+)
+
+// DevPaymentHandler drives the fake payment gateway. It is only mounted when
+// the fake gateway is configured.
+type DevPaymentHandler struct {
+	gateway *paymentgateway.FakeGateway
+}
+
+func NewDevPaymentHandler(gateway *paymentgateway.FakeGateway) *DevPaymentHandler {
+	return &DevPaymentHandler{
+		gateway: gateway,
+	}
+}
+
+func (h *DevPaymentHandler) ListPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.gateway.Payments())
+}
+
+// SimulatePaymentHandler handles POST /dev/payments/{id}/{action} where
+// action is pay, expire or fail.
+func (h *DevPaymentHandler) SimulatePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	payment, err := h.gateway.Simulate(r.Context(), r.PathValue("id"), r.PathValue("action"))
+	switch {
+	case errors.Is(err, paymentgateway.ErrPaymentRequestNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, paymentgateway.ErrInvalidSimulation):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "payment updated but callback failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payment)
+}
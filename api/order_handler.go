@@ -3,13 +3,18 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
-	"sync"
+	"strings"
+	"time"
 
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/checkout"
 	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
 	"github.com/duniandewon/madkunyah-transactions-service/internal/features/payments"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/refunds"
 	mw "github.com/duniandewon/madkunyah-transactions-service/internal/middleware"
 	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
 )
@@ -17,29 +22,35 @@ import (
 type OrderHandler struct {
 	repo           orders.OrderRepository
 	paymentService payments.PaymentService
+	checkout       checkout.CheckoutService
 	menuClient     *orders.MenuClient
-	paymentgateway paymentgateway.PaymentGateway
+	gateways       *paymentgateway.Registry
+	refundService  refunds.RefundService
 }
 
 func NewOrderHandler(
 	repo orders.OrderRepository,
 	paymentService payments.PaymentService,
+	checkoutService checkout.CheckoutService,
 	menuClient *orders.MenuClient,
-	paymentGateway paymentgateway.PaymentGateway,
+	gateways *paymentgateway.Registry,
+	refundService refunds.RefundService,
 ) *OrderHandler {
 	return &OrderHandler{
 		repo:           repo,
 		menuClient:     menuClient,
 		paymentService: paymentService,
-		paymentgateway: paymentGateway,
+		checkout:       checkoutService,
+		gateways:       gateways,
+		refundService:  refundService,
 	}
 }
 
 type CreateOrderResponse struct {
-	OrderID   int    `json:"order_id"`
-	URL       string `json:"url"`
-	Total     int    `json:"total"`
-	GatewayID string `json:"gateway_id"`
+	OrderID   int                          `json:"order_id"`
+	Total     int                          `json:"total"`
+	GatewayID string                       `json:"gateway_id"`
+	Action    paymentgateway.PaymentAction `json:"action"`
 }
 
 func (h *OrderHandler) CreateOrderHandler(w http.ResponseWriter, r *http.Request) {
@@ -51,43 +62,57 @@ func (h *OrderHandler) CreateOrderHandler(w http.ResponseWriter, r *http.Request
 
 	orderItems, err := h.buildOrderItems(r.Context(), req.Items)
 	if err != nil {
-		http.Error(w, "failed to build order items: "+err.Error(), http.StatusInternalServerError)
+		var verr *orders.ValidationError
+		switch {
+		case errors.As(err, &verr):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]any{
+				"error":  "invalid order",
+				"fields": verr.Fields,
+			})
+		case errors.Is(err, orders.ErrMenuNotFound):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, orders.ErrMenuUnavailable):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case errors.Is(err, orders.ErrInvalidMenu):
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			http.Error(w, "failed to build order items: "+err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
-	order, err := h.repo.Create(r.Context(), orders.CreateOrderInput{
+	input := orders.CreateOrderInput{
 		CustomerName: req.Customer.Name,
 		Phone:        req.Customer.Phone,
 		Address:      req.Customer.Address,
+		GatewayName:  req.Gateway,
 		Items:        orderItems,
-	})
-	if err != nil {
-		http.Error(w, "failed to create order: "+err.Error(), http.StatusInternalServerError)
-		return
+	}
+	if claims, ok := mw.GetClaims(r.Context()); ok {
+		input.UserID = &claims.UserID
 	}
 
-	url, gatewayID, err := h.paymentgateway.CreatePaymentRequest(r.Context(), order.Total, fmt.Sprint(order.ID))
-	if err != nil {
-		http.Error(w, "Failed to create payment request: "+err.Error(), http.StatusInternalServerError)
+	result, err := h.checkout.PlaceOrder(r.Context(), input, req.PaymentMethod)
+	if errors.Is(err, paymentgateway.ErrUnknownGateway) || errors.Is(err, paymentgateway.ErrUnsupportedPaymentMethod) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, orders.ErrOutOfStock) {
+		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
-
-	_, err = h.paymentService.CreatePayment(r.Context(), payments.CreatePaymentInput{
-		OrderID:     order.ID,
-		ExternalID:  gatewayID,
-		GatewayName: "xendit",
-		Amount:      order.Total,
-	})
 	if err != nil {
-		http.Error(w, "failed to create payment record: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "failed to create order: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	response := CreateOrderResponse{
-		OrderID:   order.ID,
-		URL:       url,
-		Total:     order.Total,
-		GatewayID: gatewayID,
+		OrderID:   result.Order.ID,
+		Total:     result.Order.Total,
+		GatewayID: result.GatewayID,
+		Action:    result.Action,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -102,39 +127,112 @@ func (h *OrderHandler) GetAllOrdersHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
+	query := r.URL.Query()
 
-	limit, err := strconv.Atoi(limitStr)
+	limit, err := strconv.Atoi(query.Get("limit"))
 	if err != nil || limit <= 0 {
 		limit = 10
 	}
+	if limit > 100 {
+		limit = 100
+	}
 
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	filter := orders.OrderFilter{
+		PaymentStatus:     query.Get("payment_status"),
+		FulfillmentStatus: query.Get("fulfillment_status"),
+		Phone:             query.Get("phone"),
+		Name:              query.Get("name"),
+		SortBy:            orders.SortByCreatedAt,
+		SortDesc:          true,
+		Cursor:            query.Get("cursor"),
+		Limit:             limit,
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		filter.SortDesc = strings.HasPrefix(sort, "-")
+		filter.SortBy = strings.TrimPrefix(sort, "-")
 	}
 
-	orders, err := h.repo.GetAll(r.Context(), offset, limit)
+	if filter.CreatedFrom, err = parseDateParam(query.Get("created_from"), false); err != nil {
+		http.Error(w, "invalid created_from", http.StatusBadRequest)
+		return
+	}
+	if filter.CreatedTo, err = parseDateParam(query.Get("created_to"), true); err != nil {
+		http.Error(w, "invalid created_to", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.GetAll(r.Context(), filter)
 	if err != nil {
+		if errors.Is(err, orders.ErrInvalidCursor) || errors.Is(err, orders.ErrInvalidSort) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "failed to get orders: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(orders)
+	json.NewEncoder(w).Encode(page)
+}
+
+// parseDateParam accepts RFC 3339 timestamps or plain dates. A plain date used
+// as an upper bound covers the whole day.
+func parseDateParam(value string, upperBound bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, err
+	}
+	if upperBound {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return &t, nil
 }
 
 func (h *OrderHandler) GetOrdersByUserIdHandler(w http.ResponseWriter, r *http.Request) {
-	userID := 1
-	orders, err := h.repo.GetAllByUserID(r.Context(), userID)
+	claims, ok := mw.GetClaims(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	page, err := h.repo.GetAllByUserID(r.Context(), claims.UserID, orders.UserOrderFilter{
+		PaymentStatus:     query.Get("payment_status"),
+		FulfillmentStatus: query.Get("fulfillment_status"),
+		Limit:             limit,
+		Offset:            offset,
+	})
 	if err != nil {
 		http.Error(w, "failed to get orders: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(orders)
+	json.NewEncoder(w).Encode(page)
 }
 
 func (h *OrderHandler) GetUserOrderDetailsHandler(w http.ResponseWriter, r *http.Request) {
@@ -146,85 +244,249 @@ func (h *OrderHandler) GetUserOrderDetailsHandler(w http.ResponseWriter, r *http
 
 	orderID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "invalid category ID", http.StatusBadRequest)
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
 		return
 	}
 
-	orderItemsRows, err := h.repo.GetUserOrderDetails(r.Context(), claims.UserID, orderID)
+	orderDetail, err := h.repo.GetUserOrderDetails(r.Context(), actorFromClaims(claims), orderID)
 	if err != nil {
-		http.Error(w, "failed to get order details: "+err.Error(), http.StatusInternalServerError)
+		writeOrderError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(orderItemsRows)
+	json.NewEncoder(w).Encode(orderDetail)
+}
+
+func (h *OrderHandler) GetOrdersReadyToPrepareHandler(w http.ResponseWriter, r *http.Request) {
+	h.writeOrderList(w, r, h.repo.GetOrdersReadyToPrepare)
+}
+
+func (h *OrderHandler) GetOrdersInPreparationHandler(w http.ResponseWriter, r *http.Request) {
+	h.writeOrderList(w, r, h.repo.GetOrdersInPreparation)
 }
 
+func (h *OrderHandler) GetOrdersForDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	h.writeOrderList(w, r, h.repo.GetOrdersForDelivery)
+}
+
+func (h *OrderHandler) MarkOrderPreparingHandler(w http.ResponseWriter, r *http.Request) {
+	h.transitionOrder(w, r, h.repo.MarkOrderPreparing)
+}
+
+func (h *OrderHandler) MarkOrderDeliveringHandler(w http.ResponseWriter, r *http.Request) {
+	h.transitionOrder(w, r, h.repo.MarkOrderDelivering)
+}
+
+func (h *OrderHandler) MarkOrderCompletedHandler(w http.ResponseWriter, r *http.Request) {
+	h.transitionOrder(w, r, h.repo.MarkOrderCompleted)
+}
+
+func (h *OrderHandler) CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := mw.GetClaims(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var req orders.CancelOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.repo.CancelOrder(r.Context(), orders.CancelOrderInput{
+		OrderID: orderID,
+		Actor:   actorFromClaims(claims),
+		IsAdmin: claims.Role == mw.RoleAdmin,
+		Reason:  req.Reason,
+	})
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
+
+	// The cancellation is committed with the order in a state the workers
+	// act on: the expiry sweeper voids a pending payment and the refund
+	// worker refunds a captured one. Doing it here first only saves the
+	// customer the wait, so failures are logged and left to them.
+	h.settleCanceledPayment(r.Context(), order, actorFromClaims(claims))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+// settleCanceledPayment voids the pending payment request of a canceled
+// order, or requests the refund of a canceled paid one.
+func (h *OrderHandler) settleCanceledPayment(ctx context.Context, order *orders.Order, actor orders.Actor) {
+	ctx = context.WithoutCancel(ctx)
+
+	payment, err := h.paymentService.GetLatestPaymentByOrderID(ctx, order.ID)
+	if err != nil {
+		if !errors.Is(err, payments.ErrPaymentNotFound) {
+			log.Printf("order %d canceled but failed to load payment: %v", order.ID, err)
+		}
+		return
+	}
+
+	switch {
+	case order.PaymentStatus == "canceled" && payment.Status == "pending":
+		gateway, err := h.gateways.Get(payment.GatewayName)
+		if err != nil {
+			log.Printf("order %d canceled but %v", order.ID, err)
+			return
+		}
+
+		if err := gateway.CancelPaymentRequest(ctx, payment.ExternalID); err != nil {
+			log.Printf("order %d canceled but failed to void payment request: %v", order.ID, err)
+			return
+		}
+
+		if err := h.paymentService.MarkPaymentCanceled(ctx, payment.ExternalID); err != nil {
+			log.Printf("order %d canceled but failed to update payment record: %v", order.ID, err)
+		}
+
+	case order.PaymentStatus == "refund_pending":
+		refund, err := h.refundService.RequestRefund(ctx, refunds.CreateRefundInput{
+			OrderID: order.ID,
+			Reason:  paymentgateway.RefundReasonCancellation,
+			Actor:   actor,
+		})
+		if err != nil {
+			log.Printf("order %d canceled but failed to request refund: %v", order.ID, err)
+			return
+		}
+
+		log.Printf("refund %d requested for canceled order %d", refund.ID, order.ID)
+	}
+}
+
+// RetryPaymentHandler starts a new payment attempt for an order whose payment
+// failed or expired, or replaces the pending one. The previous attempt is
+// superseded and can no longer be paid.
+func (h *OrderHandler) RetryPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := mw.GetClaims(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var req checkout.RetryPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	attempt, err := h.checkout.RetryPayment(r.Context(), checkout.RetryPaymentInput{
+		OrderID: orderID,
+		Method:  req.PaymentMethod,
+		Actor:   actorFromClaims(claims),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, paymentgateway.ErrUnsupportedPaymentMethod):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, checkout.ErrTooManyAttempts),
+			errors.Is(err, checkout.ErrRetryWindowClosed),
+			errors.Is(err, payments.ErrInvalidPaymentStatus),
+			errors.Is(err, payments.ErrAttemptConflict),
+			errors.Is(err, orders.ErrOutOfStock):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, orders.ErrOrderNotFound),
+			errors.Is(err, orders.ErrUnauthorizedAccess),
+			errors.Is(err, orders.ErrInvalidOrderStatus):
+			writeOrderError(w, err)
+		default:
+			http.Error(w, "failed to retry payment: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attempt)
+}
+
+func (h *OrderHandler) writeOrderList(w http.ResponseWriter, r *http.Request, list func(ctx context.Context) ([]*orders.Order, error)) {
+	orders, err := list(r.Context())
+	if err != nil {
+		http.Error(w, "failed to get orders: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orders)
+}
+
+func (h *OrderHandler) transitionOrder(w http.ResponseWriter, r *http.Request, transition func(ctx context.Context, orderId int, actor orders.Actor) error) {
+	claims, ok := mw.GetClaims(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := transition(r.Context(), orderID, actorFromClaims(claims)); err != nil {
+		writeOrderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// actorFromClaims maps a token to an order timeline actor; staff roles act as
+// admin, everyone else as the customer.
+func actorFromClaims(claims *mw.Claims) orders.Actor {
+	source := orders.SourceUser
+	if claims.Role == mw.RoleAdmin || claims.Role == mw.RoleKitchen {
+		source = orders.SourceAdmin
+	}
+
+	return orders.Actor{UserID: claims.UserID, Source: source}
+}
+
+func writeOrderError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, orders.ErrOrderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, orders.ErrUnauthorizedAccess):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, orders.ErrInvalidOrderStatus):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "failed to process order: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// buildOrderItems prices the cart from the menu service. Items keep the order
+// they were requested in.
 func (h *OrderHandler) buildOrderItems(ctx context.Context, items []orders.MenuItemRequest) ([]orders.CreateOrderItemInput, error) {
-	resChan := make(chan orders.CreateOrderItemInput, len(items))
-	errChan := make(chan error, len(items))
-	var wg sync.WaitGroup
-
-	for _, item := range items {
-		wg.Add(1)
-
-		go func(item orders.MenuItemRequest) {
-			defer wg.Done()
-
-			menu, err := h.menuClient.FetchMenu(ctx, int(item.MenuID))
-			if err != nil {
-				errChan <- err
-				return
-			}
-
-			var selectedModifiersItems []orders.CreateOrderItemModifierInput
-			for _, modReq := range item.ModifiersItemsID {
-				found := false
-				for _, group := range menu.ModifierGroups {
-					for _, modItem := range group.Items {
-						if modItem.ID == modReq {
-							selectedModifiersItems = append(selectedModifiersItems, orders.CreateOrderItemModifierInput{
-								ModifierID:        modReq,
-								ModifierItemName:  modItem.Name,
-								ModifierGroupName: group.Name,
-								Price:             int(modItem.Price),
-							})
-							found = true
-							break
-						}
-					}
-					if found {
-						break
-					}
-				}
-				if !found {
-					errChan <- fmt.Errorf("modifier item ID %d not found for menu %s", modReq, menu.Name)
-					return
-				}
-
-			}
-
-			resChan <- orders.CreateOrderItemInput{
-				MenuID:    int(item.MenuID),
-				MenuName:  menu.Name,
-				Quantity:  int(item.Quantity),
-				Price:     int(menu.Price),
-				Modifiers: selectedModifiersItems,
-			}
-		}(item)
-	}
-	wg.Wait()
-	close(resChan)
-	close(errChan)
-
-	if len(errChan) > 0 {
-		return nil, <-errChan
-	}
-
-	var orderItems []orders.CreateOrderItemInput
-	for item := range resChan {
-		orderItems = append(orderItems, item)
-	}
-
-	return orderItems, nil
+	menuIDs := make([]int, len(items))
+	for i, item := range items {
+		menuIDs[i] = int(item.MenuID)
+	}
+
+	menus, err := h.menuClient.FetchMenus(ctx, menuIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return orders.BuildOrderItems(items, menus)
 }
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/outbox"
+)
+
+type OutboxHandler struct {
+	outboxService outbox.OutboxService
+}
+
+func NewOutboxHandler(outboxService outbox.OutboxService) *OutboxHandler {
+	return &OutboxHandler{
+		outboxService: outboxService,
+	}
+}
+
+func (h *OutboxHandler) GetFailedEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	events, err := h.outboxService.GetFailedEvents(r.Context(), limit, offset)
+	if err != nil {
+		http.Error(w, "failed to get outbox events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+func (h *OutboxHandler) ReplayEventHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.outboxService.Replay(r.Context(), id); err != nil {
+		if errors.Is(err, outbox.ErrEventNotReplayable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to replay outbox event: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/refunds"
+	mw "github.com/duniandewon/madkunyah-transactions-service/internal/middleware"
+)
+
+type RefundHandler struct {
+	refundService refunds.RefundService
+}
+
+func NewRefundHandler(refundService refunds.RefundService) *RefundHandler {
+	return &RefundHandler{
+		refundService: refundService,
+	}
+}
+
+// CreateRefundHandler refunds all or part of an order's captured payment.
+// Refunds through gateways that settle them asynchronously are returned as
+// pending and completed by the gateway's callback.
+func (h *RefundHandler) CreateRefundHandler(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var req refunds.CreateRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := mw.GetClaims(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	refund, err := h.refundService.RequestRefund(r.Context(), refunds.CreateRefundInput{
+		OrderID: orderID,
+		Amount:  req.Amount,
+		Reason:  req.Reason,
+		Actor:   actorFromClaims(claims),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, refunds.ErrInvalidRefundAmount), errors.Is(err, refunds.ErrInvalidRefundReason):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, refunds.ErrNotRefundable), errors.Is(err, refunds.ErrExceedsCaptured):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "failed to request refund: "+err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(refund)
+}
+
+func (h *RefundHandler) GetRefundsHandler(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	refunds, err := h.refundService.GetRefundsByOrderID(r.Context(), orderID)
+	if err != nil {
+		http.Error(w, "failed to get refunds: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refunds)
+}
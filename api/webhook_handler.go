@@ -2,117 +2,103 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 
-	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
-	"github.com/duniandewon/madkunyah-transactions-service/internal/features/payments"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/webhooks"
 )
 
+// maxWebhookBody bounds the callback body read into memory.
+const maxWebhookBody = 1 << 20
+
 type WebhookHandler struct {
-	oerderService  orders.OrderRepository
-	paymentService payments.PaymentService
-	webhookSecret  string
+	webhookService webhooks.WebhookService
+	processor      *webhooks.Processor
 }
 
 func NewWebhookHandler(
-	orderService orders.OrderRepository,
-	paymentService payments.PaymentService,
-	webhookSecret string,
+	webhookService webhooks.WebhookService,
+	processor *webhooks.Processor,
 ) *WebhookHandler {
 	return &WebhookHandler{
-		oerderService:  orderService,
-		paymentService: paymentService,
-		webhookSecret:  webhookSecret,
+		webhookService: webhookService,
+		processor:      processor,
 	}
 }
 
-type XenditWebhookPayload struct {
-	Event      string                 `json:"event"`
-	BusinessID string                 `json:"business_id"`
-	Created    string                 `json:"created"`
-	Data       map[string]interface{} `json:"data"`
-}
-
+// XenditPaymentWebhook stores the callback and acknowledges it; the payment
+// is updated asynchronously by the webhook processor. Redeliveries of a
+// stored callback are acknowledged without being stored again. Callers are
+// authenticated by the CallbackAuth middleware.
 func (h *WebhookHandler) XenditPaymentWebhook(w http.ResponseWriter, r *http.Request) {
-	callbackToken := r.Header.Get("X-CALLBACK-TOKEN")
-	if callbackToken != h.webhookSecret {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	var payload XenditWebhookPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
 		http.Error(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 
-	data := payload.Data
-
-	gatewayTransactionID, ok := data["id"].(string)
-	if !ok {
-		fmt.Println("Error: id field missing")
-		http.Error(w, "missing id", http.StatusBadRequest)
+	duplicate, err := h.webhookService.Record(r.Context(), webhooks.NewXenditEvent(r.Header, body))
+	if err != nil {
+		http.Error(w, "failed to store webhook event", http.StatusInternalServerError)
 		return
 	}
-	orderId, ok := data["reference_id"].(string)
-	if !ok {
-		fmt.Println("Error: order_id field missing")
-		http.Error(w, "missing order_id", http.StatusBadRequest)
-		return
+
+	status := "accepted"
+	if duplicate {
+		status = "duplicate"
+	} else {
+		h.processor.Notify()
 	}
-	paymentRequestId, ok := data["payment_request_id"].(string)
-	if !ok {
-		fmt.Println("Error: payment_request_id field missing")
-		http.Error(w, "missing payment_request_id", http.StatusBadRequest)
-		return
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+func (h *WebhookHandler) GetFailedEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
 	}
-	status, ok := data["status"].(string)
-	if !ok {
-		fmt.Println("Warning: status field missing or invalid")
-		status = "unknown"
+	if limit > 100 {
+		limit = 100
 	}
-	channelCode, ok := data["channel_code"].(string)
-	if !ok {
-		fmt.Println("Warning: channel_code field missing or invalid")
-		channelCode = "unknown"
+
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
 	}
 
-	orderIdInt, err := strconv.Atoi(orderId)
+	events, err := h.webhookService.GetFailedEvents(r.Context(), limit, offset)
 	if err != nil {
-		fmt.Println("Error: order_id is not a valid integer")
-		http.Error(w, "invalid order_id", http.StatusBadRequest)
+		http.Error(w, "failed to get webhook events: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var internalStatus string
-	switch status {
-	case "SUCCEEDED", "COMPLETED":
-		internalStatus = "paid"
-	case "EXPIRED":
-		internalStatus = "expired"
-	case "FAILED":
-		internalStatus = "failed"
-	default:
-		internalStatus = "pending"
-	}
-
-	fmt.Printf("Payment Status: %s,payment method: %s, payment request ID: %s, payment internal status: %v\n", status, channelCode, paymentRequestId, internalStatus)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
 
-	if err := h.paymentService.UpdatePaymentStatus(r.Context(), payments.UpdatePaymentStatusInput{
-		OrderID:              orderIdInt,
-		PaymentRequestID:     paymentRequestId,
-		PaymentChannel:       channelCode,
-		GatewayTransactionID: gatewayTransactionID,
-		Status:               internalStatus,
-	}); err != nil {
-		fmt.Printf("Error updating payment status: %v\n", err)
+func (h *WebhookHandler) ReplayEventHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid event ID", http.StatusBadRequest)
+		return
+	}
 
-		http.Error(w, "failed to update payment status", http.StatusInternalServerError)
+	if err := h.webhookService.Replay(r.Context(), id); err != nil {
+		if errors.Is(err, webhooks.ErrEventNotReplayable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to replay webhook event: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.processor.Notify()
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	w.WriteHeader(http.StatusAccepted)
 }
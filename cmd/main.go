@@ -3,32 +3,58 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/duniandewon/madkunyah-transactions-service/api"
 	"github.com/duniandewon/madkunyah-transactions-service/internal/config"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/checkout"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/idempotency"
 	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/outbox"
 	"github.com/duniandewon/madkunyah-transactions-service/internal/features/payments"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/reconciliation"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/refunds"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/webhooks"
 	mw "github.com/duniandewon/madkunyah-transactions-service/internal/middleware"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/cache"
 	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
 	postgresql "github.com/duniandewon/madkunyah-transactions-service/internal/platform/postgres"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// worker is a background job started alongside the HTTP server.
+type worker interface {
+	Run(ctx context.Context)
+}
+
 type application struct {
-	env *config.Env
-	db  *sql.DB
+	env     *config.Env
+	db      *sql.DB
+	cache   cache.Cache
+	workers []worker
 }
 
 func (app *application) mount() http.Handler {
+	realIP, err := mw.RealIP(app.env.TrustedProxies)
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
@@ -45,33 +71,237 @@ func (app *application) mount() http.Handler {
 		w.Write([]byte("System Healthy"))
 	})
 
-	xenditClient := paymentgateway.NewXenditGateway(app.env.XenditKey)
-	menuClient := orders.NewMenuClient("http://localhost:5002")
+	gateways, fakeGateway := app.paymentGateways()
+	menuClient := orders.NewMenuClient(app.env.MenuServiceUrl, orders.MenuClientOptions{
+		Timeout:          app.env.MenuServiceTimeout,
+		Token:            app.env.MenuServiceToken,
+		MaxRetries:       app.env.MenuMaxRetries,
+		BreakerThreshold: app.env.MenuBreakerThreshold,
+		BreakerCooldown:  app.env.MenuBreakerCooldown,
+		MaxConcurrency:   app.env.MenuMaxConcurrency,
+		Cache:            app.cache,
+		CacheTTL:         app.env.MenuCacheTTL,
+	})
+
+	// The menu service being down only breaks checkout, so it does not stop
+	// the server from starting; /ready reports it until it is reachable.
+	startupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := menuClient.Ping(startupCtx); err != nil {
+		log.Printf("menu service at %s is not reachable: %v", app.env.MenuServiceUrl, err)
+	}
+	cancel()
+
+	r.Get("/ready", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		if err := app.db.PingContext(ctx); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Database Down"))
+			return
+		}
+		if err := menuClient.Ping(ctx); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Menu Service Down"))
+			return
+		}
+
+		w.Write([]byte("System Ready"))
+	})
 
 	paymentService := payments.NewService(app.db)
-	orderRepo := orders.NewService(app.db)
+	orderRepo := orders.NewService(app.db, app.env.StockHold)
 
-	orderHandler := api.NewOrderHandler(orderRepo, paymentService, menuClient, xenditClient)
+	idempotencyStore := idempotency.NewService(app.db, app.env.IdempotencyKeyTTL, app.env.IdempotencyLease)
+	app.workers = append(app.workers, idempotency.NewPurger(idempotencyStore, app.env.IdempotencyPurgeInterval))
+
+	checkoutService := checkout.NewService(
+		orderRepo,
+		paymentService,
+		gateways,
+		app.env.PaymentTTL,
+		app.env.PaymentMaxAttempts,
+		app.env.PaymentRetryWindow,
+	)
+	app.workers = append(app.workers, checkout.NewWorker(
+		checkoutService,
+		app.env.CheckoutWorkerInterval,
+		app.env.CheckoutStaleAfter,
+		app.env.CheckoutMaxAttempts,
+	))
+	app.workers = append(app.workers, payments.NewExpirySweeper(
+		paymentService,
+		gateways,
+		app.env.PaymentExpiryInterval,
+		app.env.PaymentExpiryGrace,
+	))
+	app.workers = append(app.workers, orders.NewStockSweeper(orderRepo, app.env.StockSweepInterval))
+	app.workers = append(app.workers, reconciliation.NewWorker(
+		reconciliation.NewService(app.db, paymentService, gateways),
+		app.env.ReconciliationInterval,
+		app.env.ReconciliationWindow,
+		app.env.ReconciliationApply,
+	))
+
+	publisher, err := app.outboxPublisher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.workers = append(app.workers, outbox.NewRelay(
+		app.db,
+		publisher,
+		app.env.OutboxRelayInterval,
+		app.env.OutboxMaxAttempts,
+	))
+
+	refundService := refunds.NewService(app.db, gateways)
+	app.workers = append(app.workers, refunds.NewWorker(
+		refundService,
+		app.env.RefundWorkerInterval,
+		app.env.RefundResendAfter,
+		app.env.RefundMaxAttempts,
+	))
+
+	orderHandler := api.NewOrderHandler(orderRepo, paymentService, checkoutService, menuClient, gateways, refundService)
 
 	r.Route("/orders", func(r chi.Router) {
-		r.Post("/", orderHandler.CreateOrderHandler)
+		r.With(
+			mw.OptionalAuth(app.env.JwtSecret),
+			mw.Idempotency(idempotencyStore),
+		).Post("/", orderHandler.CreateOrderHandler)
 
 		r.Group(func(r chi.Router) {
 			r.Use(mw.IsAuth(app.env.JwtSecret))
 
 			r.Get("/", orderHandler.GetAllOrdersHandler)
+			r.Get("/me", orderHandler.GetOrdersByUserIdHandler)
 			r.Get("/{id}", orderHandler.GetUserOrderDetailsHandler)
+			r.Post("/{id}/cancel", orderHandler.CancelOrderHandler)
+			r.Post("/{id}/payments", orderHandler.RetryPaymentHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(mw.HasRole(mw.RoleAdmin, mw.RoleKitchen))
+
+				r.Get("/kitchen/ready", orderHandler.GetOrdersReadyToPrepareHandler)
+				r.Get("/kitchen/preparing", orderHandler.GetOrdersInPreparationHandler)
+				r.Get("/kitchen/delivering", orderHandler.GetOrdersForDeliveryHandler)
+
+				r.Post("/{id}/prepare", orderHandler.MarkOrderPreparingHandler)
+				r.Post("/{id}/deliver", orderHandler.MarkOrderDeliveringHandler)
+				r.Post("/{id}/complete", orderHandler.MarkOrderCompletedHandler)
+			})
 		})
 	})
 
-	xenditWebhooks := api.NewWebhookHandler(orderRepo, paymentService, app.env.XenditWebhookKey)
+	r.With(
+		mw.IsAuth(app.env.JwtSecret),
+		mw.HasRole(mw.RoleAdmin),
+	).Handle("/debug/vars", expvar.Handler())
+
+	webhookProcessor := webhooks.NewProcessor(
+		app.db,
+		app.env.WebhookProcessInterval,
+		app.env.WebhookMaxAttempts,
+		app.env.WebhookUnmatchedWindow,
+	)
+	webhookProcessor.Register(webhooks.ProviderXendit, webhooks.NewXenditHandler(paymentService, refundService))
+	app.workers = append(app.workers, webhookProcessor)
+
+	xenditWebhooks := api.NewWebhookHandler(webhooks.NewService(app.db), webhookProcessor)
+	refundHandler := api.NewRefundHandler(refundService)
+	outboxHandler := api.NewOutboxHandler(outbox.NewService(app.db))
+
+	xenditCallbackAuth, err := mw.CallbackAuth(
+		webhooks.ProviderXendit,
+		"X-CALLBACK-TOKEN",
+		[]mw.CallbackToken{
+			{Value: app.env.XenditWebhookKey},
+			{Value: app.env.XenditWebhookPreviousKey, ExpiresAt: app.env.XenditWebhookPreviousKeyExpiresAt},
+		},
+		app.env.XenditWebhookAllowedIPs,
+	)
+	if err != nil {
+		log.Fatalf("XENDIT_WEBHOOK_ALLOWED_IPS: %v", err)
+	}
+
+	r.With(xenditCallbackAuth).Post("/webhooks/xendit", xenditWebhooks.XenditPaymentWebhook)
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(mw.IsAuth(app.env.JwtSecret))
+		r.Use(mw.HasRole(mw.RoleAdmin))
+
+		r.Get("/webhook-events/failed", xenditWebhooks.GetFailedEventsHandler)
+		r.Post("/webhook-events/{id}/replay", xenditWebhooks.ReplayEventHandler)
+
+		r.Get("/outbox-events/failed", outboxHandler.GetFailedEventsHandler)
+		r.Post("/outbox-events/{id}/replay", outboxHandler.ReplayEventHandler)
+
+		r.Get("/orders/{id}/refunds", refundHandler.GetRefundsHandler)
+		r.Post("/orders/{id}/refunds", refundHandler.CreateRefundHandler)
+	})
+
+	if fakeGateway != nil {
+		devPayments := api.NewDevPaymentHandler(fakeGateway)
 
-	r.Post("/webhooks/xendit", xenditWebhooks.XenditPaymentWebhook)
+		r.Route("/dev/payments", func(r chi.Router) {
+			r.Get("/", devPayments.ListPaymentsHandler)
+			r.Post("/{id}/{action}", devPayments.SimulatePaymentHandler)
+		})
+	}
 
 	return r
 }
 
-func (app *application) run(h http.Handler) error {
+// paymentGateways registers the configured gateways. The fake gateway is only
+// available when explicitly selected, and is returned so the dev endpoints
+// that drive it can be mounted.
+func (app *application) paymentGateways() (*paymentgateway.Registry, *paymentgateway.FakeGateway) {
+	gateways := paymentgateway.NewRegistry(app.env.PaymentGateway)
+	if app.env.XenditKey != "" {
+		gateways.Register("xendit", paymentgateway.NewXenditGateway(
+			app.env.XenditKey,
+			app.env.PaymentSuccessUrl,
+			app.env.PaymentFailureUrl,
+		))
+	}
+
+	var fakeGateway *paymentgateway.FakeGateway
+	if app.env.PaymentGateway == "fake" {
+		callbackURL := app.env.FakeGatewayCallbackUrl
+		if callbackURL == "" {
+			callbackURL = fmt.Sprintf("http://localhost:%s/webhooks/xendit", app.env.Port)
+		}
+
+		fakeGateway = paymentgateway.NewFakeGateway(callbackURL, app.env.XenditWebhookKey)
+		gateways.Register("fake", fakeGateway)
+	}
+
+	if _, err := gateways.Get(""); err != nil {
+		log.Fatalf("PAYMENT_GATEWAY: %v", err)
+	}
+
+	return gateways, fakeGateway
+}
+
+func (app *application) outboxPublisher() (outbox.Publisher, error) {
+	switch app.env.OutboxPublisher {
+	case "log":
+		return outbox.NewLogPublisher(), nil
+	case "webhook":
+		if app.env.OutboxWebhookUrl == "" {
+			return nil, fmt.Errorf("OUTBOX_WEBHOOK_URL is required for the webhook publisher")
+		}
+		return outbox.NewWebhookPublisher(
+			app.env.OutboxWebhookUrl,
+			app.env.OutboxWebhookSecret,
+			app.env.OutboxWebhookTimeout,
+		), nil
+	default:
+		return nil, fmt.Errorf("unknown outbox publisher %q", app.env.OutboxPublisher)
+	}
+}
+
+func (app *application) run(ctx context.Context, h http.Handler) error {
 	srv := &http.Server{
 		Handler:      h,
 		Addr:         fmt.Sprintf(":%v", app.env.Port),
@@ -80,9 +310,73 @@ func (app *application) run(h http.Handler) error {
 		IdleTimeout:  time.Minute,
 	}
 
-	log.Printf("Server started port: %s", app.env.Port)
+	for _, w := range app.workers {
+		go w.Run(ctx)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Server started port: %s", app.env.Port)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return srv.Shutdown(shutdownCtx)
+}
+
+// reconcile runs a single reconciliation for the range given on the command
+// line and prints the report, e.g.
+//
+//	go run ./cmd reconcile -from 2026-01-01 -to 2026-01-02 -apply
+func (app *application) reconcile(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	from := flags.String("from", "", "start of the range, as a date or RFC 3339 time (required)")
+	to := flags.String("to", "", "end of the range, exclusive; defaults to now")
+	apply := flags.Bool("apply", false, "move pending payments to the state the gateway reports")
+	flags.Parse(args)
+
+	input := reconciliation.RunInput{
+		To:    time.Now(),
+		Apply: *apply,
+	}
+
+	var err error
+	if input.From, err = parseTime(*from); err != nil {
+		return fmt.Errorf("-from: %w", err)
+	}
+	if *to != "" {
+		if input.To, err = parseTime(*to); err != nil {
+			return fmt.Errorf("-to: %w", err)
+		}
+	}
+
+	gateways, _ := app.paymentGateways()
+	service := reconciliation.NewService(app.db, payments.NewService(app.db), gateways)
+
+	run, err := service.Reconcile(ctx, input)
+	if run != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(run)
+	}
+	return err
+}
 
-	return srv.ListenAndServe()
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
 }
 
 func main() {
@@ -94,12 +388,33 @@ func main() {
 	}
 	defer db.Close()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	api := application{
-		env: env,
-		db:  db,
+		env:   env,
+		db:    db,
+		cache: cache.NewMemory(),
+	}
+
+	if env.RedisUrl != "" {
+		redisCache, err := cache.NewRedis(ctx, env.RedisUrl, "transactions:")
+		if err != nil {
+			log.Fatalf("Could not connect to redis: %v", err)
+		}
+		defer redisCache.Close()
+
+		api.cache = redisCache
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		if err := api.reconcile(ctx, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	if err := api.run(api.mount()); err != nil {
+	if err := api.run(ctx, api.mount()); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
 }
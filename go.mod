@@ -9,4 +9,14 @@ require (
 	github.com/lib/pq v1.10.9
 )
 
-require github.com/xendit/xendit-go/v7 v7.0.0
+require (
+	github.com/redis/go-redis/v9 v9.22.0
+	github.com/xendit/xendit-go/v7 v7.0.0
+	golang.org/x/sync v0.17.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
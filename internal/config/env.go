@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,6 +17,69 @@ type Env struct {
 	JwtSecret        string
 	XenditKey        string
 	XenditWebhookKey string
+	PaymentGateway   string
+
+	// TrustedProxies lists the proxies whose X-Forwarded-For and X-Real-IP
+	// headers name the client. Requests from anywhere else keep the address
+	// of the connection.
+	TrustedProxies []string
+
+	// XenditWebhookPreviousKey is accepted alongside XenditWebhookKey until
+	// XenditWebhookPreviousKeyExpiresAt while the callback token is rotated.
+	XenditWebhookPreviousKey          string
+	XenditWebhookPreviousKeyExpiresAt time.Time
+	XenditWebhookAllowedIPs           []string
+
+	PaymentSuccessUrl string
+	PaymentFailureUrl string
+
+	FakeGatewayCallbackUrl string
+
+	PaymentTTL            time.Duration
+	PaymentExpiryInterval time.Duration
+	PaymentExpiryGrace    time.Duration
+	PaymentMaxAttempts    int
+	PaymentRetryWindow    time.Duration
+
+	ReconciliationInterval time.Duration
+	ReconciliationWindow   time.Duration
+	ReconciliationApply    bool
+
+	WebhookProcessInterval time.Duration
+	WebhookMaxAttempts     int
+	WebhookUnmatchedWindow time.Duration
+
+	IdempotencyKeyTTL        time.Duration
+	IdempotencyLease         time.Duration
+	IdempotencyPurgeInterval time.Duration
+
+	CheckoutWorkerInterval time.Duration
+	CheckoutStaleAfter     time.Duration
+	CheckoutMaxAttempts    int
+
+	OutboxPublisher      string
+	OutboxWebhookUrl     string
+	OutboxWebhookSecret  string
+	OutboxWebhookTimeout time.Duration
+	OutboxRelayInterval  time.Duration
+	OutboxMaxAttempts    int
+
+	MenuServiceUrl     string
+	MenuServiceTimeout time.Duration
+	MenuServiceToken   string
+
+	MenuCacheTTL         time.Duration
+	MenuMaxRetries       int
+	MenuBreakerThreshold int
+	MenuBreakerCooldown  time.Duration
+	MenuMaxConcurrency   int
+
+	StockHold          time.Duration
+	StockSweepInterval time.Duration
+
+	RefundWorkerInterval time.Duration
+	RefundResendAfter    time.Duration
+	RefundMaxAttempts    int
 }
 
 func getEnv(key string) string {
@@ -24,15 +90,167 @@ func getEnv(key string) string {
 	return val
 }
 
+func getEnvDefault(key, fallback string) string {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	return val
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be a duration: %v", key, err)
+	}
+	return d
+}
+
+func getEnvInt(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be an integer: %v", key, err)
+	}
+	return n
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be a boolean: %v", key, err)
+	}
+	return b
+}
+
+// getEnvTime parses an RFC 3339 timestamp. Unset values are the zero time.
+func getEnvTime(key string) time.Time {
+	val := os.Getenv(key)
+	if val == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be an RFC 3339 time: %v", key, err)
+	}
+	return t
+}
+
+// getEnvList splits a comma-separated value, dropping empty entries.
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func NewEnv() *Env {
 	godotenv.Load()
 
-	return &Env{
+	if os.Getenv("XENDIT_WEBHOOK_PREVIOUS_KEY") != "" && os.Getenv("XENDIT_WEBHOOK_PREVIOUS_KEY_EXPIRES_AT") == "" {
+		log.Fatal("Environment variable XENDIT_WEBHOOK_PREVIOUS_KEY_EXPIRES_AT is required with XENDIT_WEBHOOK_PREVIOUS_KEY")
+	}
+
+	env := &Env{
 		Port:             getEnv("PORT"),
 		DatabaseUrl:      getEnv("DATABASE_URL"),
-		RedisUrl:         getEnv("REDIS_URL"),
+		RedisUrl:         os.Getenv("REDIS_URL"),
 		JwtSecret:        getEnv("JWT_SECRET"),
-		XenditKey:        getEnv("XENDIT_SECRET_KEY"),
+		XenditKey:        os.Getenv("XENDIT_SECRET_KEY"),
 		XenditWebhookKey: getEnv("XENDIT_WEBHOOK_KEY"),
+		PaymentGateway:   getEnvDefault("PAYMENT_GATEWAY", "xendit"),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+
+		XenditWebhookPreviousKey:          os.Getenv("XENDIT_WEBHOOK_PREVIOUS_KEY"),
+		XenditWebhookPreviousKeyExpiresAt: getEnvTime("XENDIT_WEBHOOK_PREVIOUS_KEY_EXPIRES_AT"),
+		XenditWebhookAllowedIPs:           getEnvList("XENDIT_WEBHOOK_ALLOWED_IPS"),
+
+		PaymentSuccessUrl: os.Getenv("PAYMENT_SUCCESS_URL"),
+		PaymentFailureUrl: os.Getenv("PAYMENT_FAILURE_URL"),
+
+		FakeGatewayCallbackUrl: os.Getenv("FAKE_GATEWAY_CALLBACK_URL"),
+
+		PaymentTTL:            getEnvDuration("PAYMENT_TTL", 15*time.Minute),
+		PaymentExpiryInterval: getEnvDuration("PAYMENT_EXPIRY_INTERVAL", time.Minute),
+		PaymentExpiryGrace:    getEnvDuration("PAYMENT_EXPIRY_GRACE", 2*time.Minute),
+		PaymentMaxAttempts:    getEnvInt("PAYMENT_MAX_ATTEMPTS", 3),
+		PaymentRetryWindow:    getEnvDuration("PAYMENT_RETRY_WINDOW", time.Hour),
+
+		ReconciliationInterval: getEnvDuration("RECONCILIATION_INTERVAL", 24*time.Hour),
+		ReconciliationWindow:   getEnvDuration("RECONCILIATION_WINDOW", 24*time.Hour),
+		ReconciliationApply:    getEnvBool("RECONCILIATION_APPLY", false),
+
+		WebhookProcessInterval: getEnvDuration("WEBHOOK_PROCESS_INTERVAL", 5*time.Second),
+		WebhookMaxAttempts:     getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookUnmatchedWindow: getEnvDuration("WEBHOOK_UNMATCHED_WINDOW", 15*time.Minute),
+
+		IdempotencyKeyTTL:        getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		IdempotencyLease:         getEnvDuration("IDEMPOTENCY_LEASE", time.Minute),
+		IdempotencyPurgeInterval: getEnvDuration("IDEMPOTENCY_PURGE_INTERVAL", time.Hour),
+
+		CheckoutWorkerInterval: getEnvDuration("CHECKOUT_WORKER_INTERVAL", 30*time.Second),
+		CheckoutStaleAfter:     getEnvDuration("CHECKOUT_STALE_AFTER", 2*time.Minute),
+		CheckoutMaxAttempts:    getEnvInt("CHECKOUT_MAX_ATTEMPTS", 5),
+
+		OutboxPublisher:      getEnvDefault("OUTBOX_PUBLISHER", "log"),
+		OutboxWebhookUrl:     os.Getenv("OUTBOX_WEBHOOK_URL"),
+		OutboxWebhookSecret:  os.Getenv("OUTBOX_WEBHOOK_SECRET"),
+		OutboxWebhookTimeout: getEnvDuration("OUTBOX_WEBHOOK_TIMEOUT", 10*time.Second),
+		OutboxRelayInterval:  getEnvDuration("OUTBOX_RELAY_INTERVAL", 2*time.Second),
+		OutboxMaxAttempts:    getEnvInt("OUTBOX_MAX_ATTEMPTS", 10),
+
+		MenuServiceUrl:     getEnv("MENU_SERVICE_URL"),
+		MenuServiceTimeout: getEnvDuration("MENU_SERVICE_TIMEOUT", 5*time.Second),
+		MenuServiceToken:   os.Getenv("MENU_SERVICE_TOKEN"),
+
+		MenuCacheTTL:         getEnvDuration("MENU_CACHE_TTL", 5*time.Minute),
+		MenuMaxRetries:       getEnvInt("MENU_MAX_RETRIES", 2),
+		MenuBreakerThreshold: getEnvInt("MENU_BREAKER_THRESHOLD", 5),
+		MenuBreakerCooldown:  getEnvDuration("MENU_BREAKER_COOLDOWN", 30*time.Second),
+		MenuMaxConcurrency:   getEnvInt("MENU_MAX_CONCURRENCY", 4),
+
+		StockHold:          getEnvDuration("STOCK_HOLD", 30*time.Minute),
+		StockSweepInterval: getEnvDuration("STOCK_SWEEP_INTERVAL", time.Minute),
+
+		RefundWorkerInterval: getEnvDuration("REFUND_WORKER_INTERVAL", time.Minute),
+		RefundResendAfter:    getEnvDuration("REFUND_RESEND_AFTER", 5*time.Minute),
+		RefundMaxAttempts:    getEnvInt("REFUND_MAX_ATTEMPTS", 3),
+	}
+
+	// Stock must stay held for as long as a pending payment can still be
+	// captured, or the sweeper hands it to other orders first. A payment
+	// that never expires could be captured after any hold.
+	if env.PaymentTTL <= 0 {
+		log.Fatal("Environment variable PAYMENT_TTL must be positive, since stock is only held for a limited time")
 	}
+	if env.StockHold < env.PaymentTTL+env.PaymentExpiryGrace {
+		log.Fatal("Environment variable STOCK_HOLD must be at least PAYMENT_TTL plus PAYMENT_EXPIRY_GRACE")
+	}
+
+	// A payment callback can arrive before its checkout stored the payment;
+	// it has to be retried until the checkout worker resolved the checkout.
+	if env.WebhookUnmatchedWindow < env.CheckoutStaleAfter+env.CheckoutWorkerInterval {
+		log.Fatal("Environment variable WEBHOOK_UNMATCHED_WINDOW must be at least CHECKOUT_STALE_AFTER plus CHECKOUT_WORKER_INTERVAL")
+	}
+
+	return env
 }
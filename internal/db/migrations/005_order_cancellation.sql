@@ -0,0 +1,59 @@
+-- +goose up
+ALTER TABLE orders
+    ADD COLUMN canceled_by INTEGER,
+    ADD COLUMN cancel_reason TEXT,
+    ADD COLUMN canceled_at TIMESTAMP;
+
+ALTER TABLE orders DROP CONSTRAINT orders_payment_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_payment_status_check CHECK (
+    payment_status IN ('pending', 'paid', 'failed', 'expired', 'canceled', 'refund_pending')
+);
+
+ALTER TABLE orders DROP CONSTRAINT chk_payment_fulfillment;
+ALTER TABLE orders ADD CONSTRAINT chk_payment_fulfillment CHECK (
+    (
+        payment_status = 'pending'
+        AND fulfillment_status = 'new'
+    )
+    OR (
+        payment_status = 'paid'
+        AND fulfillment_status IN ('new', 'preparing', 'delivering', 'completed')
+    )
+    OR (
+        payment_status IN ('canceled', 'refund_pending')
+        AND fulfillment_status = 'canceled'
+    )
+);
+
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (
+    status IN ('pending', 'paid', 'failed', 'expired', 'settled', 'canceled')
+);
+
+-- +goose down
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (
+    status IN ('pending', 'paid', 'failed', 'expired', 'settled')
+);
+
+ALTER TABLE orders DROP CONSTRAINT chk_payment_fulfillment;
+ALTER TABLE orders ADD CONSTRAINT chk_payment_fulfillment CHECK (
+    (
+        payment_status = 'pending'
+        AND fulfillment_status = 'new'
+    )
+    OR (
+        payment_status = 'paid'
+        AND fulfillment_status IN ('new', 'preparing', 'delivering', 'completed')
+    )
+);
+
+ALTER TABLE orders DROP CONSTRAINT orders_payment_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_payment_status_check CHECK (
+    payment_status IN ('pending', 'paid', 'failed', 'expired')
+);
+
+ALTER TABLE orders
+    DROP COLUMN canceled_at,
+    DROP COLUMN cancel_reason,
+    DROP COLUMN canceled_by;
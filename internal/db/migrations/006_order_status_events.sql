@@ -0,0 +1,18 @@
+-- +goose up
+CREATE TABLE IF NOT EXISTS order_status_events (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    from_payment_status VARCHAR(20),
+    to_payment_status VARCHAR(20) NOT NULL,
+    from_fulfillment_status VARCHAR(20),
+    to_fulfillment_status VARCHAR(20) NOT NULL,
+    actor_id INTEGER,
+    source VARCHAR(20) NOT NULL CHECK (
+        source IN ('user', 'admin', 'webhook', 'system')
+    ),
+    note TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_order_status_events_order_id ON order_status_events(order_id);
+-- +goose down
+DROP TABLE order_status_events;
@@ -0,0 +1,15 @@
+-- +goose up
+CREATE TABLE IF NOT EXISTS checkout_sagas (
+    order_id INTEGER PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'started' CHECK (
+        status IN ('started', 'completed', 'compensated')
+    ),
+    gateway_name VARCHAR(50) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_checkout_sagas_status_updated_at ON checkout_sagas(status, updated_at);
+-- +goose down
+DROP TABLE checkout_sagas;
@@ -0,0 +1,5 @@
+-- +goose up
+ALTER TABLE payments
+ADD COLUMN payment_method VARCHAR(30);
+-- +goose down
+ALTER TABLE payments DROP COLUMN payment_method;
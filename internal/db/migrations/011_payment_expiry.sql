@@ -0,0 +1,8 @@
+-- +goose up
+ALTER TABLE payments
+ADD COLUMN expires_at TIMESTAMP;
+CREATE INDEX idx_payments_pending_expires_at ON payments(expires_at)
+WHERE status = 'pending';
+-- +goose down
+DROP INDEX idx_payments_pending_expires_at;
+ALTER TABLE payments DROP COLUMN expires_at;
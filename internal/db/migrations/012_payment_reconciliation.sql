@@ -0,0 +1,38 @@
+-- +goose up
+CREATE TABLE IF NOT EXISTS reconciliation_runs (
+    id BIGSERIAL PRIMARY KEY,
+    range_start TIMESTAMP NOT NULL,
+    range_end TIMESTAMP NOT NULL,
+    apply BOOLEAN NOT NULL DEFAULT FALSE,
+    status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (
+        status IN ('running', 'completed', 'failed')
+    ),
+    checked INTEGER NOT NULL DEFAULT 0,
+    mismatches INTEGER NOT NULL DEFAULT 0,
+    errors INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS reconciliation_mismatches (
+    id BIGSERIAL PRIMARY KEY,
+    run_id BIGINT NOT NULL REFERENCES reconciliation_runs(id) ON DELETE CASCADE,
+    kind VARCHAR(30) NOT NULL,
+    order_id INTEGER REFERENCES orders(id) ON DELETE CASCADE,
+    payment_id INTEGER REFERENCES payments(id) ON DELETE CASCADE,
+    gateway_name VARCHAR(50) NOT NULL,
+    gateway_id VARCHAR(255) NOT NULL,
+    local_status VARCHAR(20),
+    gateway_status VARCHAR(20),
+    local_amount INTEGER,
+    gateway_amount INTEGER,
+    applied BOOLEAN NOT NULL DEFAULT FALSE,
+    apply_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_reconciliation_mismatches_run_id ON reconciliation_mismatches(run_id);
+CREATE INDEX idx_payments_created_at ON payments(created_at);
+-- +goose down
+DROP INDEX idx_payments_created_at;
+DROP TABLE reconciliation_mismatches;
+DROP TABLE reconciliation_runs;
@@ -0,0 +1,25 @@
+-- +goose up
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id BIGSERIAL PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    dedupe_key VARCHAR(255) NOT NULL,
+    event_type VARCHAR(100) NOT NULL,
+    reference VARCHAR(255) NOT NULL,
+    headers JSONB NOT NULL,
+    body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (
+        status IN ('pending', 'processed', 'failed')
+    ),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    available_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP,
+    CONSTRAINT uq_webhook_events_dedupe UNIQUE (provider, dedupe_key)
+);
+CREATE INDEX idx_webhook_events_pending ON webhook_events(provider, reference, id)
+WHERE status = 'pending';
+CREATE INDEX idx_webhook_events_failed ON webhook_events(received_at)
+WHERE status = 'failed';
+-- +goose down
+DROP TABLE webhook_events;
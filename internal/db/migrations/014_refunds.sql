@@ -0,0 +1,79 @@
+-- +goose up
+CREATE TABLE IF NOT EXISTS refunds (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    payment_id INTEGER NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    gateway_name VARCHAR(50) NOT NULL,
+    gateway_refund_id VARCHAR(255) UNIQUE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    reason VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (
+        status IN ('pending', 'succeeded', 'failed')
+    ),
+    failure_code VARCHAR(100),
+    requested_by INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+CREATE INDEX idx_refunds_order_id ON refunds(order_id);
+CREATE INDEX idx_refunds_payment_id ON refunds(payment_id);
+
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (
+    status IN ('pending', 'paid', 'failed', 'expired', 'settled', 'canceled', 'partially_refunded', 'refunded')
+);
+
+ALTER TABLE orders DROP CONSTRAINT orders_payment_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_payment_status_check CHECK (
+    payment_status IN ('pending', 'paid', 'failed', 'expired', 'canceled', 'refund_pending', 'partially_refunded', 'refunded')
+);
+
+ALTER TABLE orders DROP CONSTRAINT chk_payment_fulfillment;
+ALTER TABLE orders ADD CONSTRAINT chk_payment_fulfillment CHECK (
+    (
+        payment_status = 'pending'
+        AND fulfillment_status = 'new'
+    )
+    OR (
+        payment_status = 'paid'
+        AND fulfillment_status IN ('new', 'preparing', 'delivering', 'completed')
+    )
+    OR (
+        payment_status IN ('canceled', 'refund_pending')
+        AND fulfillment_status = 'canceled'
+    )
+    OR (
+        payment_status IN ('partially_refunded', 'refunded')
+        AND fulfillment_status IN ('new', 'preparing', 'delivering', 'completed', 'canceled')
+    )
+);
+
+-- +goose down
+ALTER TABLE orders DROP CONSTRAINT chk_payment_fulfillment;
+ALTER TABLE orders ADD CONSTRAINT chk_payment_fulfillment CHECK (
+    (
+        payment_status = 'pending'
+        AND fulfillment_status = 'new'
+    )
+    OR (
+        payment_status = 'paid'
+        AND fulfillment_status IN ('new', 'preparing', 'delivering', 'completed')
+    )
+    OR (
+        payment_status IN ('canceled', 'refund_pending')
+        AND fulfillment_status = 'canceled'
+    )
+);
+
+ALTER TABLE orders DROP CONSTRAINT orders_payment_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_payment_status_check CHECK (
+    payment_status IN ('pending', 'paid', 'failed', 'expired', 'canceled', 'refund_pending')
+);
+
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (
+    status IN ('pending', 'paid', 'failed', 'expired', 'settled', 'canceled')
+);
+
+DROP TABLE refunds;
@@ -0,0 +1,29 @@
+-- +goose up
+ALTER TABLE payments ADD COLUMN attempt INTEGER NOT NULL DEFAULT 1;
+
+UPDATE payments p
+SET attempt = ranked.attempt
+FROM (
+    SELECT id,
+      ROW_NUMBER() OVER (PARTITION BY order_id ORDER BY created_at, id) AS attempt
+    FROM payments
+  ) ranked
+WHERE p.id = ranked.id;
+
+ALTER TABLE payments ADD CONSTRAINT uq_payments_order_attempt UNIQUE (order_id, attempt);
+
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (
+    status IN ('pending', 'paid', 'failed', 'expired', 'settled', 'canceled', 'partially_refunded', 'refunded', 'superseded')
+);
+
+-- +goose down
+UPDATE payments SET status = 'canceled' WHERE status = 'superseded';
+
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (
+    status IN ('pending', 'paid', 'failed', 'expired', 'settled', 'canceled', 'partially_refunded', 'refunded')
+);
+
+ALTER TABLE payments DROP CONSTRAINT uq_payments_order_attempt;
+ALTER TABLE payments DROP COLUMN attempt;
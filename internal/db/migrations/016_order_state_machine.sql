@@ -0,0 +1,45 @@
+-- +goose up
+-- Matches orders.validStates. Orders whose payment failed or expired are
+-- canceled in the kitchen, which the previous constraint did not allow.
+ALTER TABLE orders DROP CONSTRAINT chk_payment_fulfillment;
+ALTER TABLE orders ADD CONSTRAINT chk_payment_fulfillment CHECK (
+    (
+        payment_status = 'pending'
+        AND fulfillment_status = 'new'
+    )
+    OR (
+        payment_status = 'paid'
+        AND fulfillment_status IN ('new', 'preparing', 'delivering', 'completed')
+    )
+    OR (
+        payment_status IN ('failed', 'expired', 'canceled', 'refund_pending')
+        AND fulfillment_status = 'canceled'
+    )
+    OR (
+        payment_status IN ('partially_refunded', 'refunded')
+        AND fulfillment_status IN ('new', 'preparing', 'delivering', 'completed', 'canceled')
+    )
+);
+
+-- +goose down
+-- Failed and expired orders written since the up migration cannot be
+-- represented in the old constraint, so it is not checked against them.
+ALTER TABLE orders DROP CONSTRAINT chk_payment_fulfillment;
+ALTER TABLE orders ADD CONSTRAINT chk_payment_fulfillment CHECK (
+    (
+        payment_status = 'pending'
+        AND fulfillment_status = 'new'
+    )
+    OR (
+        payment_status = 'paid'
+        AND fulfillment_status IN ('new', 'preparing', 'delivering', 'completed')
+    )
+    OR (
+        payment_status IN ('canceled', 'refund_pending')
+        AND fulfillment_status = 'canceled'
+    )
+    OR (
+        payment_status IN ('partially_refunded', 'refunded')
+        AND fulfillment_status IN ('new', 'preparing', 'delivering', 'completed', 'canceled')
+    )
+) NOT VALID;
@@ -0,0 +1,20 @@
+-- +goose up
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    menu_id INTEGER NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    stock_level INTEGER NOT NULL CHECK (stock_level >= 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'held' CHECK (
+        status IN ('held', 'released', 'committed')
+    ),
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT uq_stock_reservations_order_menu UNIQUE (order_id, menu_id)
+);
+CREATE INDEX idx_stock_reservations_held_menu ON stock_reservations(menu_id) WHERE status = 'held';
+CREATE INDEX idx_stock_reservations_held_expiry ON stock_reservations(expires_at) WHERE status = 'held';
+
+-- +goose down
+DROP TABLE stock_reservations;
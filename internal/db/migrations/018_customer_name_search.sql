@@ -0,0 +1,9 @@
+-- +goose up
+-- Customer search matches a case-insensitive prefix, which the plain btree
+-- index on customer_name cannot serve.
+DROP INDEX idx_orders_customer_name;
+CREATE INDEX idx_orders_customer_name_prefix ON orders(lower(customer_name) text_pattern_ops);
+
+-- +goose down
+DROP INDEX idx_orders_customer_name_prefix;
+CREATE INDEX idx_orders_customer_name ON orders(customer_name);
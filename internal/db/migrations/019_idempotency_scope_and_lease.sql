@@ -0,0 +1,24 @@
+-- +goose up
+-- Keys are scoped to the client that sent them, so two clients picking the
+-- same key no longer collide. An unfinished request holds its key only until
+-- locked_until, after which a retry may take it over.
+ALTER TABLE idempotency_keys ADD COLUMN scope VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ADD COLUMN locked_until TIMESTAMP;
+UPDATE idempotency_keys
+SET locked_until = CURRENT_TIMESTAMP
+WHERE response_status IS NULL;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, idempotency_key);
+
+-- +goose down
+DELETE FROM idempotency_keys a
+USING idempotency_keys b
+WHERE a.idempotency_key = b.idempotency_key
+  AND a.scope > b.scope;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (idempotency_key);
+
+ALTER TABLE idempotency_keys DROP COLUMN locked_until;
+ALTER TABLE idempotency_keys DROP COLUMN scope;
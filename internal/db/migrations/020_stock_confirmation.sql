@@ -0,0 +1,23 @@
+-- +goose up
+-- Committed stock keeps counting against the stock level the menu service
+-- reports until the menu service has been told to deduct it. Reservations
+-- also remember how long they are held for, so renewing one does not
+-- lengthen the hold.
+ALTER TABLE stock_reservations ADD COLUMN hold_seconds INTEGER;
+UPDATE stock_reservations
+SET hold_seconds = GREATEST(EXTRACT(EPOCH FROM (expires_at - created_at))::int, 0);
+ALTER TABLE stock_reservations ALTER COLUMN hold_seconds SET NOT NULL;
+
+ALTER TABLE stock_reservations ADD COLUMN confirmed_at TIMESTAMP;
+UPDATE stock_reservations
+SET confirmed_at = updated_at
+WHERE status = 'committed';
+
+CREATE INDEX idx_stock_reservations_unconfirmed_menu ON stock_reservations(menu_id)
+WHERE status = 'committed'
+  AND confirmed_at IS NULL;
+
+-- +goose down
+DROP INDEX idx_stock_reservations_unconfirmed_menu;
+ALTER TABLE stock_reservations DROP COLUMN confirmed_at;
+ALTER TABLE stock_reservations DROP COLUMN hold_seconds;
@@ -0,0 +1,5 @@
+-- +goose up
+CREATE INDEX idx_outbox_events_failed ON outbox_events(created_at)
+WHERE status = 'failed';
+-- +goose down
+DROP INDEX idx_outbox_events_failed;
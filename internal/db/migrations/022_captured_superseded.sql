@@ -0,0 +1,20 @@
+-- +goose up
+-- A superseded attempt that is paid anyway keeps its own status, so its
+-- money is refunded without counting as the order's payment.
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (
+    status IN ('pending', 'paid', 'failed', 'expired', 'settled', 'canceled', 'partially_refunded', 'refunded', 'superseded', 'captured_superseded')
+);
+
+CREATE INDEX idx_payments_captured_superseded ON payments(updated_at)
+WHERE status = 'captured_superseded';
+
+-- +goose down
+DROP INDEX idx_payments_captured_superseded;
+
+UPDATE payments SET status = 'superseded' WHERE status = 'captured_superseded';
+
+ALTER TABLE payments DROP CONSTRAINT payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (
+    status IN ('pending', 'paid', 'failed', 'expired', 'settled', 'canceled', 'partially_refunded', 'refunded', 'superseded')
+);
@@ -0,0 +1,35 @@
+-- name: CreateCheckoutSaga :exec
+INSERT INTO checkout_sagas (order_id, gateway_name)
+VALUES (sqlc.arg('order_id'), sqlc.arg('gateway_name'));
+-- name: CompleteCheckoutSaga :execrows
+UPDATE checkout_sagas
+SET status = 'completed',
+  updated_at = CURRENT_TIMESTAMP
+WHERE order_id = sqlc.arg('order_id')
+  AND status = 'started';
+-- name: CompensateCheckoutSaga :execrows
+UPDATE checkout_sagas
+SET status = 'compensated',
+  last_error = sqlc.arg('last_error'),
+  updated_at = CURRENT_TIMESTAMP
+WHERE order_id = sqlc.arg('order_id')
+  AND status = 'started';
+-- name: RecordCheckoutSagaFailure :exec
+UPDATE checkout_sagas
+SET attempts = attempts + 1,
+  last_error = sqlc.arg('last_error'),
+  updated_at = CURRENT_TIMESTAMP
+WHERE order_id = sqlc.arg('order_id')
+  AND status = 'started';
+-- name: GetStalledCheckoutSagas :many
+SELECT s.order_id,
+  s.gateway_name,
+  s.attempts,
+  o.order_total,
+  o.payment_status
+FROM checkout_sagas s
+  JOIN orders o ON o.id = s.order_id
+WHERE s.status = 'started'
+  AND s.updated_at < CURRENT_TIMESTAMP - (sqlc.arg('stale_seconds')::int * INTERVAL '1 second')
+ORDER BY s.created_at ASC
+LIMIT sqlc.arg('limit');
\ No newline at end of file
@@ -0,0 +1,58 @@
+-- name: ClaimIdempotencyKey :one
+-- Claims a key for a new request. A key is taken over once it expired, or
+-- when the request holding it stopped without finishing and its lease ran
+-- out; the latter only for the same request.
+INSERT INTO idempotency_keys (
+    scope,
+    idempotency_key,
+    request_hash,
+    locked_until,
+    expires_at
+  )
+VALUES (
+    sqlc.arg('scope'),
+    sqlc.arg('idempotency_key'),
+    sqlc.arg('request_hash'),
+    CURRENT_TIMESTAMP + (sqlc.arg('lease_seconds')::int * INTERVAL '1 second'),
+    CURRENT_TIMESTAMP + (sqlc.arg('ttl_seconds')::int * INTERVAL '1 second')
+  ) ON CONFLICT (scope, idempotency_key) DO
+UPDATE
+SET request_hash = EXCLUDED.request_hash,
+  response_status = NULL,
+  response_body = NULL,
+  locked_until = EXCLUDED.locked_until,
+  created_at = CURRENT_TIMESTAMP,
+  expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < CURRENT_TIMESTAMP
+  OR (
+    idempotency_keys.response_status IS NULL
+    AND idempotency_keys.locked_until < CURRENT_TIMESTAMP
+    AND idempotency_keys.request_hash = EXCLUDED.request_hash
+  )
+RETURNING *;
+-- name: GetIdempotencyKey :one
+SELECT *
+FROM idempotency_keys
+WHERE scope = sqlc.arg('scope')
+  AND idempotency_key = sqlc.arg('idempotency_key');
+-- name: SaveIdempotencyResponse :exec
+UPDATE idempotency_keys
+SET response_status = sqlc.arg('response_status'),
+  response_body = sqlc.arg('response_body'),
+  locked_until = NULL
+WHERE scope = sqlc.arg('scope')
+  AND idempotency_key = sqlc.arg('idempotency_key');
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE scope = sqlc.arg('scope')
+  AND idempotency_key = sqlc.arg('idempotency_key')
+  AND response_status IS NULL;
+-- name: PurgeExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE (scope, idempotency_key) IN (
+    SELECT scope, idempotency_key
+    FROM idempotency_keys
+    WHERE expires_at < CURRENT_TIMESTAMP
+    ORDER BY expires_at
+    LIMIT sqlc.arg('limit')
+  );
@@ -0,0 +1,26 @@
+-- name: CreateOrderStatusEvent :exec
+INSERT INTO order_status_events (
+    order_id,
+    from_payment_status,
+    to_payment_status,
+    from_fulfillment_status,
+    to_fulfillment_status,
+    actor_id,
+    source,
+    note
+  )
+VALUES (
+    sqlc.arg('order_id'),
+    sqlc.narg('from_payment_status'),
+    sqlc.arg('to_payment_status'),
+    sqlc.narg('from_fulfillment_status'),
+    sqlc.arg('to_fulfillment_status'),
+    sqlc.narg('actor_id'),
+    sqlc.arg('source'),
+    sqlc.narg('note')
+  );
+-- name: GetOrderStatusEvents :many
+SELECT *
+FROM order_status_events
+WHERE order_id = sqlc.arg('order_id')
+ORDER BY created_at ASC, id ASC;
\ No newline at end of file
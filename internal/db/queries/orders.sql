@@ -3,11 +3,145 @@ SELECT *
 FROM orders
 ORDER BY created_at DESC
 LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+-- name: SearchOrders :many
+SELECT *
+FROM orders
+WHERE (
+    sqlc.narg('payment_status')::varchar IS NULL
+    OR payment_status = sqlc.narg('payment_status')
+  )
+  AND (
+    sqlc.narg('fulfillment_status')::varchar IS NULL
+    OR fulfillment_status = sqlc.narg('fulfillment_status')
+  )
+  AND (
+    sqlc.narg('created_from')::timestamp IS NULL
+    OR created_at >= sqlc.narg('created_from')
+  )
+  AND (
+    sqlc.narg('created_to')::timestamp IS NULL
+    OR created_at < sqlc.narg('created_to')
+  )
+  AND (
+    sqlc.narg('customer_phone')::varchar IS NULL
+    OR customer_phone = sqlc.narg('customer_phone')
+  )
+  AND (
+    sqlc.narg('customer_name')::varchar IS NULL
+    OR lower(customer_name) LIKE lower(sqlc.narg('customer_name')) || '%'
+  )
+  AND (
+    sqlc.narg('cursor_id')::int IS NULL
+    OR (
+      sqlc.arg('sort_by')::text = 'created_at'
+      AND sqlc.arg('sort_desc')::bool
+      AND (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::int)
+    )
+    OR (
+      sqlc.arg('sort_by')::text = 'created_at'
+      AND NOT sqlc.arg('sort_desc')::bool
+      AND (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::int)
+    )
+    OR (
+      sqlc.arg('sort_by')::text = 'order_total'
+      AND sqlc.arg('sort_desc')::bool
+      AND (order_total, id) < (sqlc.narg('cursor_total')::int, sqlc.narg('cursor_id')::int)
+    )
+    OR (
+      sqlc.arg('sort_by')::text = 'order_total'
+      AND NOT sqlc.arg('sort_desc')::bool
+      AND (order_total, id) > (sqlc.narg('cursor_total')::int, sqlc.narg('cursor_id')::int)
+    )
+  )
+ORDER BY CASE
+    WHEN sqlc.arg('sort_by')::text = 'created_at'
+    AND sqlc.arg('sort_desc')::bool THEN created_at
+  END DESC,
+  CASE
+    WHEN sqlc.arg('sort_by')::text = 'created_at'
+    AND NOT sqlc.arg('sort_desc')::bool THEN created_at
+  END ASC,
+  CASE
+    WHEN sqlc.arg('sort_by')::text = 'order_total'
+    AND sqlc.arg('sort_desc')::bool THEN order_total
+  END DESC,
+  CASE
+    WHEN sqlc.arg('sort_by')::text = 'order_total'
+    AND NOT sqlc.arg('sort_desc')::bool THEN order_total
+  END ASC,
+  CASE
+    WHEN sqlc.arg('sort_desc')::bool THEN id
+  END DESC,
+  CASE
+    WHEN NOT sqlc.arg('sort_desc')::bool THEN id
+  END ASC
+LIMIT sqlc.arg('limit');
+-- name: CountOrders :one
+SELECT COUNT(*)
+FROM orders
+WHERE (
+    sqlc.narg('payment_status')::varchar IS NULL
+    OR payment_status = sqlc.narg('payment_status')
+  )
+  AND (
+    sqlc.narg('fulfillment_status')::varchar IS NULL
+    OR fulfillment_status = sqlc.narg('fulfillment_status')
+  )
+  AND (
+    sqlc.narg('created_from')::timestamp IS NULL
+    OR created_at >= sqlc.narg('created_from')
+  )
+  AND (
+    sqlc.narg('created_to')::timestamp IS NULL
+    OR created_at < sqlc.narg('created_to')
+  )
+  AND (
+    sqlc.narg('customer_phone')::varchar IS NULL
+    OR customer_phone = sqlc.narg('customer_phone')
+  )
+  AND (
+    sqlc.narg('customer_name')::varchar IS NULL
+    OR lower(customer_name) LIKE lower(sqlc.narg('customer_name')) || '%'
+  );
 -- name: GetOrdersByUserId :many
 SELECT *
 FROM orders
 WHERE user_id = sqlc.arg('user_id')
 ORDER BY created_at DESC;
+-- name: ListUserOrders :many
+SELECT *
+FROM orders
+WHERE user_id = sqlc.arg('user_id')
+  AND (
+    sqlc.narg('payment_status')::varchar IS NULL
+    OR payment_status = sqlc.narg('payment_status')
+  )
+  AND (
+    sqlc.narg('fulfillment_status')::varchar IS NULL
+    OR fulfillment_status = sqlc.narg('fulfillment_status')
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+-- name: CountUserOrders :one
+SELECT COUNT(*)
+FROM orders
+WHERE user_id = sqlc.arg('user_id')
+  AND (
+    sqlc.narg('payment_status')::varchar IS NULL
+    OR payment_status = sqlc.narg('payment_status')
+  )
+  AND (
+    sqlc.narg('fulfillment_status')::varchar IS NULL
+    OR fulfillment_status = sqlc.narg('fulfillment_status')
+  );
+-- name: GetOrdersToFulfill :many
+-- Returns the paid orders at a fulfillment step, including the ones that
+-- were refunded in part and are still being fulfilled.
+SELECT *
+FROM orders
+WHERE payment_status IN ('paid', 'partially_refunded')
+  AND fulfillment_status = sqlc.arg('fulfillment_status')
+ORDER BY created_at ASC;
 -- name: GetOrderById :one
 SELECT *
 FROM orders
@@ -37,20 +171,45 @@ UPDATE orders
 SET order_total = sqlc.arg('order_total'),
   updated_at = CURRENT_TIMESTAMP
 WHERE id = sqlc.arg('id');
--- name: CancelOrder :exec
+-- name: CancelOrder :one
 UPDATE orders
-SET fulfillment_status = 'canceled',
+SET payment_status = 'canceled',
+  fulfillment_status = 'canceled',
+  canceled_by = sqlc.narg('canceled_by'),
+  cancel_reason = sqlc.narg('cancel_reason'),
+  canceled_at = CURRENT_TIMESTAMP,
   updated_at = CURRENT_TIMESTAMP
 WHERE id = sqlc.arg('id')
   AND payment_status = 'pending'
-  AND fulfillment_status = 'new';
--- name: MarkOrderPaid :exec
+  AND fulfillment_status = 'new'
+RETURNING *;
+-- name: CancelPaidOrder :one
+UPDATE orders
+SET payment_status = 'refund_pending',
+  fulfillment_status = 'canceled',
+  canceled_by = sqlc.narg('canceled_by'),
+  cancel_reason = sqlc.narg('cancel_reason'),
+  canceled_at = CURRENT_TIMESTAMP,
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+  AND payment_status = 'paid'
+  AND fulfillment_status IN ('new', 'preparing')
+RETURNING *;
+-- name: MarkOrderPaid :execrows
 UPDATE orders
 SET payment_status = 'paid',
   updated_at = CURRENT_TIMESTAMP
 WHERE id = sqlc.arg('id')
   AND payment_status = 'pending';
--- name: MarkOrderPaymentFailed :exec
+-- name: MarkCanceledOrderPaid :execrows
+-- A payment captured after its order was canceled has to be returned.
+UPDATE orders
+SET payment_status = 'refund_pending',
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+  AND payment_status = 'canceled'
+  AND fulfillment_status = 'canceled';
+-- name: MarkOrderPaymentFailed :execrows
 UPDATE orders
 SET payment_status = 'failed',
   fulfillment_status = 'canceled',
@@ -58,7 +217,7 @@ SET payment_status = 'failed',
 WHERE id = sqlc.arg('id')
   AND payment_status = 'pending'
   AND fulfillment_status = 'new';
--- name: MarkOrderPaymentExpired :exec
+-- name: MarkOrderPaymentExpired :execrows
 UPDATE orders
 SET payment_status = 'expired',
   fulfillment_status = 'canceled',
@@ -66,24 +225,41 @@ SET payment_status = 'expired',
 WHERE id = sqlc.arg('id')
   AND payment_status = 'pending'
   AND fulfillment_status = 'new';
--- name: StartPreparingOrder :exec
+-- name: StartPreparingOrder :execrows
 UPDATE orders
 SET fulfillment_status = 'preparing',
   updated_at = CURRENT_TIMESTAMP
 WHERE id = sqlc.arg('id')
-  AND payment_status = 'paid'
+  AND payment_status IN ('paid', 'partially_refunded')
   AND fulfillment_status = 'new';
--- name: MarkOrderDelivering :exec
+-- name: MarkOrderDelivering :execrows
 UPDATE orders
 SET fulfillment_status = 'delivering',
   updated_at = CURRENT_TIMESTAMP
 WHERE id = sqlc.arg('id')
-  AND payment_status = 'paid'
+  AND payment_status IN ('paid', 'partially_refunded')
   AND fulfillment_status = 'preparing';
--- name: CompleteOrder :exec
+-- name: CompleteOrder :execrows
 UPDATE orders
 SET fulfillment_status = 'completed',
   updated_at = CURRENT_TIMESTAMP
 WHERE id = sqlc.arg('id')
-  AND payment_status = 'paid'
-  AND fulfillment_status = 'delivering';
\ No newline at end of file
+  AND payment_status IN ('paid', 'partially_refunded')
+  AND fulfillment_status = 'delivering';
+-- name: MarkOrderRefunded :execrows
+UPDATE orders
+SET payment_status = sqlc.arg('payment_status'),
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+  AND payment_status IN ('paid', 'refund_pending', 'partially_refunded');
+-- name: ReopenOrderPayment :execrows
+-- Puts an order whose payment failed or expired back to awaiting payment.
+-- Orders canceled by a user or admin stay canceled.
+UPDATE orders
+SET payment_status = 'pending',
+  fulfillment_status = 'new',
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+  AND payment_status IN ('pending', 'failed', 'expired')
+  AND fulfillment_status IN ('new', 'canceled')
+  AND canceled_at IS NULL;
@@ -0,0 +1,65 @@
+-- name: CreateOutboxEvent :exec
+INSERT INTO outbox_events (
+    aggregate_type,
+    aggregate_id,
+    event_type,
+    payload
+  )
+VALUES (
+    sqlc.arg('aggregate_type'),
+    sqlc.arg('aggregate_id'),
+    sqlc.arg('event_type'),
+    sqlc.arg('payload')
+  );
+-- name: ClaimOutboxEvents :many
+-- Leases the oldest pending event of each aggregate. Later events of the same
+-- aggregate stay hidden until the earlier one leaves the pending state, which
+-- keeps delivery ordered per aggregate.
+UPDATE outbox_events
+SET attempts = attempts + 1,
+  available_at = CURRENT_TIMESTAMP + (sqlc.arg('lease_seconds')::int * INTERVAL '1 second')
+WHERE id IN (
+    SELECT e.id
+    FROM outbox_events e
+    WHERE e.status = 'pending'
+      AND e.available_at <= CURRENT_TIMESTAMP
+      AND NOT EXISTS (
+        SELECT 1
+        FROM outbox_events prev
+        WHERE prev.aggregate_type = e.aggregate_type
+          AND prev.aggregate_id = e.aggregate_id
+          AND prev.status = 'pending'
+          AND prev.id < e.id
+      )
+    ORDER BY e.id
+    LIMIT sqlc.arg('limit') FOR UPDATE SKIP LOCKED
+  )
+RETURNING *;
+-- name: MarkOutboxEventPublished :exec
+UPDATE outbox_events
+SET status = 'published',
+  last_error = NULL,
+  published_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id');
+-- name: RecordOutboxEventFailure :exec
+UPDATE outbox_events
+SET status = CASE
+    WHEN attempts >= sqlc.arg('max_attempts')::int THEN 'failed'
+    ELSE 'pending'
+  END,
+  last_error = sqlc.arg('last_error'),
+  available_at = CURRENT_TIMESTAMP + (sqlc.arg('retry_seconds')::int * INTERVAL '1 second')
+WHERE id = sqlc.arg('id');
+-- name: GetFailedOutboxEvents :many
+SELECT *
+FROM outbox_events
+WHERE status = 'failed'
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+-- name: ReplayOutboxEvent :execrows
+UPDATE outbox_events
+SET status = 'pending',
+  attempts = 0,
+  available_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+  AND status = 'failed';
\ No newline at end of file
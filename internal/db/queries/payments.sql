@@ -3,23 +3,38 @@ INSERT INTO payments (
     order_id,
     external_id,
     gateway_name,
+    payment_method,
     payment_channel,
     amount,
-    status
+    attempt,
+    status,
+    expires_at
   )
 VALUES (
     sqlc.arg(order_id),
     sqlc.arg(external_id),
     sqlc.arg(gateway_name),
+    sqlc.arg(payment_method),
     sqlc.arg(payment_channel),
     sqlc.arg(amount),
-    'pending'
+    sqlc.arg(attempt),
+    'pending',
+    CURRENT_TIMESTAMP + (sqlc.narg('ttl_seconds')::int * INTERVAL '1 second')
   )
 RETURNING *;
 -- name: GetAllPayments :many
 SELECT *
 FROM payments
-ORDER BY created_at DESC
+WHERE (
+    sqlc.narg('created_from')::timestamp IS NULL
+    OR created_at >= sqlc.narg('created_from')
+  )
+  AND (
+    sqlc.narg('created_to')::timestamp IS NULL
+    OR created_at < sqlc.narg('created_to')
+  )
+ORDER BY created_at DESC,
+  id DESC
 LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
 -- name: GetPaymentByExternalID :many
 SELECT *
@@ -31,32 +46,96 @@ LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
 SELECT *
 FROM payments
 WHERE order_id = sqlc.arg('order_id')
-ORDER BY created_at DESC
+ORDER BY attempt DESC,
+  created_at DESC
 LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
--- name: MarkPaymentPaid :exec
+-- name: MarkPaymentPaid :execrows
 UPDATE payments
 SET status = 'paid',
-  payment_channel = sqlc.arg('payment_channel'),
-  gateway_transaction_id = sqlc.arg('gateway_transaction_id'),
+  payment_channel = COALESCE(sqlc.narg('payment_channel'), payment_channel),
+  gateway_transaction_id = COALESCE(sqlc.narg('gateway_transaction_id'), gateway_transaction_id),
   paid_at = CURRENT_TIMESTAMP,
   updated_at = CURRENT_TIMESTAMP
 WHERE external_id = sqlc.arg('external_id')
-  AND status = 'pending';
--- name: MarkPaymentFailed :exec
+  AND status IN ('pending', 'canceled');
+-- name: MarkPaymentFailed :execrows
 UPDATE payments
 SET status = 'failed',
   updated_at = CURRENT_TIMESTAMP
 WHERE external_id = sqlc.arg('external_id')
   AND status = 'pending';
--- name: MarkPaymentExpired :exec
+-- name: MarkPaymentExpired :execrows
 UPDATE payments
 SET status = 'expired',
   updated_at = CURRENT_TIMESTAMP
 WHERE external_id = sqlc.arg('external_id')
   AND status = 'pending';
--- name: MarkPaymentSettled :exec
+-- name: MarkPaymentSettled :execrows
 UPDATE payments
 SET status = 'settled',
   updated_at = CURRENT_TIMESTAMP
 WHERE external_id = sqlc.arg('external_id')
-  AND status = 'paid';
\ No newline at end of file
+  AND status = 'paid';
+-- name: MarkPaymentCanceled :execrows
+UPDATE payments
+SET status = 'canceled',
+  updated_at = CURRENT_TIMESTAMP
+WHERE external_id = sqlc.arg('external_id')
+  AND status = 'pending';
+-- name: GetOverduePayments :many
+SELECT *
+FROM payments
+WHERE status = 'pending'
+  AND expires_at < CURRENT_TIMESTAMP - (sqlc.arg('grace_seconds')::int * INTERVAL '1 second')
+ORDER BY expires_at ASC
+LIMIT sqlc.arg('limit');
+-- name: GetCanceledOrderPayments :many
+-- Returns pending payments of canceled orders, whose gateway requests still
+-- have to be voided.
+SELECT p.*
+FROM payments p
+  JOIN orders o ON o.id = p.order_id
+WHERE p.status = 'pending'
+  AND o.payment_status = 'canceled'
+ORDER BY p.created_at ASC
+LIMIT sqlc.arg('limit');
+-- name: GetPaymentByID :one
+SELECT *
+FROM payments
+WHERE id = sqlc.arg('id');
+-- name: MarkPaymentRefunded :execrows
+UPDATE payments
+SET status = sqlc.arg('status'),
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+  AND status IN ('paid', 'settled', 'partially_refunded', 'captured_superseded');
+-- name: MarkPaymentSuperseded :execrows
+UPDATE payments
+SET status = 'superseded',
+  updated_at = CURRENT_TIMESTAMP
+WHERE external_id = sqlc.arg('external_id')
+  AND status = 'pending';
+-- name: MarkSupersededPaymentCaptured :execrows
+-- Records money captured on an attempt that was replaced by a retry. The
+-- order is paid by another attempt, if at all, so this money is refunded.
+UPDATE payments
+SET status = 'captured_superseded',
+  payment_channel = COALESCE(sqlc.narg('payment_channel'), payment_channel),
+  gateway_transaction_id = COALESCE(sqlc.narg('gateway_transaction_id'), gateway_transaction_id),
+  paid_at = CURRENT_TIMESTAMP,
+  updated_at = CURRENT_TIMESTAMP
+WHERE external_id = sqlc.arg('external_id')
+  AND status = 'superseded';
+-- name: LockPaymentByExternalID :one
+-- Locks the payment so status changes for the same payment request are
+-- applied one at a time.
+SELECT *
+FROM payments
+WHERE external_id = sqlc.arg('external_id')
+FOR UPDATE;
+-- name: LockPaymentByID :one
+-- Locks a payment so refunds of it are applied one at a time.
+SELECT *
+FROM payments
+WHERE id = sqlc.arg('id')
+FOR UPDATE;
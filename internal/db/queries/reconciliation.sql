@@ -0,0 +1,50 @@
+-- name: CreateReconciliationRun :one
+INSERT INTO reconciliation_runs (
+    range_start,
+    range_end,
+    apply
+  )
+VALUES (
+    sqlc.arg('range_start'),
+    sqlc.arg('range_end'),
+    sqlc.arg('apply')
+  )
+RETURNING *;
+-- name: FinishReconciliationRun :exec
+UPDATE reconciliation_runs
+SET status = sqlc.arg('status'),
+  checked = sqlc.arg('checked'),
+  mismatches = sqlc.arg('mismatches'),
+  errors = sqlc.arg('errors'),
+  error = sqlc.narg('error'),
+  finished_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id');
+-- name: CreateReconciliationMismatch :exec
+INSERT INTO reconciliation_mismatches (
+    run_id,
+    kind,
+    order_id,
+    payment_id,
+    gateway_name,
+    gateway_id,
+    local_status,
+    gateway_status,
+    local_amount,
+    gateway_amount,
+    applied,
+    apply_error
+  )
+VALUES (
+    sqlc.arg('run_id'),
+    sqlc.arg('kind'),
+    sqlc.narg('order_id'),
+    sqlc.narg('payment_id'),
+    sqlc.arg('gateway_name'),
+    sqlc.arg('gateway_id'),
+    sqlc.narg('local_status'),
+    sqlc.narg('gateway_status'),
+    sqlc.narg('local_amount'),
+    sqlc.narg('gateway_amount'),
+    sqlc.arg('applied'),
+    sqlc.narg('apply_error')
+  );
@@ -0,0 +1,118 @@
+-- name: CreateRefund :one
+INSERT INTO refunds (
+    order_id,
+    payment_id,
+    gateway_name,
+    amount,
+    reason,
+    requested_by
+  )
+VALUES (
+    sqlc.arg('order_id'),
+    sqlc.arg('payment_id'),
+    sqlc.arg('gateway_name'),
+    sqlc.arg('amount'),
+    sqlc.arg('reason'),
+    sqlc.narg('requested_by')
+  )
+RETURNING *;
+-- name: LockRefundablePayment :one
+-- Locks the captured payment of an order so concurrent refunds are checked
+-- against the same total.
+SELECT *
+FROM payments
+WHERE order_id = sqlc.arg('order_id')
+  AND status IN ('paid', 'settled', 'partially_refunded')
+ORDER BY created_at DESC
+LIMIT 1 FOR UPDATE;
+-- name: GetReservedRefundAmount :one
+-- Sums the refunds that are pending or succeeded, i.e. the part of the
+-- payment that can no longer be refunded.
+SELECT COALESCE(SUM(amount), 0)::int AS reserved
+FROM refunds
+WHERE payment_id = sqlc.arg('payment_id')
+  AND status IN ('pending', 'succeeded');
+-- name: GetRefundedAmount :one
+SELECT COALESCE(SUM(amount), 0)::int AS refunded
+FROM refunds
+WHERE payment_id = sqlc.arg('payment_id')
+  AND status = 'succeeded';
+-- name: SetRefundGatewayID :exec
+UPDATE refunds
+SET gateway_refund_id = sqlc.arg('gateway_refund_id'),
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id');
+-- name: CompleteRefund :execrows
+UPDATE refunds
+SET status = sqlc.arg('status'),
+  gateway_refund_id = COALESCE(sqlc.narg('gateway_refund_id'), gateway_refund_id),
+  failure_code = sqlc.narg('failure_code'),
+  completed_at = CURRENT_TIMESTAMP,
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+  AND status = 'pending';
+-- name: GetRefundByID :one
+SELECT *
+FROM refunds
+WHERE id = sqlc.arg('id');
+-- name: GetRefundByGatewayRefundID :one
+SELECT *
+FROM refunds
+WHERE gateway_refund_id = sqlc.arg('gateway_refund_id');
+-- name: GetRefundsByOrderID :many
+SELECT *
+FROM refunds
+WHERE order_id = sqlc.arg('order_id')
+ORDER BY created_at DESC;
+-- name: GetOrdersAwaitingRefund :many
+-- Returns orders that wait for a refund nobody requested yet, e.g. because
+-- the request failed after the order was canceled. Orders whose refund was
+-- refused max_attempts times are left for an admin.
+SELECT o.id
+FROM orders o
+WHERE o.payment_status = 'refund_pending'
+  AND NOT EXISTS (
+    SELECT 1
+    FROM refunds r
+    WHERE r.order_id = o.id
+      AND r.status IN ('pending', 'succeeded')
+  )
+  AND (
+    SELECT COUNT(*)
+    FROM refunds r
+    WHERE r.order_id = o.id
+      AND r.status = 'failed'
+  ) < sqlc.arg('max_attempts')::int
+ORDER BY o.updated_at ASC
+LIMIT sqlc.arg('limit');
+-- name: GetUnsentRefunds :many
+-- Returns pending refunds the gateway has not confirmed receiving, e.g.
+-- because the process stopped before the request was sent.
+SELECT *
+FROM refunds
+WHERE status = 'pending'
+  AND gateway_refund_id IS NULL
+  AND updated_at < CURRENT_TIMESTAMP - (sqlc.arg('stale_seconds')::int * INTERVAL '1 second')
+ORDER BY created_at ASC
+LIMIT sqlc.arg('limit');
+-- name: GetSupersededCaptures :many
+-- Returns payments captured on superseded attempts that still have to be
+-- refunded. Payments whose refund was refused max_attempts times are left
+-- for an admin.
+SELECT p.*
+FROM payments p
+WHERE p.status = 'captured_superseded'
+  AND NOT EXISTS (
+    SELECT 1
+    FROM refunds r
+    WHERE r.payment_id = p.id
+      AND r.status IN ('pending', 'succeeded')
+  )
+  AND (
+    SELECT COUNT(*)
+    FROM refunds r
+    WHERE r.payment_id = p.id
+      AND r.status = 'failed'
+  ) < sqlc.arg('max_attempts')::int
+ORDER BY p.updated_at ASC
+LIMIT sqlc.arg('limit');
\ No newline at end of file
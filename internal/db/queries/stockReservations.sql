@@ -0,0 +1,105 @@
+-- name: LockMenuStock :exec
+-- Serializes reservations of a menu until the transaction ends, so two
+-- orders cannot both take the last portion.
+SELECT pg_advisory_xact_lock(hashtext('menu_stock'), sqlc.arg('menu_id')::int);
+
+-- name: ReserveStock :execrows
+-- Holds stock for an order unless the holds on the menu, together with the
+-- committed stock the menu service has not deducted yet, would exceed the
+-- stock level the menu service reported. Must run after LockMenuStock.
+INSERT INTO stock_reservations (
+    order_id,
+    menu_id,
+    quantity,
+    stock_level,
+    hold_seconds,
+    expires_at
+)
+SELECT
+    sqlc.arg('order_id')::int,
+    sqlc.arg('menu_id')::int,
+    sqlc.arg('quantity')::int,
+    sqlc.arg('stock_level')::int,
+    sqlc.arg('hold_seconds')::int,
+    CURRENT_TIMESTAMP + (sqlc.arg('hold_seconds')::int * INTERVAL '1 second')
+WHERE (
+    SELECT COALESCE(SUM(quantity), 0)
+    FROM stock_reservations
+    WHERE menu_id = sqlc.arg('menu_id')
+      AND (
+        status = 'held'
+        OR (status = 'committed' AND confirmed_at IS NULL)
+      )
+) + sqlc.arg('quantity') <= sqlc.arg('stock_level');
+
+-- name: GetOrderStockReservations :many
+SELECT *
+FROM stock_reservations
+WHERE order_id = sqlc.arg('order_id')
+ORDER BY menu_id;
+
+-- name: RenewStockReservation :execrows
+-- Holds a reservation again for as long as it was first held. A released
+-- reservation is only held again if the stock level it was made against
+-- still covers it. Must run after LockMenuStock.
+UPDATE stock_reservations sr
+SET status = 'held',
+  expires_at = CURRENT_TIMESTAMP + (sr.hold_seconds * INTERVAL '1 second'),
+  updated_at = CURRENT_TIMESTAMP
+WHERE sr.id = sqlc.arg('id')
+  AND (
+    sr.status = 'held'
+    OR (
+      sr.status = 'released'
+      AND (
+        SELECT COALESCE(SUM(other.quantity), 0)
+        FROM stock_reservations other
+        WHERE other.menu_id = sr.menu_id
+          AND (
+            other.status = 'held'
+            OR (other.status = 'committed' AND other.confirmed_at IS NULL)
+          )
+          AND other.id <> sr.id
+      ) + sr.quantity <= sr.stock_level
+    )
+  );
+
+-- name: ReleaseOrderStock :execrows
+UPDATE stock_reservations
+SET status = 'released',
+  updated_at = CURRENT_TIMESTAMP
+WHERE order_id = sqlc.arg('order_id')
+  AND status = 'held';
+
+-- name: CommitOrderStock :many
+UPDATE stock_reservations
+SET status = 'committed',
+  updated_at = CURRENT_TIMESTAMP
+WHERE order_id = sqlc.arg('order_id')
+  AND status = 'held'
+RETURNING menu_id, quantity;
+
+-- name: ConfirmOrderStock :execrows
+-- Marks an order's committed stock as handed to the menu service, whose
+-- stock levels include it from then on.
+UPDATE stock_reservations
+SET confirmed_at = CURRENT_TIMESTAMP,
+  updated_at = CURRENT_TIMESTAMP
+WHERE order_id = sqlc.arg('order_id')
+  AND status = 'committed'
+  AND confirmed_at IS NULL;
+
+-- name: ReleaseStaleStock :execrows
+-- Releases holds that outlived their expiry, and holds of orders that ended
+-- without being paid but missed their release.
+UPDATE stock_reservations sr
+SET status = 'released',
+  updated_at = CURRENT_TIMESTAMP
+FROM orders o
+WHERE o.id = sr.order_id
+  AND sr.status = 'held'
+  AND o.payment_status IN ('pending', 'failed', 'expired', 'canceled')
+  AND (
+    sr.expires_at < CURRENT_TIMESTAMP
+    OR o.payment_status <> 'pending'
+  );
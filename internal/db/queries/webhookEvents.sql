@@ -0,0 +1,70 @@
+-- name: CreateWebhookEvent :execrows
+-- Stores a received callback. A redelivery of an event that is already
+-- stored is dropped and affects no rows.
+INSERT INTO webhook_events (
+    provider,
+    dedupe_key,
+    event_type,
+    reference,
+    headers,
+    body
+  )
+VALUES (
+    sqlc.arg('provider'),
+    sqlc.arg('dedupe_key'),
+    sqlc.arg('event_type'),
+    sqlc.arg('reference'),
+    sqlc.arg('headers'),
+    sqlc.arg('body')
+  ) ON CONFLICT (provider, dedupe_key) DO NOTHING;
+-- name: ClaimWebhookEvents :many
+-- Leases the oldest pending event of each reference, so callbacks for one
+-- payment are applied in the order they were received.
+UPDATE webhook_events
+SET attempts = attempts + 1,
+  available_at = CURRENT_TIMESTAMP + (sqlc.arg('lease_seconds')::int * INTERVAL '1 second')
+WHERE id IN (
+    SELECT e.id
+    FROM webhook_events e
+    WHERE e.status = 'pending'
+      AND e.available_at <= CURRENT_TIMESTAMP
+      AND NOT EXISTS (
+        SELECT 1
+        FROM webhook_events prev
+        WHERE prev.provider = e.provider
+          AND prev.reference = e.reference
+          AND prev.status = 'pending'
+          AND prev.id < e.id
+      )
+    ORDER BY e.id
+    LIMIT sqlc.arg('limit') FOR UPDATE SKIP LOCKED
+  )
+RETURNING *;
+-- name: MarkWebhookEventProcessed :exec
+UPDATE webhook_events
+SET status = 'processed',
+  last_error = NULL,
+  processed_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id');
+-- name: RecordWebhookEventFailure :exec
+UPDATE webhook_events
+SET status = CASE
+    WHEN attempts >= sqlc.arg('max_attempts')::int THEN 'failed'
+    ELSE 'pending'
+  END,
+  last_error = sqlc.arg('last_error'),
+  available_at = CURRENT_TIMESTAMP + (sqlc.arg('retry_seconds')::int * INTERVAL '1 second')
+WHERE id = sqlc.arg('id');
+-- name: GetFailedWebhookEvents :many
+SELECT *
+FROM webhook_events
+WHERE status = 'failed'
+ORDER BY received_at DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+-- name: ReplayWebhookEvent :execrows
+UPDATE webhook_events
+SET status = 'pending',
+  attempts = 0,
+  available_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+  AND status = 'failed';
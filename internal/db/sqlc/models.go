@@ -6,20 +6,45 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
+type CheckoutSaga struct {
+	OrderID     int32          `json:"order_id"`
+	Status      string         `json:"status"`
+	GatewayName string         `json:"gateway_name"`
+	Attempts    int32          `json:"attempts"`
+	LastError   sql.NullString `json:"last_error"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+type IdempotencyKey struct {
+	IdempotencyKey string        `json:"idempotency_key"`
+	RequestHash    string        `json:"request_hash"`
+	ResponseStatus sql.NullInt32 `json:"response_status"`
+	ResponseBody   []byte        `json:"response_body"`
+	CreatedAt      time.Time     `json:"created_at"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	Scope          string        `json:"scope"`
+	LockedUntil    sql.NullTime  `json:"locked_until"`
+}
+
 type Order struct {
-	ID                int32         `json:"id"`
-	UserID            sql.NullInt32 `json:"user_id"`
-	CustomerName      string        `json:"customer_name"`
-	CustomerPhone     string        `json:"customer_phone"`
-	DeliveryAddress   string        `json:"delivery_address"`
-	OrderTotal        int32         `json:"order_total"`
-	PaymentStatus     string        `json:"payment_status"`
-	FulfillmentStatus string        `json:"fulfillment_status"`
-	CreatedAt         time.Time     `json:"created_at"`
-	UpdatedAt         time.Time     `json:"updated_at"`
+	ID                int32          `json:"id"`
+	UserID            sql.NullInt32  `json:"user_id"`
+	CustomerName      string         `json:"customer_name"`
+	CustomerPhone     string         `json:"customer_phone"`
+	DeliveryAddress   string         `json:"delivery_address"`
+	OrderTotal        int32          `json:"order_total"`
+	PaymentStatus     string         `json:"payment_status"`
+	FulfillmentStatus string         `json:"fulfillment_status"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	CanceledBy        sql.NullInt32  `json:"canceled_by"`
+	CancelReason      sql.NullString `json:"cancel_reason"`
+	CanceledAt        sql.NullTime   `json:"canceled_at"`
 }
 
 type OrderItem struct {
@@ -42,6 +67,33 @@ type OrderItemModifier struct {
 	Quantity                  int32  `json:"quantity"`
 }
 
+type OrderStatusEvent struct {
+	ID                    int32          `json:"id"`
+	OrderID               int32          `json:"order_id"`
+	FromPaymentStatus     sql.NullString `json:"from_payment_status"`
+	ToPaymentStatus       string         `json:"to_payment_status"`
+	FromFulfillmentStatus sql.NullString `json:"from_fulfillment_status"`
+	ToFulfillmentStatus   string         `json:"to_fulfillment_status"`
+	ActorID               sql.NullInt32  `json:"actor_id"`
+	Source                string         `json:"source"`
+	Note                  sql.NullString `json:"note"`
+	CreatedAt             time.Time      `json:"created_at"`
+}
+
+type OutboxEvent struct {
+	ID            int64           `json:"id"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   int32           `json:"aggregate_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int32           `json:"attempts"`
+	LastError     sql.NullString  `json:"last_error"`
+	AvailableAt   time.Time       `json:"available_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	PublishedAt   sql.NullTime    `json:"published_at"`
+}
+
 type Payment struct {
 	ID                   int32          `json:"id"`
 	OrderID              int32          `json:"order_id"`
@@ -54,4 +106,84 @@ type Payment struct {
 	PaidAt               sql.NullTime   `json:"paid_at"`
 	CreatedAt            time.Time      `json:"created_at"`
 	UpdatedAt            time.Time      `json:"updated_at"`
+	PaymentMethod        sql.NullString `json:"payment_method"`
+	ExpiresAt            sql.NullTime   `json:"expires_at"`
+	Attempt              int32          `json:"attempt"`
+}
+
+type ReconciliationMismatch struct {
+	ID            int64          `json:"id"`
+	RunID         int64          `json:"run_id"`
+	Kind          string         `json:"kind"`
+	OrderID       sql.NullInt32  `json:"order_id"`
+	PaymentID     sql.NullInt32  `json:"payment_id"`
+	GatewayName   string         `json:"gateway_name"`
+	GatewayID     string         `json:"gateway_id"`
+	LocalStatus   sql.NullString `json:"local_status"`
+	GatewayStatus sql.NullString `json:"gateway_status"`
+	LocalAmount   sql.NullInt32  `json:"local_amount"`
+	GatewayAmount sql.NullInt32  `json:"gateway_amount"`
+	Applied       bool           `json:"applied"`
+	ApplyError    sql.NullString `json:"apply_error"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+type ReconciliationRun struct {
+	ID         int64          `json:"id"`
+	RangeStart time.Time      `json:"range_start"`
+	RangeEnd   time.Time      `json:"range_end"`
+	Apply      bool           `json:"apply"`
+	Status     string         `json:"status"`
+	Checked    int32          `json:"checked"`
+	Mismatches int32          `json:"mismatches"`
+	Errors     int32          `json:"errors"`
+	Error      sql.NullString `json:"error"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt sql.NullTime   `json:"finished_at"`
+}
+
+type Refund struct {
+	ID              int32          `json:"id"`
+	OrderID         int32          `json:"order_id"`
+	PaymentID       int32          `json:"payment_id"`
+	GatewayName     string         `json:"gateway_name"`
+	GatewayRefundID sql.NullString `json:"gateway_refund_id"`
+	Amount          int32          `json:"amount"`
+	Reason          string         `json:"reason"`
+	Status          string         `json:"status"`
+	FailureCode     sql.NullString `json:"failure_code"`
+	RequestedBy     sql.NullInt32  `json:"requested_by"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	CompletedAt     sql.NullTime   `json:"completed_at"`
+}
+
+type StockReservation struct {
+	ID          int32        `json:"id"`
+	OrderID     int32        `json:"order_id"`
+	MenuID      int32        `json:"menu_id"`
+	Quantity    int32        `json:"quantity"`
+	StockLevel  int32        `json:"stock_level"`
+	Status      string       `json:"status"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	HoldSeconds int32        `json:"hold_seconds"`
+	ConfirmedAt sql.NullTime `json:"confirmed_at"`
+}
+
+type WebhookEvent struct {
+	ID          int64           `json:"id"`
+	Provider    string          `json:"provider"`
+	DedupeKey   string          `json:"dedupe_key"`
+	EventType   string          `json:"event_type"`
+	Reference   string          `json:"reference"`
+	Headers     json.RawMessage `json:"headers"`
+	Body        string          `json:"body"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	LastError   sql.NullString  `json:"last_error"`
+	AvailableAt time.Time       `json:"available_at"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt sql.NullTime    `json:"processed_at"`
 }
@@ -10,32 +10,182 @@ import (
 	"database/sql"
 )
 
-const cancelOrder = `-- name: CancelOrder :exec
+const cancelOrder = `-- name: CancelOrder :one
 UPDATE orders
-SET fulfillment_status = 'canceled',
+SET payment_status = 'canceled',
+  fulfillment_status = 'canceled',
+  canceled_by = $1,
+  cancel_reason = $2,
+  canceled_at = CURRENT_TIMESTAMP,
   updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
+WHERE id = $3
   AND payment_status = 'pending'
   AND fulfillment_status = 'new'
+RETURNING id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at, canceled_by, cancel_reason, canceled_at
 `
 
-func (q *Queries) CancelOrder(ctx context.Context, id int32) error {
-	_, err := q.db.ExecContext(ctx, cancelOrder, id)
-	return err
+type CancelOrderParams struct {
+	CanceledBy   sql.NullInt32  `json:"canceled_by"`
+	CancelReason sql.NullString `json:"cancel_reason"`
+	ID           int32          `json:"id"`
+}
+
+func (q *Queries) CancelOrder(ctx context.Context, arg CancelOrderParams) (Order, error) {
+	row := q.db.QueryRowContext(ctx, cancelOrder, arg.CanceledBy, arg.CancelReason, arg.ID)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CustomerName,
+		&i.CustomerPhone,
+		&i.DeliveryAddress,
+		&i.OrderTotal,
+		&i.PaymentStatus,
+		&i.FulfillmentStatus,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CanceledBy,
+		&i.CancelReason,
+		&i.CanceledAt,
+	)
+	return i, err
 }
 
-const completeOrder = `-- name: CompleteOrder :exec
+const cancelPaidOrder = `-- name: CancelPaidOrder :one
+UPDATE orders
+SET payment_status = 'refund_pending',
+  fulfillment_status = 'canceled',
+  canceled_by = $1,
+  cancel_reason = $2,
+  canceled_at = CURRENT_TIMESTAMP,
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = $3
+  AND payment_status = 'paid'
+  AND fulfillment_status IN ('new', 'preparing')
+RETURNING id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at, canceled_by, cancel_reason, canceled_at
+`
+
+type CancelPaidOrderParams struct {
+	CanceledBy   sql.NullInt32  `json:"canceled_by"`
+	CancelReason sql.NullString `json:"cancel_reason"`
+	ID           int32          `json:"id"`
+}
+
+func (q *Queries) CancelPaidOrder(ctx context.Context, arg CancelPaidOrderParams) (Order, error) {
+	row := q.db.QueryRowContext(ctx, cancelPaidOrder, arg.CanceledBy, arg.CancelReason, arg.ID)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CustomerName,
+		&i.CustomerPhone,
+		&i.DeliveryAddress,
+		&i.OrderTotal,
+		&i.PaymentStatus,
+		&i.FulfillmentStatus,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CanceledBy,
+		&i.CancelReason,
+		&i.CanceledAt,
+	)
+	return i, err
+}
+
+const completeOrder = `-- name: CompleteOrder :execrows
 UPDATE orders
 SET fulfillment_status = 'completed',
   updated_at = CURRENT_TIMESTAMP
 WHERE id = $1
-  AND payment_status = 'paid'
+  AND payment_status IN ('paid', 'partially_refunded')
   AND fulfillment_status = 'delivering'
 `
 
-func (q *Queries) CompleteOrder(ctx context.Context, id int32) error {
-	_, err := q.db.ExecContext(ctx, completeOrder, id)
-	return err
+func (q *Queries) CompleteOrder(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, completeOrder, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const countOrders = `-- name: CountOrders :one
+SELECT COUNT(*)
+FROM orders
+WHERE (
+    $1::varchar IS NULL
+    OR payment_status = $1
+  )
+  AND (
+    $2::varchar IS NULL
+    OR fulfillment_status = $2
+  )
+  AND (
+    $3::timestamp IS NULL
+    OR created_at >= $3
+  )
+  AND (
+    $4::timestamp IS NULL
+    OR created_at < $4
+  )
+  AND (
+    $5::varchar IS NULL
+    OR customer_phone = $5
+  )
+  AND (
+    $6::varchar IS NULL
+    OR lower(customer_name) LIKE lower($6) || '%'
+  )
+`
+
+type CountOrdersParams struct {
+	PaymentStatus     sql.NullString `json:"payment_status"`
+	FulfillmentStatus sql.NullString `json:"fulfillment_status"`
+	CreatedFrom       sql.NullTime   `json:"created_from"`
+	CreatedTo         sql.NullTime   `json:"created_to"`
+	CustomerPhone     sql.NullString `json:"customer_phone"`
+	CustomerName      sql.NullString `json:"customer_name"`
+}
+
+func (q *Queries) CountOrders(ctx context.Context, arg CountOrdersParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countOrders,
+		arg.PaymentStatus,
+		arg.FulfillmentStatus,
+		arg.CreatedFrom,
+		arg.CreatedTo,
+		arg.CustomerPhone,
+		arg.CustomerName,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserOrders = `-- name: CountUserOrders :one
+SELECT COUNT(*)
+FROM orders
+WHERE user_id = $1
+  AND (
+    $2::varchar IS NULL
+    OR payment_status = $2
+  )
+  AND (
+    $3::varchar IS NULL
+    OR fulfillment_status = $3
+  )
+`
+
+type CountUserOrdersParams struct {
+	UserID            sql.NullInt32  `json:"user_id"`
+	PaymentStatus     sql.NullString `json:"payment_status"`
+	FulfillmentStatus sql.NullString `json:"fulfillment_status"`
+}
+
+func (q *Queries) CountUserOrders(ctx context.Context, arg CountUserOrdersParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUserOrders, arg.UserID, arg.PaymentStatus, arg.FulfillmentStatus)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
 }
 
 const createOrder = `-- name: CreateOrder :one
@@ -57,7 +207,7 @@ VALUES (
     $6,
     $7
   )
-RETURNING id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at
+RETURNING id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at, canceled_by, cancel_reason, canceled_at
 `
 
 type CreateOrderParams struct {
@@ -92,12 +242,15 @@ func (q *Queries) CreateOrder(ctx context.Context, arg CreateOrderParams) (Order
 		&i.FulfillmentStatus,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.CanceledBy,
+		&i.CancelReason,
+		&i.CanceledAt,
 	)
 	return i, err
 }
 
 const getAllOrders = `-- name: GetAllOrders :many
-SELECT id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at
+SELECT id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at, canceled_by, cancel_reason, canceled_at
 FROM orders
 ORDER BY created_at DESC
 LIMIT $2 OFFSET $1
@@ -128,6 +281,9 @@ func (q *Queries) GetAllOrders(ctx context.Context, arg GetAllOrdersParams) ([]O
 			&i.FulfillmentStatus,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.CanceledBy,
+			&i.CancelReason,
+			&i.CanceledAt,
 		); err != nil {
 			return nil, err
 		}
@@ -143,7 +299,7 @@ func (q *Queries) GetAllOrders(ctx context.Context, arg GetAllOrdersParams) ([]O
 }
 
 const getOrderById = `-- name: GetOrderById :one
-SELECT id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at
+SELECT id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at, canceled_by, cancel_reason, canceled_at
 FROM orders
 WHERE id = $1
 `
@@ -162,12 +318,15 @@ func (q *Queries) GetOrderById(ctx context.Context, id int32) (Order, error) {
 		&i.FulfillmentStatus,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.CanceledBy,
+		&i.CancelReason,
+		&i.CanceledAt,
 	)
 	return i, err
 }
 
 const getOrdersByUserId = `-- name: GetOrdersByUserId :many
-SELECT id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at
+SELECT id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at, canceled_by, cancel_reason, canceled_at
 FROM orders
 WHERE user_id = $1
 ORDER BY created_at DESC
@@ -193,6 +352,56 @@ func (q *Queries) GetOrdersByUserId(ctx context.Context, userID sql.NullInt32) (
 			&i.FulfillmentStatus,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.CanceledBy,
+			&i.CancelReason,
+			&i.CanceledAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOrdersToFulfill = `-- name: GetOrdersToFulfill :many
+SELECT id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at, canceled_by, cancel_reason, canceled_at
+FROM orders
+WHERE payment_status IN ('paid', 'partially_refunded')
+  AND fulfillment_status = $1
+ORDER BY created_at ASC
+`
+
+// Returns the paid orders at a fulfillment step, including the ones that
+// were refunded in part and are still being fulfilled.
+func (q *Queries) GetOrdersToFulfill(ctx context.Context, fulfillmentStatus string) ([]Order, error) {
+	rows, err := q.db.QueryContext(ctx, getOrdersToFulfill, fulfillmentStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Order
+	for rows.Next() {
+		var i Order
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CustomerName,
+			&i.CustomerPhone,
+			&i.DeliveryAddress,
+			&i.OrderTotal,
+			&i.PaymentStatus,
+			&i.FulfillmentStatus,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CanceledBy,
+			&i.CancelReason,
+			&i.CanceledAt,
 		); err != nil {
 			return nil, err
 		}
@@ -207,21 +416,109 @@ func (q *Queries) GetOrdersByUserId(ctx context.Context, userID sql.NullInt32) (
 	return items, nil
 }
 
-const markOrderDelivering = `-- name: MarkOrderDelivering :exec
+const listUserOrders = `-- name: ListUserOrders :many
+SELECT id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at, canceled_by, cancel_reason, canceled_at
+FROM orders
+WHERE user_id = $1
+  AND (
+    $2::varchar IS NULL
+    OR payment_status = $2
+  )
+  AND (
+    $3::varchar IS NULL
+    OR fulfillment_status = $3
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT $5 OFFSET $4
+`
+
+type ListUserOrdersParams struct {
+	UserID            sql.NullInt32  `json:"user_id"`
+	PaymentStatus     sql.NullString `json:"payment_status"`
+	FulfillmentStatus sql.NullString `json:"fulfillment_status"`
+	Offset            int32          `json:"offset"`
+	Limit             int32          `json:"limit"`
+}
+
+func (q *Queries) ListUserOrders(ctx context.Context, arg ListUserOrdersParams) ([]Order, error) {
+	rows, err := q.db.QueryContext(ctx, listUserOrders,
+		arg.UserID,
+		arg.PaymentStatus,
+		arg.FulfillmentStatus,
+		arg.Offset,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Order
+	for rows.Next() {
+		var i Order
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CustomerName,
+			&i.CustomerPhone,
+			&i.DeliveryAddress,
+			&i.OrderTotal,
+			&i.PaymentStatus,
+			&i.FulfillmentStatus,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CanceledBy,
+			&i.CancelReason,
+			&i.CanceledAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markCanceledOrderPaid = `-- name: MarkCanceledOrderPaid :execrows
+UPDATE orders
+SET payment_status = 'refund_pending',
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND payment_status = 'canceled'
+  AND fulfillment_status = 'canceled'
+`
+
+// A payment captured after its order was canceled has to be returned.
+func (q *Queries) MarkCanceledOrderPaid(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markCanceledOrderPaid, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const markOrderDelivering = `-- name: MarkOrderDelivering :execrows
 UPDATE orders
 SET fulfillment_status = 'delivering',
   updated_at = CURRENT_TIMESTAMP
 WHERE id = $1
-  AND payment_status = 'paid'
+  AND payment_status IN ('paid', 'partially_refunded')
   AND fulfillment_status = 'preparing'
 `
 
-func (q *Queries) MarkOrderDelivering(ctx context.Context, id int32) error {
-	_, err := q.db.ExecContext(ctx, markOrderDelivering, id)
-	return err
+func (q *Queries) MarkOrderDelivering(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markOrderDelivering, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const markOrderPaid = `-- name: MarkOrderPaid :exec
+const markOrderPaid = `-- name: MarkOrderPaid :execrows
 UPDATE orders
 SET payment_status = 'paid',
   updated_at = CURRENT_TIMESTAMP
@@ -229,12 +526,15 @@ WHERE id = $1
   AND payment_status = 'pending'
 `
 
-func (q *Queries) MarkOrderPaid(ctx context.Context, id int32) error {
-	_, err := q.db.ExecContext(ctx, markOrderPaid, id)
-	return err
+func (q *Queries) MarkOrderPaid(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markOrderPaid, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const markOrderPaymentExpired = `-- name: MarkOrderPaymentExpired :exec
+const markOrderPaymentExpired = `-- name: MarkOrderPaymentExpired :execrows
 UPDATE orders
 SET payment_status = 'expired',
   fulfillment_status = 'canceled',
@@ -244,12 +544,15 @@ WHERE id = $1
   AND fulfillment_status = 'new'
 `
 
-func (q *Queries) MarkOrderPaymentExpired(ctx context.Context, id int32) error {
-	_, err := q.db.ExecContext(ctx, markOrderPaymentExpired, id)
-	return err
+func (q *Queries) MarkOrderPaymentExpired(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markOrderPaymentExpired, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const markOrderPaymentFailed = `-- name: MarkOrderPaymentFailed :exec
+const markOrderPaymentFailed = `-- name: MarkOrderPaymentFailed :execrows
 UPDATE orders
 SET payment_status = 'failed',
   fulfillment_status = 'canceled',
@@ -259,23 +562,211 @@ WHERE id = $1
   AND fulfillment_status = 'new'
 `
 
-func (q *Queries) MarkOrderPaymentFailed(ctx context.Context, id int32) error {
-	_, err := q.db.ExecContext(ctx, markOrderPaymentFailed, id)
-	return err
+func (q *Queries) MarkOrderPaymentFailed(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markOrderPaymentFailed, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const markOrderRefunded = `-- name: MarkOrderRefunded :execrows
+UPDATE orders
+SET payment_status = $1,
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = $2
+  AND payment_status IN ('paid', 'refund_pending', 'partially_refunded')
+`
+
+type MarkOrderRefundedParams struct {
+	PaymentStatus string `json:"payment_status"`
+	ID            int32  `json:"id"`
+}
+
+func (q *Queries) MarkOrderRefunded(ctx context.Context, arg MarkOrderRefundedParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markOrderRefunded, arg.PaymentStatus, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const startPreparingOrder = `-- name: StartPreparingOrder :exec
+const reopenOrderPayment = `-- name: ReopenOrderPayment :execrows
+UPDATE orders
+SET payment_status = 'pending',
+  fulfillment_status = 'new',
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND payment_status IN ('pending', 'failed', 'expired')
+  AND fulfillment_status IN ('new', 'canceled')
+  AND canceled_at IS NULL
+`
+
+// Puts an order whose payment failed or expired back to awaiting payment.
+// Orders canceled by a user or admin stay canceled.
+func (q *Queries) ReopenOrderPayment(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, reopenOrderPayment, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const searchOrders = `-- name: SearchOrders :many
+SELECT id, user_id, customer_name, customer_phone, delivery_address, order_total, payment_status, fulfillment_status, created_at, updated_at, canceled_by, cancel_reason, canceled_at
+FROM orders
+WHERE (
+    $1::varchar IS NULL
+    OR payment_status = $1
+  )
+  AND (
+    $2::varchar IS NULL
+    OR fulfillment_status = $2
+  )
+  AND (
+    $3::timestamp IS NULL
+    OR created_at >= $3
+  )
+  AND (
+    $4::timestamp IS NULL
+    OR created_at < $4
+  )
+  AND (
+    $5::varchar IS NULL
+    OR customer_phone = $5
+  )
+  AND (
+    $6::varchar IS NULL
+    OR lower(customer_name) LIKE lower($6) || '%'
+  )
+  AND (
+    $7::int IS NULL
+    OR (
+      $8::text = 'created_at'
+      AND $9::bool
+      AND (created_at, id) < ($10::timestamp, $7::int)
+    )
+    OR (
+      $8::text = 'created_at'
+      AND NOT $9::bool
+      AND (created_at, id) > ($10::timestamp, $7::int)
+    )
+    OR (
+      $8::text = 'order_total'
+      AND $9::bool
+      AND (order_total, id) < ($11::int, $7::int)
+    )
+    OR (
+      $8::text = 'order_total'
+      AND NOT $9::bool
+      AND (order_total, id) > ($11::int, $7::int)
+    )
+  )
+ORDER BY CASE
+    WHEN $8::text = 'created_at'
+    AND $9::bool THEN created_at
+  END DESC,
+  CASE
+    WHEN $8::text = 'created_at'
+    AND NOT $9::bool THEN created_at
+  END ASC,
+  CASE
+    WHEN $8::text = 'order_total'
+    AND $9::bool THEN order_total
+  END DESC,
+  CASE
+    WHEN $8::text = 'order_total'
+    AND NOT $9::bool THEN order_total
+  END ASC,
+  CASE
+    WHEN $9::bool THEN id
+  END DESC,
+  CASE
+    WHEN NOT $9::bool THEN id
+  END ASC
+LIMIT $12
+`
+
+type SearchOrdersParams struct {
+	PaymentStatus     sql.NullString `json:"payment_status"`
+	FulfillmentStatus sql.NullString `json:"fulfillment_status"`
+	CreatedFrom       sql.NullTime   `json:"created_from"`
+	CreatedTo         sql.NullTime   `json:"created_to"`
+	CustomerPhone     sql.NullString `json:"customer_phone"`
+	CustomerName      sql.NullString `json:"customer_name"`
+	CursorID          sql.NullInt32  `json:"cursor_id"`
+	SortBy            string         `json:"sort_by"`
+	SortDesc          bool           `json:"sort_desc"`
+	CursorCreatedAt   sql.NullTime   `json:"cursor_created_at"`
+	CursorTotal       sql.NullInt32  `json:"cursor_total"`
+	Limit             int32          `json:"limit"`
+}
+
+func (q *Queries) SearchOrders(ctx context.Context, arg SearchOrdersParams) ([]Order, error) {
+	rows, err := q.db.QueryContext(ctx, searchOrders,
+		arg.PaymentStatus,
+		arg.FulfillmentStatus,
+		arg.CreatedFrom,
+		arg.CreatedTo,
+		arg.CustomerPhone,
+		arg.CustomerName,
+		arg.CursorID,
+		arg.SortBy,
+		arg.SortDesc,
+		arg.CursorCreatedAt,
+		arg.CursorTotal,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Order
+	for rows.Next() {
+		var i Order
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CustomerName,
+			&i.CustomerPhone,
+			&i.DeliveryAddress,
+			&i.OrderTotal,
+			&i.PaymentStatus,
+			&i.FulfillmentStatus,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CanceledBy,
+			&i.CancelReason,
+			&i.CanceledAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const startPreparingOrder = `-- name: StartPreparingOrder :execrows
 UPDATE orders
 SET fulfillment_status = 'preparing',
   updated_at = CURRENT_TIMESTAMP
 WHERE id = $1
-  AND payment_status = 'paid'
+  AND payment_status IN ('paid', 'partially_refunded')
   AND fulfillment_status = 'new'
 `
 
-func (q *Queries) StartPreparingOrder(ctx context.Context, id int32) error {
-	_, err := q.db.ExecContext(ctx, startPreparingOrder, id)
-	return err
+func (q *Queries) StartPreparingOrder(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, startPreparingOrder, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 const updateOrderTotal = `-- name: UpdateOrderTotal :exec
@@ -15,9 +15,12 @@ INSERT INTO payments (
     order_id,
     external_id,
     gateway_name,
+    payment_method,
     payment_channel,
     amount,
-    status
+    attempt,
+    status,
+    expires_at
   )
 VALUES (
     $1,
@@ -25,17 +28,23 @@ VALUES (
     $3,
     $4,
     $5,
-    'pending'
+    $6,
+    $7,
+    'pending',
+    CURRENT_TIMESTAMP + ($8::int * INTERVAL '1 second')
   )
-RETURNING id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at
+RETURNING id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at, payment_method, expires_at, attempt
 `
 
 type CreatePaymentParams struct {
 	OrderID        int32          `json:"order_id"`
 	ExternalID     string         `json:"external_id"`
 	GatewayName    string         `json:"gateway_name"`
+	PaymentMethod  sql.NullString `json:"payment_method"`
 	PaymentChannel sql.NullString `json:"payment_channel"`
 	Amount         int32          `json:"amount"`
+	Attempt        int32          `json:"attempt"`
+	TtlSeconds     sql.NullInt32  `json:"ttl_seconds"`
 }
 
 func (q *Queries) CreatePayment(ctx context.Context, arg CreatePaymentParams) (Payment, error) {
@@ -43,8 +52,11 @@ func (q *Queries) CreatePayment(ctx context.Context, arg CreatePaymentParams) (P
 		arg.OrderID,
 		arg.ExternalID,
 		arg.GatewayName,
+		arg.PaymentMethod,
 		arg.PaymentChannel,
 		arg.Amount,
+		arg.Attempt,
+		arg.TtlSeconds,
 	)
 	var i Payment
 	err := row.Scan(
@@ -59,24 +71,43 @@ func (q *Queries) CreatePayment(ctx context.Context, arg CreatePaymentParams) (P
 		&i.PaidAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.PaymentMethod,
+		&i.ExpiresAt,
+		&i.Attempt,
 	)
 	return i, err
 }
 
 const getAllPayments = `-- name: GetAllPayments :many
-SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at
+SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at, payment_method, expires_at, attempt
 FROM payments
-ORDER BY created_at DESC
-LIMIT $2 OFFSET $1
+WHERE (
+    $1::timestamp IS NULL
+    OR created_at >= $1
+  )
+  AND (
+    $2::timestamp IS NULL
+    OR created_at < $2
+  )
+ORDER BY created_at DESC,
+  id DESC
+LIMIT $4 OFFSET $3
 `
 
 type GetAllPaymentsParams struct {
-	Offset int32 `json:"offset"`
-	Limit  int32 `json:"limit"`
+	CreatedFrom sql.NullTime `json:"created_from"`
+	CreatedTo   sql.NullTime `json:"created_to"`
+	Offset      int32        `json:"offset"`
+	Limit       int32        `json:"limit"`
 }
 
 func (q *Queries) GetAllPayments(ctx context.Context, arg GetAllPaymentsParams) ([]Payment, error) {
-	rows, err := q.db.QueryContext(ctx, getAllPayments, arg.Offset, arg.Limit)
+	rows, err := q.db.QueryContext(ctx, getAllPayments,
+		arg.CreatedFrom,
+		arg.CreatedTo,
+		arg.Offset,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -96,6 +127,111 @@ func (q *Queries) GetAllPayments(ctx context.Context, arg GetAllPaymentsParams)
 			&i.PaidAt,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.PaymentMethod,
+			&i.ExpiresAt,
+			&i.Attempt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCanceledOrderPayments = `-- name: GetCanceledOrderPayments :many
+SELECT p.id, p.order_id, p.external_id, p.gateway_transaction_id, p.gateway_name, p.amount, p.payment_channel, p.status, p.paid_at, p.created_at, p.updated_at, p.payment_method, p.expires_at, p.attempt
+FROM payments p
+  JOIN orders o ON o.id = p.order_id
+WHERE p.status = 'pending'
+  AND o.payment_status = 'canceled'
+ORDER BY p.created_at ASC
+LIMIT $1
+`
+
+// Returns pending payments of canceled orders, whose gateway requests still
+// have to be voided.
+func (q *Queries) GetCanceledOrderPayments(ctx context.Context, limit int32) ([]Payment, error) {
+	rows, err := q.db.QueryContext(ctx, getCanceledOrderPayments, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Payment
+	for rows.Next() {
+		var i Payment
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.ExternalID,
+			&i.GatewayTransactionID,
+			&i.GatewayName,
+			&i.Amount,
+			&i.PaymentChannel,
+			&i.Status,
+			&i.PaidAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.PaymentMethod,
+			&i.ExpiresAt,
+			&i.Attempt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOverduePayments = `-- name: GetOverduePayments :many
+SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at, payment_method, expires_at, attempt
+FROM payments
+WHERE status = 'pending'
+  AND expires_at < CURRENT_TIMESTAMP - ($1::int * INTERVAL '1 second')
+ORDER BY expires_at ASC
+LIMIT $2
+`
+
+type GetOverduePaymentsParams struct {
+	GraceSeconds int32 `json:"grace_seconds"`
+	Limit        int32 `json:"limit"`
+}
+
+func (q *Queries) GetOverduePayments(ctx context.Context, arg GetOverduePaymentsParams) ([]Payment, error) {
+	rows, err := q.db.QueryContext(ctx, getOverduePayments, arg.GraceSeconds, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Payment
+	for rows.Next() {
+		var i Payment
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.ExternalID,
+			&i.GatewayTransactionID,
+			&i.GatewayName,
+			&i.Amount,
+			&i.PaymentChannel,
+			&i.Status,
+			&i.PaidAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.PaymentMethod,
+			&i.ExpiresAt,
+			&i.Attempt,
 		); err != nil {
 			return nil, err
 		}
@@ -111,7 +247,7 @@ func (q *Queries) GetAllPayments(ctx context.Context, arg GetAllPaymentsParams)
 }
 
 const getPaymentByExternalID = `-- name: GetPaymentByExternalID :many
-SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at
+SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at, payment_method, expires_at, attempt
 FROM payments
 WHERE external_id = $1
 ORDER BY created_at DESC
@@ -145,6 +281,9 @@ func (q *Queries) GetPaymentByExternalID(ctx context.Context, arg GetPaymentByEx
 			&i.PaidAt,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.PaymentMethod,
+			&i.ExpiresAt,
+			&i.Attempt,
 		); err != nil {
 			return nil, err
 		}
@@ -159,11 +298,40 @@ func (q *Queries) GetPaymentByExternalID(ctx context.Context, arg GetPaymentByEx
 	return items, nil
 }
 
+const getPaymentByID = `-- name: GetPaymentByID :one
+SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at, payment_method, expires_at, attempt
+FROM payments
+WHERE id = $1
+`
+
+func (q *Queries) GetPaymentByID(ctx context.Context, id int32) (Payment, error) {
+	row := q.db.QueryRowContext(ctx, getPaymentByID, id)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.ExternalID,
+		&i.GatewayTransactionID,
+		&i.GatewayName,
+		&i.Amount,
+		&i.PaymentChannel,
+		&i.Status,
+		&i.PaidAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PaymentMethod,
+		&i.ExpiresAt,
+		&i.Attempt,
+	)
+	return i, err
+}
+
 const getPaymentsByOrderID = `-- name: GetPaymentsByOrderID :many
-SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at
+SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at, payment_method, expires_at, attempt
 FROM payments
 WHERE order_id = $1
-ORDER BY created_at DESC
+ORDER BY attempt DESC,
+  created_at DESC
 LIMIT $3 OFFSET $2
 `
 
@@ -194,6 +362,9 @@ func (q *Queries) GetPaymentsByOrderID(ctx context.Context, arg GetPaymentsByOrd
 			&i.PaidAt,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.PaymentMethod,
+			&i.ExpiresAt,
+			&i.Attempt,
 		); err != nil {
 			return nil, err
 		}
@@ -208,7 +379,84 @@ func (q *Queries) GetPaymentsByOrderID(ctx context.Context, arg GetPaymentsByOrd
 	return items, nil
 }
 
-const markPaymentExpired = `-- name: MarkPaymentExpired :exec
+const lockPaymentByExternalID = `-- name: LockPaymentByExternalID :one
+SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at, payment_method, expires_at, attempt
+FROM payments
+WHERE external_id = $1
+FOR UPDATE
+`
+
+// Locks the payment so status changes for the same payment request are
+// applied one at a time.
+func (q *Queries) LockPaymentByExternalID(ctx context.Context, externalID string) (Payment, error) {
+	row := q.db.QueryRowContext(ctx, lockPaymentByExternalID, externalID)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.ExternalID,
+		&i.GatewayTransactionID,
+		&i.GatewayName,
+		&i.Amount,
+		&i.PaymentChannel,
+		&i.Status,
+		&i.PaidAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PaymentMethod,
+		&i.ExpiresAt,
+		&i.Attempt,
+	)
+	return i, err
+}
+
+const lockPaymentByID = `-- name: LockPaymentByID :one
+SELECT id, order_id, external_id, gateway_transaction_id, gateway_name, amount, payment_channel, status, paid_at, created_at, updated_at, payment_method, expires_at, attempt
+FROM payments
+WHERE id = $1
+FOR UPDATE
+`
+
+// Locks a payment so refunds of it are applied one at a time.
+func (q *Queries) LockPaymentByID(ctx context.Context, id int32) (Payment, error) {
+	row := q.db.QueryRowContext(ctx, lockPaymentByID, id)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.ExternalID,
+		&i.GatewayTransactionID,
+		&i.GatewayName,
+		&i.Amount,
+		&i.PaymentChannel,
+		&i.Status,
+		&i.PaidAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PaymentMethod,
+		&i.ExpiresAt,
+		&i.Attempt,
+	)
+	return i, err
+}
+
+const markPaymentCanceled = `-- name: MarkPaymentCanceled :execrows
+UPDATE payments
+SET status = 'canceled',
+  updated_at = CURRENT_TIMESTAMP
+WHERE external_id = $1
+  AND status = 'pending'
+`
+
+func (q *Queries) MarkPaymentCanceled(ctx context.Context, externalID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markPaymentCanceled, externalID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const markPaymentExpired = `-- name: MarkPaymentExpired :execrows
 UPDATE payments
 SET status = 'expired',
   updated_at = CURRENT_TIMESTAMP
@@ -216,12 +464,15 @@ WHERE external_id = $1
   AND status = 'pending'
 `
 
-func (q *Queries) MarkPaymentExpired(ctx context.Context, externalID string) error {
-	_, err := q.db.ExecContext(ctx, markPaymentExpired, externalID)
-	return err
+func (q *Queries) MarkPaymentExpired(ctx context.Context, externalID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markPaymentExpired, externalID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const markPaymentFailed = `-- name: MarkPaymentFailed :exec
+const markPaymentFailed = `-- name: MarkPaymentFailed :execrows
 UPDATE payments
 SET status = 'failed',
   updated_at = CURRENT_TIMESTAMP
@@ -229,20 +480,23 @@ WHERE external_id = $1
   AND status = 'pending'
 `
 
-func (q *Queries) MarkPaymentFailed(ctx context.Context, externalID string) error {
-	_, err := q.db.ExecContext(ctx, markPaymentFailed, externalID)
-	return err
+func (q *Queries) MarkPaymentFailed(ctx context.Context, externalID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markPaymentFailed, externalID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const markPaymentPaid = `-- name: MarkPaymentPaid :exec
+const markPaymentPaid = `-- name: MarkPaymentPaid :execrows
 UPDATE payments
 SET status = 'paid',
-  payment_channel = $1,
-  gateway_transaction_id = $2,
+  payment_channel = COALESCE($1, payment_channel),
+  gateway_transaction_id = COALESCE($2, gateway_transaction_id),
   paid_at = CURRENT_TIMESTAMP,
   updated_at = CURRENT_TIMESTAMP
 WHERE external_id = $3
-  AND status = 'pending'
+  AND status IN ('pending', 'canceled')
 `
 
 type MarkPaymentPaidParams struct {
@@ -251,12 +505,36 @@ type MarkPaymentPaidParams struct {
 	ExternalID           string         `json:"external_id"`
 }
 
-func (q *Queries) MarkPaymentPaid(ctx context.Context, arg MarkPaymentPaidParams) error {
-	_, err := q.db.ExecContext(ctx, markPaymentPaid, arg.PaymentChannel, arg.GatewayTransactionID, arg.ExternalID)
-	return err
+func (q *Queries) MarkPaymentPaid(ctx context.Context, arg MarkPaymentPaidParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markPaymentPaid, arg.PaymentChannel, arg.GatewayTransactionID, arg.ExternalID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const markPaymentRefunded = `-- name: MarkPaymentRefunded :execrows
+UPDATE payments
+SET status = $1,
+  updated_at = CURRENT_TIMESTAMP
+WHERE id = $2
+  AND status IN ('paid', 'settled', 'partially_refunded', 'captured_superseded')
+`
+
+type MarkPaymentRefundedParams struct {
+	Status string `json:"status"`
+	ID     int32  `json:"id"`
+}
+
+func (q *Queries) MarkPaymentRefunded(ctx context.Context, arg MarkPaymentRefundedParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markPaymentRefunded, arg.Status, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const markPaymentSettled = `-- name: MarkPaymentSettled :exec
+const markPaymentSettled = `-- name: MarkPaymentSettled :execrows
 UPDATE payments
 SET status = 'settled',
   updated_at = CURRENT_TIMESTAMP
@@ -264,7 +542,53 @@ WHERE external_id = $1
   AND status = 'paid'
 `
 
-func (q *Queries) MarkPaymentSettled(ctx context.Context, externalID string) error {
-	_, err := q.db.ExecContext(ctx, markPaymentSettled, externalID)
-	return err
+func (q *Queries) MarkPaymentSettled(ctx context.Context, externalID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markPaymentSettled, externalID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const markPaymentSuperseded = `-- name: MarkPaymentSuperseded :execrows
+UPDATE payments
+SET status = 'superseded',
+  updated_at = CURRENT_TIMESTAMP
+WHERE external_id = $1
+  AND status = 'pending'
+`
+
+func (q *Queries) MarkPaymentSuperseded(ctx context.Context, externalID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markPaymentSuperseded, externalID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const markSupersededPaymentCaptured = `-- name: MarkSupersededPaymentCaptured :execrows
+UPDATE payments
+SET status = 'captured_superseded',
+  payment_channel = COALESCE($1, payment_channel),
+  gateway_transaction_id = COALESCE($2, gateway_transaction_id),
+  paid_at = CURRENT_TIMESTAMP,
+  updated_at = CURRENT_TIMESTAMP
+WHERE external_id = $3
+  AND status = 'superseded'
+`
+
+type MarkSupersededPaymentCapturedParams struct {
+	PaymentChannel       sql.NullString `json:"payment_channel"`
+	GatewayTransactionID sql.NullString `json:"gateway_transaction_id"`
+	ExternalID           string         `json:"external_id"`
+}
+
+// Records money captured on an attempt that was replaced by a retry. The
+// order is paid by another attempt, if at all, so this money is refunded.
+func (q *Queries) MarkSupersededPaymentCaptured(ctx context.Context, arg MarkSupersededPaymentCapturedParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markSupersededPaymentCaptured, arg.PaymentChannel, arg.GatewayTransactionID, arg.ExternalID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
@@ -10,28 +10,139 @@ import (
 )
 
 type Querier interface {
-	CancelOrder(ctx context.Context, id int32) error
-	CompleteOrder(ctx context.Context, id int32) error
+	CancelOrder(ctx context.Context, arg CancelOrderParams) (Order, error)
+	CancelPaidOrder(ctx context.Context, arg CancelPaidOrderParams) (Order, error)
+	// Claims a key for a new request. A key is taken over once it expired, or
+	// when the request holding it stopped without finishing and its lease ran
+	// out; the latter only for the same request.
+	ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (IdempotencyKey, error)
+	// Leases the oldest pending event of each aggregate. Later events of the same
+	// aggregate stay hidden until the earlier one leaves the pending state, which
+	// keeps delivery ordered per aggregate.
+	ClaimOutboxEvents(ctx context.Context, arg ClaimOutboxEventsParams) ([]OutboxEvent, error)
+	// Leases the oldest pending event of each reference, so callbacks for one
+	// payment are applied in the order they were received.
+	ClaimWebhookEvents(ctx context.Context, arg ClaimWebhookEventsParams) ([]WebhookEvent, error)
+	CommitOrderStock(ctx context.Context, orderID int32) ([]CommitOrderStockRow, error)
+	CompensateCheckoutSaga(ctx context.Context, arg CompensateCheckoutSagaParams) (int64, error)
+	CompleteCheckoutSaga(ctx context.Context, orderID int32) (int64, error)
+	CompleteOrder(ctx context.Context, id int32) (int64, error)
+	CompleteRefund(ctx context.Context, arg CompleteRefundParams) (int64, error)
+	// Marks an order's committed stock as handed to the menu service, whose
+	// stock levels include it from then on.
+	ConfirmOrderStock(ctx context.Context, orderID int32) (int64, error)
+	CountOrders(ctx context.Context, arg CountOrdersParams) (int64, error)
+	CountUserOrders(ctx context.Context, arg CountUserOrdersParams) (int64, error)
+	CreateCheckoutSaga(ctx context.Context, arg CreateCheckoutSagaParams) error
 	CreateOrder(ctx context.Context, arg CreateOrderParams) (Order, error)
 	CreateOrderItem(ctx context.Context, arg CreateOrderItemParams) (OrderItem, error)
 	CreateOrderItemModifier(ctx context.Context, arg CreateOrderItemModifierParams) (OrderItemModifier, error)
+	CreateOrderStatusEvent(ctx context.Context, arg CreateOrderStatusEventParams) error
+	CreateOutboxEvent(ctx context.Context, arg CreateOutboxEventParams) error
 	CreatePayment(ctx context.Context, arg CreatePaymentParams) (Payment, error)
+	CreateReconciliationMismatch(ctx context.Context, arg CreateReconciliationMismatchParams) error
+	CreateReconciliationRun(ctx context.Context, arg CreateReconciliationRunParams) (ReconciliationRun, error)
+	CreateRefund(ctx context.Context, arg CreateRefundParams) (Refund, error)
+	// Stores a received callback. A redelivery of an event that is already
+	// stored is dropped and affects no rows.
+	CreateWebhookEvent(ctx context.Context, arg CreateWebhookEventParams) (int64, error)
+	DeleteIdempotencyKey(ctx context.Context, arg DeleteIdempotencyKeyParams) error
+	FinishReconciliationRun(ctx context.Context, arg FinishReconciliationRunParams) error
 	GetAllOrderItems(ctx context.Context, orderID int32) ([]GetAllOrderItemsRow, error)
 	GetAllOrders(ctx context.Context, arg GetAllOrdersParams) ([]Order, error)
 	GetAllPayments(ctx context.Context, arg GetAllPaymentsParams) ([]Payment, error)
+	// Returns pending payments of canceled orders, whose gateway requests still
+	// have to be voided.
+	GetCanceledOrderPayments(ctx context.Context, limit int32) ([]Payment, error)
+	GetFailedOutboxEvents(ctx context.Context, arg GetFailedOutboxEventsParams) ([]OutboxEvent, error)
+	GetFailedWebhookEvents(ctx context.Context, arg GetFailedWebhookEventsParams) ([]WebhookEvent, error)
+	GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error)
 	GetOrderById(ctx context.Context, id int32) (Order, error)
+	GetOrderStatusEvents(ctx context.Context, orderID int32) ([]OrderStatusEvent, error)
+	GetOrderStockReservations(ctx context.Context, orderID int32) ([]StockReservation, error)
+	// Returns orders that wait for a refund nobody requested yet, e.g. because
+	// the request failed after the order was canceled. Orders whose refund was
+	// refused max_attempts times are left for an admin.
+	GetOrdersAwaitingRefund(ctx context.Context, arg GetOrdersAwaitingRefundParams) ([]int32, error)
 	GetOrdersByUserId(ctx context.Context, userID sql.NullInt32) ([]Order, error)
+	// Returns the paid orders at a fulfillment step, including the ones that
+	// were refunded in part and are still being fulfilled.
+	GetOrdersToFulfill(ctx context.Context, fulfillmentStatus string) ([]Order, error)
+	GetOverduePayments(ctx context.Context, arg GetOverduePaymentsParams) ([]Payment, error)
 	GetPaymentByExternalID(ctx context.Context, arg GetPaymentByExternalIDParams) ([]Payment, error)
+	GetPaymentByID(ctx context.Context, id int32) (Payment, error)
 	GetPaymentsByOrderID(ctx context.Context, arg GetPaymentsByOrderIDParams) ([]Payment, error)
-	MarkOrderDelivering(ctx context.Context, id int32) error
-	MarkOrderPaid(ctx context.Context, id int32) error
-	MarkOrderPaymentExpired(ctx context.Context, id int32) error
-	MarkOrderPaymentFailed(ctx context.Context, id int32) error
-	MarkPaymentExpired(ctx context.Context, externalID string) error
-	MarkPaymentFailed(ctx context.Context, externalID string) error
-	MarkPaymentPaid(ctx context.Context, arg MarkPaymentPaidParams) error
-	MarkPaymentSettled(ctx context.Context, externalID string) error
-	StartPreparingOrder(ctx context.Context, id int32) error
+	GetRefundByGatewayRefundID(ctx context.Context, gatewayRefundID sql.NullString) (Refund, error)
+	GetRefundByID(ctx context.Context, id int32) (Refund, error)
+	GetRefundedAmount(ctx context.Context, paymentID int32) (int32, error)
+	GetRefundsByOrderID(ctx context.Context, orderID int32) ([]Refund, error)
+	// Sums the refunds that are pending or succeeded, i.e. the part of the
+	// payment that can no longer be refunded.
+	GetReservedRefundAmount(ctx context.Context, paymentID int32) (int32, error)
+	GetStalledCheckoutSagas(ctx context.Context, arg GetStalledCheckoutSagasParams) ([]GetStalledCheckoutSagasRow, error)
+	// Returns payments captured on superseded attempts that still have to be
+	// refunded. Payments whose refund was refused max_attempts times are left
+	// for an admin.
+	GetSupersededCaptures(ctx context.Context, arg GetSupersededCapturesParams) ([]Payment, error)
+	// Returns pending refunds the gateway has not confirmed receiving, e.g.
+	// because the process stopped before the request was sent.
+	GetUnsentRefunds(ctx context.Context, arg GetUnsentRefundsParams) ([]Refund, error)
+	ListUserOrders(ctx context.Context, arg ListUserOrdersParams) ([]Order, error)
+	// Serializes reservations of a menu until the transaction ends, so two
+	// orders cannot both take the last portion.
+	LockMenuStock(ctx context.Context, menuID int32) error
+	// Locks the payment so status changes for the same payment request are
+	// applied one at a time.
+	LockPaymentByExternalID(ctx context.Context, externalID string) (Payment, error)
+	// Locks a payment so refunds of it are applied one at a time.
+	LockPaymentByID(ctx context.Context, id int32) (Payment, error)
+	// Locks the captured payment of an order so concurrent refunds are checked
+	// against the same total.
+	LockRefundablePayment(ctx context.Context, orderID int32) (Payment, error)
+	// A payment captured after its order was canceled has to be returned.
+	MarkCanceledOrderPaid(ctx context.Context, id int32) (int64, error)
+	MarkOrderDelivering(ctx context.Context, id int32) (int64, error)
+	MarkOrderPaid(ctx context.Context, id int32) (int64, error)
+	MarkOrderPaymentExpired(ctx context.Context, id int32) (int64, error)
+	MarkOrderPaymentFailed(ctx context.Context, id int32) (int64, error)
+	MarkOrderRefunded(ctx context.Context, arg MarkOrderRefundedParams) (int64, error)
+	MarkOutboxEventPublished(ctx context.Context, id int64) error
+	MarkPaymentCanceled(ctx context.Context, externalID string) (int64, error)
+	MarkPaymentExpired(ctx context.Context, externalID string) (int64, error)
+	MarkPaymentFailed(ctx context.Context, externalID string) (int64, error)
+	MarkPaymentPaid(ctx context.Context, arg MarkPaymentPaidParams) (int64, error)
+	MarkPaymentRefunded(ctx context.Context, arg MarkPaymentRefundedParams) (int64, error)
+	MarkPaymentSettled(ctx context.Context, externalID string) (int64, error)
+	MarkPaymentSuperseded(ctx context.Context, externalID string) (int64, error)
+	// Records money captured on an attempt that was replaced by a retry. The
+	// order is paid by another attempt, if at all, so this money is refunded.
+	MarkSupersededPaymentCaptured(ctx context.Context, arg MarkSupersededPaymentCapturedParams) (int64, error)
+	MarkWebhookEventProcessed(ctx context.Context, id int64) error
+	PurgeExpiredIdempotencyKeys(ctx context.Context, limit int32) (int64, error)
+	RecordCheckoutSagaFailure(ctx context.Context, arg RecordCheckoutSagaFailureParams) error
+	RecordOutboxEventFailure(ctx context.Context, arg RecordOutboxEventFailureParams) error
+	RecordWebhookEventFailure(ctx context.Context, arg RecordWebhookEventFailureParams) error
+	ReleaseOrderStock(ctx context.Context, orderID int32) (int64, error)
+	// Releases holds that outlived their expiry, and holds of orders that ended
+	// without being paid but missed their release.
+	ReleaseStaleStock(ctx context.Context) (int64, error)
+	// Holds a reservation again for as long as it was first held. A released
+	// reservation is only held again if the stock level it was made against
+	// still covers it. Must run after LockMenuStock.
+	RenewStockReservation(ctx context.Context, id int32) (int64, error)
+	// Puts an order whose payment failed or expired back to awaiting payment.
+	// Orders canceled by a user or admin stay canceled.
+	ReopenOrderPayment(ctx context.Context, id int32) (int64, error)
+	ReplayOutboxEvent(ctx context.Context, id int64) (int64, error)
+	ReplayWebhookEvent(ctx context.Context, id int64) (int64, error)
+	// Holds stock for an order unless the holds on the menu, together with the
+	// committed stock the menu service has not deducted yet, would exceed the
+	// stock level the menu service reported. Must run after LockMenuStock.
+	ReserveStock(ctx context.Context, arg ReserveStockParams) (int64, error)
+	SaveIdempotencyResponse(ctx context.Context, arg SaveIdempotencyResponseParams) error
+	SearchOrders(ctx context.Context, arg SearchOrdersParams) ([]Order, error)
+	SetRefundGatewayID(ctx context.Context, arg SetRefundGatewayIDParams) error
+	StartPreparingOrder(ctx context.Context, id int32) (int64, error)
 	UpdateOrderTotal(ctx context.Context, arg UpdateOrderTotalParams) error
 }
 
@@ -0,0 +1,262 @@
+package checkout
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/payments"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
+)
+
+// svc runs order creation as a saga: the order and its saga row are written
+// first, then the gateway request is made, and the payment record is stored
+// together with closing the saga. Any step that fails is undone, and sagas
+// left half-done by a crash are picked up by the Worker.
+type svc struct {
+	orders   orders.OrderRepository
+	payments payments.PaymentService
+	gateways *paymentgateway.Registry
+	// paymentTTL is how long the customer has to pay; zero leaves it to the
+	// gateway's default.
+	paymentTTL time.Duration
+	// maxAttempts bounds the payment attempts of an order, and retryWindow
+	// how long after it was placed an order may still be paid again.
+	maxAttempts int
+	retryWindow time.Duration
+}
+
+func NewService(
+	orderRepo orders.OrderRepository,
+	paymentService payments.PaymentService,
+	gateways *paymentgateway.Registry,
+	paymentTTL time.Duration,
+	maxAttempts int,
+	retryWindow time.Duration,
+) *svc {
+	return &svc{
+		orders:      orderRepo,
+		payments:    paymentService,
+		gateways:    gateways,
+		paymentTTL:  paymentTTL,
+		maxAttempts: maxAttempts,
+		retryWindow: retryWindow,
+	}
+}
+
+func (s *svc) PlaceOrder(ctx context.Context, input orders.CreateOrderInput, method paymentgateway.PaymentMethod) (*Result, error) {
+	gatewayName, gateway, err := s.gateways.Resolve(input.GatewayName)
+	if err != nil {
+		return nil, err
+	}
+	input.GatewayName = gatewayName
+
+	if method.Type == "" {
+		method.Type = paymentgateway.MethodQRIS
+	}
+	method.Channel = strings.ToUpper(method.Channel)
+
+	if err := gateway.SupportsMethod(method); err != nil {
+		return nil, err
+	}
+
+	order, err := s.orders.Create(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("create order: %w", err)
+	}
+
+	var expiresAt time.Time
+	if s.paymentTTL > 0 {
+		expiresAt = time.Now().Add(s.paymentTTL)
+	}
+
+	paymentRequest, err := gateway.CreatePaymentRequest(ctx, paymentgateway.CreatePaymentInput{
+		Amount:       order.Total,
+		ReferenceID:  fmt.Sprint(order.ID),
+		CustomerName: order.CustomerName,
+		Method:       method,
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		s.compensate(ctx, gateway, order.ID, "", err)
+		return nil, fmt.Errorf("create payment request: %w", err)
+	}
+
+	if _, err := s.payments.CompleteCheckout(ctx, payments.CreatePaymentInput{
+		OrderID:        order.ID,
+		ExternalID:     paymentRequest.GatewayID,
+		GatewayName:    gatewayName,
+		PaymentMethod:  method.Type,
+		PaymentChannel: method.Channel,
+		Amount:         order.Total,
+		TTL:            s.paymentTTL,
+	}); err != nil {
+		s.compensate(ctx, gateway, order.ID, paymentRequest.GatewayID, err)
+		return nil, fmt.Errorf("create payment record: %w", err)
+	}
+
+	return &Result{
+		Order:     order,
+		GatewayID: paymentRequest.GatewayID,
+		Action:    paymentRequest.Action,
+	}, nil
+}
+
+// RetryPayment creates a new payment attempt for an order whose payment
+// failed or expired, or replaces a pending one, e.g. to switch channels. The
+// new gateway request is made before the pending one is voided, so the
+// customer is never left without a way to pay; if voiding fails because the
+// old request was paid meanwhile, the new one is voided instead. Concurrent
+// retries end up with the same attempt: one stores it and the others fail
+// with payments.ErrAttemptConflict or ErrInvalidPaymentStatus.
+func (s *svc) RetryPayment(ctx context.Context, input RetryPaymentInput) (*Attempt, error) {
+	detail, err := s.orders.GetUserOrderDetails(ctx, input.Actor, input.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	order := detail.Order
+	switch {
+	case order.CanceledAt != nil:
+		return nil, orders.ErrInvalidOrderStatus
+	case order.PaymentStatus != "pending" && order.PaymentStatus != "failed" && order.PaymentStatus != "expired":
+		return nil, orders.ErrInvalidOrderStatus
+	case s.retryWindow > 0 && time.Since(order.CreatedAt) > s.retryWindow:
+		return nil, ErrRetryWindowClosed
+	}
+
+	previous := detail.Payment
+	attempt := 1
+	gatewayName := ""
+	if previous != nil {
+		attempt = previous.Attempt + 1
+		gatewayName = previous.GatewayName
+	}
+	if attempt > s.maxAttempts {
+		return nil, ErrTooManyAttempts
+	}
+
+	gatewayName, gateway, err := s.gateways.Resolve(gatewayName)
+	if err != nil {
+		return nil, err
+	}
+
+	method := input.Method
+	if method.Type == "" {
+		method.Type = paymentgateway.MethodQRIS
+	}
+	method.Channel = strings.ToUpper(method.Channel)
+
+	if err := gateway.SupportsMethod(method); err != nil {
+		return nil, err
+	}
+
+	var expiresAt time.Time
+	if s.paymentTTL > 0 {
+		expiresAt = time.Now().Add(s.paymentTTL)
+	}
+
+	paymentRequest, err := gateway.CreatePaymentRequest(ctx, paymentgateway.CreatePaymentInput{
+		Amount:         order.Total,
+		ReferenceID:    fmt.Sprint(order.ID),
+		IdempotencyKey: fmt.Sprintf("%d-attempt-%d", order.ID, attempt),
+		CustomerName:   order.CustomerName,
+		Method:         method,
+		ExpiresAt:      expiresAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create payment request: %w", err)
+	}
+
+	var supersedes string
+	if previous != nil && previous.Status == "pending" {
+		// A concurrent retry may have voided the previous request already,
+		// which is fine; only a request that can still be paid blocks this
+		// attempt.
+		if err := gateway.CancelPaymentRequest(ctx, previous.ExternalID); err != nil && !s.isClosed(ctx, gateway, previous.ExternalID) {
+			s.voidAttempt(ctx, gateway, order.ID, paymentRequest.GatewayID)
+			return nil, fmt.Errorf("void previous payment request: %w", err)
+		}
+		supersedes = previous.ExternalID
+	}
+
+	payment, err := s.payments.RetryPayment(ctx, payments.RetryPaymentInput{
+		Payment: payments.CreatePaymentInput{
+			OrderID:        order.ID,
+			ExternalID:     paymentRequest.GatewayID,
+			GatewayName:    gatewayName,
+			PaymentMethod:  method.Type,
+			PaymentChannel: method.Channel,
+			Amount:         order.Total,
+			TTL:            s.paymentTTL,
+			Attempt:        attempt,
+		},
+		Supersedes: supersedes,
+		Actor:      input.Actor,
+	})
+	if err != nil {
+		s.voidAttempt(ctx, gateway, order.ID, paymentRequest.GatewayID)
+		return nil, err
+	}
+
+	return &Attempt{
+		Payment: payment,
+		Action:  paymentRequest.Action,
+	}, nil
+}
+
+// voidAttempt cancels a gateway request whose payment attempt could not be
+// stored. Concurrent retries of an order compute the same attempt and so
+// share its gateway request; if another retry stored it, it is kept. Failures
+// are only logged; an unrecorded request is reported by reconciliation.
+func (s *svc) voidAttempt(ctx context.Context, gateway paymentgateway.PaymentGateway, orderID int, gatewayID string) {
+	ctx = context.WithoutCancel(ctx)
+
+	if _, err := s.payments.GetPaymentByGatewayID(ctx, gatewayID); err == nil {
+		log.Printf("order %d: payment request %s was stored by a concurrent retry, keeping it", orderID, gatewayID)
+		return
+	}
+
+	if err := gateway.CancelPaymentRequest(ctx, gatewayID); err != nil {
+		log.Printf("order %d: void payment request %s: %v", orderID, gatewayID, err)
+	}
+}
+
+// isClosed reports whether the gateway confirms a request can no longer be
+// paid.
+func (s *svc) isClosed(ctx context.Context, gateway paymentgateway.PaymentGateway, gatewayID string) bool {
+	remote, err := gateway.GetPaymentStatus(ctx, gatewayID)
+	if err != nil {
+		return false
+	}
+	return remote.Status != paymentgateway.StatusPending && remote.Status != paymentgateway.StatusPaid
+}
+
+// compensate voids the gateway request, if one was made, and cancels the
+// order. It runs detached from the request context so a client disconnect
+// does not leave the saga half undone. Failures are left for the Worker.
+func (s *svc) compensate(ctx context.Context, gateway paymentgateway.PaymentGateway, orderID int, gatewayID string, cause error) {
+	ctx = context.WithoutCancel(ctx)
+
+	if gatewayID != "" {
+		if err := gateway.CancelPaymentRequest(ctx, gatewayID); err != nil {
+			log.Printf("checkout %d: void payment request %s: %v", orderID, gatewayID, err)
+			s.recordFailure(ctx, orderID, err)
+			return
+		}
+	}
+
+	if err := s.orders.CompensateCheckout(ctx, orderID, cause.Error()); err != nil {
+		log.Printf("checkout %d: compensate: %v", orderID, err)
+		s.recordFailure(ctx, orderID, err)
+	}
+}
+
+func (s *svc) recordFailure(ctx context.Context, orderID int, cause error) {
+	if err := s.orders.RecordCheckoutFailure(ctx, orderID, cause.Error()); err != nil {
+		log.Printf("checkout %d: record failure: %v", orderID, err)
+	}
+}
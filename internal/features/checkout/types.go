@@ -0,0 +1,45 @@
+package checkout
+
+import (
+	"context"
+	"errors"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/payments"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
+)
+
+var (
+	ErrTooManyAttempts   = errors.New("order has no payment attempts left")
+	ErrRetryWindowClosed = errors.New("order is too old to retry payment")
+)
+
+// Result is a placed order together with the gateway request the customer
+// has to pay and what they need to do to pay it.
+type Result struct {
+	Order     *orders.Order                `json:"order"`
+	GatewayID string                       `json:"gateway_id"`
+	Action    paymentgateway.PaymentAction `json:"action"`
+}
+
+// RetryPaymentInput asks for a new payment attempt on an existing order.
+type RetryPaymentInput struct {
+	OrderID int
+	Method  paymentgateway.PaymentMethod
+	Actor   orders.Actor
+}
+
+type RetryPaymentRequest struct {
+	PaymentMethod paymentgateway.PaymentMethod `json:"payment_method"`
+}
+
+// Attempt is a new payment attempt and what the customer has to do to pay it.
+type Attempt struct {
+	Payment *payments.Payment            `json:"payment"`
+	Action  paymentgateway.PaymentAction `json:"action"`
+}
+
+type CheckoutService interface {
+	PlaceOrder(ctx context.Context, input orders.CreateOrderInput, method paymentgateway.PaymentMethod) (*Result, error)
+	RetryPayment(ctx context.Context, input RetryPaymentInput) (*Attempt, error)
+}
@@ -0,0 +1,125 @@
+package checkout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/payments"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
+)
+
+const workerBatchSize = 50
+
+// Worker finishes or compensates checkouts that were left in the started
+// state, e.g. because the process died between the order insert and the
+// payment record, or because compensation itself failed.
+type Worker struct {
+	svc         *svc
+	interval    time.Duration
+	staleAfter  time.Duration
+	maxAttempts int
+}
+
+func NewWorker(service *svc, interval, staleAfter time.Duration, maxAttempts int) *Worker {
+	return &Worker{
+		svc:         service,
+		interval:    interval,
+		staleAfter:  staleAfter,
+		maxAttempts: maxAttempts,
+	}
+}
+
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.sweep(ctx)
+		}
+	}
+}
+
+func (w *Worker) sweep(ctx context.Context) {
+	stalled, err := w.svc.orders.GetStalledCheckouts(ctx, w.staleAfter, workerBatchSize)
+	if err != nil {
+		log.Printf("checkout worker: %v", err)
+		return
+	}
+
+	for _, checkout := range stalled {
+		if err := w.resolve(ctx, checkout); err != nil {
+			log.Printf("checkout %d: %v", checkout.OrderID, err)
+			w.svc.recordFailure(ctx, checkout.OrderID, err)
+		}
+	}
+}
+
+// resolve settles a single stalled checkout. The gateway request, if one was
+// made before the checkout stalled, is looked up by the order ID it was
+// created with.
+func (w *Worker) resolve(ctx context.Context, checkout *orders.Checkout) error {
+	gateway, err := w.svc.gateways.Get(checkout.GatewayName)
+	if err != nil {
+		return err
+	}
+
+	switch checkout.PaymentStatus {
+	case "pending":
+		// Nobody holds the payment link for a stalled checkout, so the order
+		// is abandoned, unless the customer managed to pay before it stalled.
+		// The order is only compensated once the gateway confirms the request
+		// can no longer be paid.
+		paymentRequest, err := gateway.FindPaymentRequest(ctx, fmt.Sprint(checkout.OrderID))
+		if errors.Is(err, paymentgateway.ErrPaymentRequestNotFound) {
+			return w.svc.orders.CompensateCheckout(ctx, checkout.OrderID, "abandoned checkout")
+		}
+		if err != nil {
+			return w.escalate(checkout, fmt.Errorf("look up payment request: %w", err))
+		}
+
+		switch paymentRequest.Status {
+		case paymentgateway.StatusPaid:
+			if paymentRequest.Amount != checkout.Amount {
+				return w.escalate(checkout, fmt.Errorf("payment request %s paid %d, order total is %d", paymentRequest.GatewayID, paymentRequest.Amount, checkout.Amount))
+			}
+
+			_, err := w.svc.payments.CompletePaidCheckout(ctx, payments.CreatePaymentInput{
+				OrderID:     checkout.OrderID,
+				ExternalID:  paymentRequest.GatewayID,
+				GatewayName: checkout.GatewayName,
+				Amount:      checkout.Amount,
+			})
+			return err
+
+		case paymentgateway.StatusPending:
+			if err := gateway.CancelPaymentRequest(ctx, paymentRequest.GatewayID); err != nil {
+				return w.escalate(checkout, fmt.Errorf("void payment request: %w", err))
+			}
+		}
+
+		return w.svc.orders.CompensateCheckout(ctx, checkout.OrderID, "abandoned checkout")
+
+	default:
+		// The order already failed, expired or was canceled, so there is
+		// nothing left to undo.
+		return w.svc.orders.CompensateCheckout(ctx, checkout.OrderID, "order is "+checkout.PaymentStatus)
+	}
+}
+
+// escalate flags a checkout that keeps failing to resolve. It is left started,
+// and retried every time it goes stale again, because the order must not be
+// compensated while its payment request might still be paid.
+func (w *Worker) escalate(checkout *orders.Checkout, err error) error {
+	if checkout.Attempts+1 >= w.maxAttempts {
+		return fmt.Errorf("needs manual review after %d attempts: %w", checkout.Attempts+1, err)
+	}
+	return err
+}
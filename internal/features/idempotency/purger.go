@@ -0,0 +1,60 @@
+package idempotency
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+)
+
+const purgeBatchSize = 1000
+
+// purgeMetrics is published under /debug/vars as "idempotency_purge".
+var purgeMetrics = expvar.NewMap("idempotency_purge")
+
+// Purger deletes expired idempotency keys. Expired keys are already ignored
+// when claiming, so this only keeps the table from growing without bound.
+type Purger struct {
+	store    Store
+	interval time.Duration
+}
+
+func NewPurger(store Store, interval time.Duration) *Purger {
+	return &Purger{
+		store:    store,
+		interval: interval,
+	}
+}
+
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.purge(ctx)
+		}
+	}
+}
+
+func (p *Purger) purge(ctx context.Context) {
+	purgeMetrics.Add("runs", 1)
+
+	// Delete in batches so a large backlog does not hold one long lock.
+	for ctx.Err() == nil {
+		purged, err := p.store.Purge(ctx, purgeBatchSize)
+		if err != nil {
+			purgeMetrics.Add("errors", 1)
+			log.Printf("idempotency purger: %v", err)
+			return
+		}
+
+		purgeMetrics.Add("purged", int64(purged))
+		if purged < purgeBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+)
+
+type svc struct {
+	*db.Queries
+	ttl   time.Duration
+	lease time.Duration
+}
+
+// NewService stores keys for ttl. A request that claimed a key holds it for
+// lease; if it stops without completing or releasing the key, a retry of the
+// same request may take the key over once the lease has passed.
+func NewService(connPool *sql.DB, ttl, lease time.Duration) *svc {
+	return &svc{
+		Queries: db.New(connPool),
+		ttl:     ttl,
+		lease:   lease,
+	}
+}
+
+func (s *svc) Begin(ctx context.Context, scope, key, requestHash string) (*Record, error) {
+	_, err := s.Queries.ClaimIdempotencyKey(ctx, db.ClaimIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+		RequestHash:    requestHash,
+		LeaseSeconds:   int32(s.lease.Seconds()),
+		TtlSeconds:     int32(s.ttl.Seconds()),
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("claim idempotency key: %w", err)
+	}
+
+	existing, err := s.Queries.GetIdempotencyKey(ctx, db.GetIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get idempotency key: %w", err)
+	}
+
+	if existing.RequestHash != requestHash {
+		return nil, ErrRequestMismatch
+	}
+	if !existing.ResponseStatus.Valid {
+		return nil, ErrKeyInProgress
+	}
+
+	return &Record{
+		StatusCode: int(existing.ResponseStatus.Int32),
+		Body:       existing.ResponseBody,
+	}, nil
+}
+
+func (s *svc) Complete(ctx context.Context, scope, key string, record Record) error {
+	if err := s.Queries.SaveIdempotencyResponse(ctx, db.SaveIdempotencyResponseParams{
+		ResponseStatus: sql.NullInt32{Int32: int32(record.StatusCode), Valid: true},
+		ResponseBody:   record.Body,
+		Scope:          scope,
+		IdempotencyKey: key,
+	}); err != nil {
+		return fmt.Errorf("save idempotency response: %w", err)
+	}
+
+	return nil
+}
+
+func (s *svc) Release(ctx context.Context, scope, key string) error {
+	if err := s.Queries.DeleteIdempotencyKey(ctx, db.DeleteIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+	}); err != nil {
+		return fmt.Errorf("delete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (s *svc) Purge(ctx context.Context, limit int) (int, error) {
+	purged, err := s.Queries.PurgeExpiredIdempotencyKeys(ctx, int32(limit))
+	if err != nil {
+		return 0, fmt.Errorf("purge expired idempotency keys: %w", err)
+	}
+
+	return int(purged), nil
+}
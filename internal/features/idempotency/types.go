@@ -0,0 +1,29 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrKeyInProgress   = errors.New("a request with this idempotency key is still being processed")
+	ErrRequestMismatch = errors.New("idempotency key was already used with a different request")
+)
+
+// Record is a completed request that can be replayed to retries.
+type Record struct {
+	StatusCode int    `json:"status_code"`
+	Body       []byte `json:"body"`
+}
+
+// Store keeps idempotency keys per scope, the client that sent them, so two
+// clients that happen to pick the same key do not see each other's requests.
+type Store interface {
+	// Begin claims key for a new request. It returns the stored record when the
+	// key was already completed with the same request hash.
+	Begin(ctx context.Context, scope, key, requestHash string) (*Record, error)
+	Complete(ctx context.Context, scope, key string, record Record) error
+	Release(ctx context.Context, scope, key string) error
+	// Purge deletes up to limit expired keys and returns how many it deleted.
+	Purge(ctx context.Context, limit int) (int, error)
+}
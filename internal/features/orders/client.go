@@ -1,39 +1,170 @@
 package orders
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/breaker"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/cache"
+	"golang.org/x/sync/errgroup"
+)
+
+// menuMetrics is published under /debug/vars as "menu_client". The cache hit
+// rate is cache_hits / (cache_hits + cache_misses) and the mean upstream
+// latency is upstream_latency_ms / upstream_requests.
+var menuMetrics = expvar.NewMap("menu_client")
+
+// MenuClientOptions tunes how MenuClient talks to the menu service. Zero
+// values fall back to the defaults below.
+type MenuClientOptions struct {
+	Timeout time.Duration
+	// Token is sent as a bearer token so the menu service can authenticate
+	// this service. Empty sends no Authorization header.
+	Token string
+
+	// MaxRetries is how many times a failed GET is retried; a negative
+	// value disables retries.
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// MaxConcurrency bounds the single-menu requests FetchMenus makes when
+	// the menu service has no batch endpoint.
+	MaxConcurrency int
+
+	// Cache stores menus between requests. Nil uses an in-memory cache.
+	Cache    cache.Cache
+	CacheTTL time.Duration
+}
+
+const (
+	defaultMenuTimeout          = 5 * time.Second
+	defaultMenuMaxRetries       = 2
+	defaultMenuRetryBackoff     = 100 * time.Millisecond
+	defaultMenuBreakerThreshold = 5
+	defaultMenuBreakerCooldown  = 30 * time.Second
+	defaultMenuMaxConcurrency   = 4
+	defaultMenuCacheTTL         = 5 * time.Minute
+
+	// batchRetryAfter is how long lookups skip the batch endpoint after the
+	// menu service turned out not to serve it, e.g. until it is upgraded.
+	batchRetryAfter = 10 * time.Minute
 )
 
 type MenuClient struct {
-	httpClient *http.Client
-	baseURL    string
+	httpClient   *http.Client
+	baseURL      string
+	token        string
+	maxRetries   int
+	retryBackoff time.Duration
+	breaker      *breaker.Breaker
+	concurrency  int
+	cache        cache.Cache
+	cacheTTL     time.Duration
+
+	// noBatchUntil is set when the menu service turns out not to serve
+	// GET /menus?ids=..., so lookups go straight to single fetches until
+	// then. It holds Unix nanoseconds.
+	noBatchUntil atomic.Int64
 }
 
-func NewMenuClient(baseURL string) *MenuClient {
+func NewMenuClient(baseURL string, opts MenuClientOptions) *MenuClient {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultMenuTimeout
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = defaultMenuMaxRetries
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = defaultMenuRetryBackoff
+	}
+	if opts.BreakerThreshold <= 0 {
+		opts.BreakerThreshold = defaultMenuBreakerThreshold
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = defaultMenuBreakerCooldown
+	}
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = defaultMenuMaxConcurrency
+	}
+	if opts.Cache == nil {
+		opts.Cache = cache.NewMemory()
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = defaultMenuCacheTTL
+	}
+
 	return &MenuClient{
-		baseURL: baseURL,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   opts.Token,
 		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
+			Timeout: opts.Timeout,
 		},
+		maxRetries:   max(opts.MaxRetries, 0),
+		retryBackoff: opts.RetryBackoff,
+		breaker:      breaker.New(opts.BreakerThreshold, opts.BreakerCooldown),
+		concurrency:  opts.MaxConcurrency,
+		cache:        opts.Cache,
+		cacheTTL:     opts.CacheTTL,
 	}
 }
 
+// MenuResponse is a menu as the menu service reports it. Available and Stock
+// are optional: a menu without them is available and its stock is not
+// tracked.
 type MenuResponse struct {
 	ID             int                     `json:"id"`
 	Name           string                  `json:"name"`
 	Image          string                  `json:"image"`
 	Price          float64                 `json:"price"`
+	Available      *bool                   `json:"available,omitempty"`
+	Stock          *int                    `json:"stock,omitempty"`
 	ModifierGroups []ModifierGroupResponse `json:"modifier_groups"`
 }
 
+// IsAvailable reports whether the menu can be ordered at all. A menu with a
+// tracked stock of zero is sold out.
+func (m *MenuResponse) IsAvailable() bool {
+	if m.Available != nil && !*m.Available {
+		return false
+	}
+	return m.Stock == nil || *m.Stock > 0
+}
+
+// ModifierGroupResponse carries the selection rules for a group. Selections
+// are counted by quantity, so an extra shot x2 counts as two. A zero
+// MaxSelections means there is no upper bound, and a required group needs at
+// least one selection even when MinSelections is zero.
 type ModifierGroupResponse struct {
-	ID    int                    `json:"id"`
-	Name  string                 `json:"name"`
-	Items []ModifierItemResponse `json:"items"`
+	ID            int                    `json:"id"`
+	Name          string                 `json:"name"`
+	Required      bool                   `json:"required"`
+	MinSelections int                    `json:"min_selections"`
+	MaxSelections int                    `json:"max_selections"`
+	Items         []ModifierItemResponse `json:"items"`
+}
+
+// MinRequired is the fewest selections the group accepts.
+func (g ModifierGroupResponse) MinRequired() int {
+	if g.Required {
+		return max(g.MinSelections, 1)
+	}
+	return g.MinSelections
 }
 
 type ModifierItemResponse struct {
@@ -42,25 +173,391 @@ type ModifierItemResponse struct {
 	Price float64 `json:"price"`
 }
 
-func (c *MenuClient) FetchMenu(ctx context.Context, menuID int) (*MenuResponse, error) {
-	url := fmt.Sprintf("%s/menus/%d", c.baseURL, menuID)
+// Validate rejects menus that cannot be priced safely, so a broken upstream
+// response fails the order instead of storing wrong prices.
+func (m *MenuResponse) Validate() error {
+	if m.ID <= 0 {
+		return fmt.Errorf("%w: missing id", ErrInvalidMenu)
+	}
+	if strings.TrimSpace(m.Name) == "" {
+		return fmt.Errorf("%w: menu %d has no name", ErrInvalidMenu, m.ID)
+	}
+	if m.Price < 0 {
+		return fmt.Errorf("%w: menu %d has a negative price", ErrInvalidMenu, m.ID)
+	}
+	if m.Stock != nil && *m.Stock < 0 {
+		return fmt.Errorf("%w: menu %d has a negative stock", ErrInvalidMenu, m.ID)
+	}
 
-	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	seen := make(map[int]bool)
+	for _, group := range m.ModifierGroups {
+		if strings.TrimSpace(group.Name) == "" {
+			return fmt.Errorf("%w: menu %d has a modifier group %d with no name", ErrInvalidMenu, m.ID, group.ID)
+		}
+		if group.MinSelections < 0 || group.MaxSelections < 0 {
+			return fmt.Errorf("%w: menu %d has a modifier group %d with negative selection limits", ErrInvalidMenu, m.ID, group.ID)
+		}
+		if group.MaxSelections > 0 && group.MinRequired() > group.MaxSelections {
+			return fmt.Errorf("%w: menu %d has a modifier group %d that needs more selections than it allows", ErrInvalidMenu, m.ID, group.ID)
+		}
+		for _, item := range group.Items {
+			if seen[item.ID] {
+				return fmt.Errorf("%w: menu %d lists modifier %d more than once", ErrInvalidMenu, m.ID, item.ID)
+			}
+			seen[item.ID] = true
 
-	resp, err := c.httpClient.Do(req)
+			if strings.TrimSpace(item.Name) == "" {
+				return fmt.Errorf("%w: menu %d has a modifier %d with no name", ErrInvalidMenu, m.ID, item.ID)
+			}
+			if item.Price < 0 {
+				return fmt.Errorf("%w: menu %d has a modifier %d with a negative price", ErrInvalidMenu, m.ID, item.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// FetchMenu returns the menu from the cache, or from the menu service when it
+// is not cached. It returns ErrMenuNotFound when the menu does not exist and
+// ErrMenuUnavailable when the menu service cannot be reached.
+func (c *MenuClient) FetchMenu(ctx context.Context, menuID int) (*MenuResponse, error) {
+	if menu, ok := c.cached(ctx, menuID); ok {
+		return menu, nil
+	}
+
+	body, err := c.get(ctx, fmt.Sprintf("%s/menus/%d", c.baseURL, menuID))
+	if isStatus(err, http.StatusNotFound) {
+		return nil, fmt.Errorf("%w: %d", ErrMenuNotFound, menuID)
+	}
 	if err != nil {
+		return nil, fmt.Errorf("fetch menu %d: %w", menuID, err)
+	}
+
+	var menu MenuResponse
+	if err := json.Unmarshal(body, &menu); err != nil {
+		return nil, fmt.Errorf("%w: decode menu %d: %w", ErrInvalidMenu, menuID, err)
+	}
+	if menu.ID != menuID {
+		return nil, fmt.Errorf("%w: asked for menu %d, got %d", ErrInvalidMenu, menuID, menu.ID)
+	}
+	if err := menu.Validate(); err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch menu, status: %s", resp.Status)
+	c.store(ctx, &menu, body)
+	return &menu, nil
+}
+
+// FetchMenus returns the menus for menuIDs keyed by ID. Duplicate IDs are
+// fetched once. Menus that are not cached are requested in one batch; when
+// the menu service has no batch endpoint they are fetched one by one, a few
+// at a time, and the first failure cancels the rest. The batch endpoint is
+// tried again batchRetryAfter later.
+func (c *MenuClient) FetchMenus(ctx context.Context, menuIDs []int) (map[int]*MenuResponse, error) {
+	menus := make(map[int]*MenuResponse, len(menuIDs))
+
+	var missing []int
+	for _, id := range menuIDs {
+		if _, ok := menus[id]; ok || slices.Contains(missing, id) {
+			continue
+		}
+		if menu, ok := c.cached(ctx, id); ok {
+			menus[id] = menu
+			continue
+		}
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 {
+		return menus, nil
+	}
+
+	if time.Now().UnixNano() >= c.noBatchUntil.Load() {
+		fetched, err := c.fetchBatch(ctx, missing)
+		if err == nil {
+			for _, menu := range fetched {
+				menus[menu.ID] = menu
+			}
+			return menus, nil
+		}
+		if !errors.Is(err, errBatchUnsupported) {
+			return nil, err
+		}
+
+		log.Printf("menu client: batch lookup unsupported, falling back to single fetches for %s", batchRetryAfter)
+		c.noBatchUntil.Store(time.Now().Add(batchRetryAfter).UnixNano())
+	}
+
+	fetched := make([]*MenuResponse, len(missing))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency)
+	for i, id := range missing {
+		g.Go(func() error {
+			menu, err := c.FetchMenu(gctx, id)
+			if err != nil {
+				return err
+			}
+			fetched[i] = menu
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	for i, id := range missing {
+		menus[id] = fetched[i]
+	}
+	return menus, nil
+}
+
+var errBatchUnsupported = errors.New("menu service has no batch endpoint")
+
+// fetchBatch requests menuIDs with GET /menus?ids=1,2,3, which responds with a
+// JSON array of menus. Every requested menu must be in the response.
+func (c *MenuClient) fetchBatch(ctx context.Context, menuIDs []int) ([]*MenuResponse, error) {
+	ids := make([]string, len(menuIDs))
+	for i, id := range menuIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+
+	body, err := c.get(ctx, fmt.Sprintf("%s/menus?ids=%s", c.baseURL, strings.Join(ids, ",")))
+	if isStatus(err, http.StatusMethodNotAllowed, http.StatusNotImplemented) || isUnknownRoute(err) {
+		return nil, errBatchUnsupported
+	}
+	if isStatus(err, http.StatusNotFound) {
+		return nil, fmt.Errorf("%w: %v", ErrMenuNotFound, menuIDs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetch menus %v: %w", menuIDs, err)
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("%w: decode menus %v: %w", ErrInvalidMenu, menuIDs, err)
+	}
+
+	found := make(map[int]*MenuResponse, len(raw))
+	for _, item := range raw {
+		var menu MenuResponse
+		if err := json.Unmarshal(item, &menu); err != nil {
+			return nil, fmt.Errorf("%w: decode menus %v: %w", ErrInvalidMenu, menuIDs, err)
+		}
+		if !slices.Contains(menuIDs, menu.ID) {
+			continue
+		}
+		if err := menu.Validate(); err != nil {
+			return nil, err
+		}
+
+		found[menu.ID] = &menu
+		c.store(ctx, &menu, item)
+	}
+
+	menus := make([]*MenuResponse, 0, len(menuIDs))
+	for _, id := range menuIDs {
+		menu, ok := found[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", ErrMenuNotFound, id)
+		}
+		menus = append(menus, menu)
+	}
+	return menus, nil
+}
+
+func menuCacheKey(menuID int) string {
+	return "menu:" + strconv.Itoa(menuID)
+}
+
+// cached looks a menu up in the cache. Cache failures are logged and treated
+// as misses so the menu service is still asked.
+func (c *MenuClient) cached(ctx context.Context, menuID int) (*MenuResponse, bool) {
+	key := menuCacheKey(menuID)
+
+	body, found, err := c.cache.Get(ctx, key)
+	if err != nil {
+		menuMetrics.Add("cache_errors", 1)
+		log.Printf("menu client: read cache %s: %v", key, err)
+	}
+	if !found {
+		menuMetrics.Add("cache_misses", 1)
+		return nil, false
 	}
-	defer resp.Body.Close()
 
 	var menu MenuResponse
-	if err := json.NewDecoder(resp.Body).Decode(&menu); err != nil {
+	if err := json.Unmarshal(body, &menu); err != nil {
+		menuMetrics.Add("cache_errors", 1)
+		log.Printf("menu client: decode cached %s: %v", key, err)
+		return nil, false
+	}
+
+	menuMetrics.Add("cache_hits", 1)
+	return &menu, true
+}
+
+// store caches a menu. Menus that report their stock or availability are
+// not cached, since either can change at any time and must be read fresh.
+func (c *MenuClient) store(ctx context.Context, menu *MenuResponse, body []byte) {
+	if menu.Stock != nil || menu.Available != nil {
+		return
+	}
+
+	if err := c.cache.Set(ctx, menuCacheKey(menu.ID), body, c.cacheTTL); err != nil {
+		menuMetrics.Add("cache_errors", 1)
+		log.Printf("menu client: cache menu %d: %v", menu.ID, err)
+	}
+}
+
+// get performs a GET with retries. Network errors, 5xx and 429 responses are
+// retried with jittered exponential backoff; other responses are final.
+func (c *MenuClient) get(ctx context.Context, url string) ([]byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			menuMetrics.Add("retries", 1)
+
+			backoff := c.retryBackoff << (attempt - 1)
+			backoff += rand.N(backoff/2 + 1)
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+
+		if err := c.breaker.Allow(); err != nil {
+			menuMetrics.Add("breaker_rejections", 1)
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w: %w", ErrMenuUnavailable, lastErr)
+			}
+			return nil, fmt.Errorf("%w: %w", ErrMenuUnavailable, err)
+		}
+
+		body, retry, err := c.do(ctx, url)
+		if err == nil || !retry {
+			// A definite answer, even a 404, means the menu service is up.
+			c.breaker.Success()
+			return body, err
+		}
+
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about the menu service.
+			c.breaker.Ignore()
+			return nil, ctx.Err()
+		}
+
+		c.breaker.Failure()
+		menuMetrics.Add("upstream_failures", 1)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("%w: %w", ErrMenuUnavailable, lastErr)
+}
+
+// do sends a single request and reports whether a failure is worth retrying.
+// The body is always drained and closed so the connection can be reused.
+func (c *MenuClient) do(ctx context.Context, url string) (body []byte, retry bool, err error) {
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return nil, false, err
+	}
+
+	start := time.Now()
+	defer func() {
+		menuMetrics.Add("upstream_requests", 1)
+		menuMetrics.Add("upstream_latency_ms", time.Since(start).Milliseconds())
+	}()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, true, err
+		}
+		return body, false, nil
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented:
+		return nil, true, &statusError{code: resp.StatusCode, status: resp.Status}
+	default:
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, false, &statusError{code: resp.StatusCode, status: resp.Status, body: detail}
+	}
+}
+
+func (c *MenuClient) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
 		return nil, err
 	}
 
-	return &menu, nil
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// Ping reports whether the menu service is reachable and accepts this
+// service's token. It makes a single request to GET /health, without retries
+// or the circuit breaker, so it reflects the service's state right now.
+func (c *MenuClient) Ping(ctx context.Context) error {
+	req, err := c.newRequest(ctx, c.baseURL+"/health")
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMenuUnavailable, err)
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %w", ErrMenuUnavailable, &statusError{code: resp.StatusCode, status: resp.Status})
+	}
+	return nil
+}
+
+// maxErrorBody bounds how much of a final error response is kept.
+const maxErrorBody = 1 << 10
+
+// statusError is a response from the menu service other than 200 OK. body is
+// the start of a final response's body.
+type statusError struct {
+	code   int
+	status string
+	body   []byte
+}
+
+func (e *statusError) Error() string {
+	return "menu service responded " + e.status
+}
+
+// isStatus reports whether err is a response with one of codes.
+func isStatus(err error, codes ...int) bool {
+	var se *statusError
+	return errors.As(err, &se) && slices.Contains(codes, se.code)
+}
+
+// isUnknownRoute reports whether err is a 404 from a router that has no such
+// route, rather than the menu service reporting an unknown menu, which it
+// does with a JSON body.
+func isUnknownRoute(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusNotFound && !json.Valid(bytes.TrimSpace(se.body))
 }
@@ -0,0 +1,334 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// menuServer fakes the menu service. Requests to /menus/{id} are answered by
+// single and those to /menus?ids=... by batch; a nil handler responds 404.
+type menuServer struct {
+	*httptest.Server
+	singleCalls atomic.Int32
+	batchCalls  atomic.Int32
+}
+
+func newMenuServer(t *testing.T, single, batch http.HandlerFunc) *menuServer {
+	t.Helper()
+
+	s := &menuServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler := single
+		if r.URL.Path == "/menus" {
+			s.batchCalls.Add(1)
+			handler = batch
+		} else {
+			s.singleCalls.Add(1)
+		}
+		if handler == nil {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestMenuClient(url string, opts MenuClientOptions) *MenuClient {
+	opts.RetryBackoff = time.Millisecond
+	return NewMenuClient(url, opts)
+}
+
+// serveMenu responds with the menu named by the last path segment.
+func serveMenu(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	fmt.Fprintf(w, `{"id": %s, "name": "Menu %s", "price": 10000}`, id, id)
+}
+
+// serveMenus responds with the menus named by the ids query, except those
+// in missing.
+func serveMenus(missing ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var menus []string
+		for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			if !slices.Contains(missing, id) {
+				menus = append(menus, fmt.Sprintf(`{"id": %s, "name": "Menu %s", "price": 10000}`, id, id))
+			}
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(menus, ","))
+	}
+}
+
+// failing responds with status the first n times and serves the menu after.
+func failing(status int, n int32) http.HandlerFunc {
+	var calls atomic.Int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= n {
+			w.WriteHeader(status)
+			return
+		}
+		serveMenu(w, r)
+	}
+}
+
+func TestMenuClientRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		failures   int32
+		maxRetries int
+		wantCalls  int32
+		wantErr    error
+	}{
+		{"retries 503", http.StatusServiceUnavailable, 2, 2, 3, nil},
+		{"retries 429", http.StatusTooManyRequests, 1, 2, 2, nil},
+		{"gives up after max retries", http.StatusBadGateway, 3, 2, 3, ErrMenuUnavailable},
+		{"does not retry 404", http.StatusNotFound, 1, 2, 1, ErrMenuNotFound},
+		{"does not retry 501", http.StatusNotImplemented, 1, 2, 1, nil},
+		{"retries disabled", http.StatusServiceUnavailable, 1, -1, 1, ErrMenuUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMenuServer(t, failing(tt.status, tt.failures), nil)
+			client := newTestMenuClient(server.URL, MenuClientOptions{
+				MaxRetries:       tt.maxRetries,
+				BreakerThreshold: 10,
+			})
+
+			menu, err := client.FetchMenu(context.Background(), 7)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("FetchMenu() error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.status == http.StatusNotImplemented:
+				if err == nil || errors.Is(err, ErrMenuUnavailable) {
+					t.Fatalf("FetchMenu() error = %v, want a final status error", err)
+				}
+			case err != nil:
+				t.Fatalf("FetchMenu() error = %v", err)
+			case menu.ID != 7:
+				t.Fatalf("FetchMenu() = menu %d, want 7", menu.ID)
+			}
+
+			if got := server.singleCalls.Load(); got != tt.wantCalls {
+				t.Fatalf("menu service called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMenuClientBreaker(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	server := newMenuServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		serveMenu(w, r)
+	}, nil)
+
+	cooldown := 30 * time.Millisecond
+	client := newTestMenuClient(server.URL, MenuClientOptions{
+		MaxRetries:       -1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  cooldown,
+	})
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		if _, err := client.FetchMenu(ctx, i); !errors.Is(err, ErrMenuUnavailable) {
+			t.Fatalf("FetchMenu(%d) error = %v, want ErrMenuUnavailable", i, err)
+		}
+	}
+	if got := server.singleCalls.Load(); got != 2 {
+		t.Fatalf("menu service called %d times, want 2 before the breaker opened", got)
+	}
+
+	// A failed probe after the cooldown opens the breaker again.
+	time.Sleep(cooldown + 10*time.Millisecond)
+	if _, err := client.FetchMenu(ctx, 4); !errors.Is(err, ErrMenuUnavailable) {
+		t.Fatalf("FetchMenu(4) error = %v, want ErrMenuUnavailable", err)
+	}
+	if _, err := client.FetchMenu(ctx, 5); !errors.Is(err, ErrMenuUnavailable) {
+		t.Fatalf("FetchMenu(5) error = %v, want ErrMenuUnavailable", err)
+	}
+	if got := server.singleCalls.Load(); got != 3 {
+		t.Fatalf("menu service called %d times, want 3 after one failed probe", got)
+	}
+
+	// A successful probe closes it.
+	down.Store(false)
+	time.Sleep(cooldown + 10*time.Millisecond)
+	for i := 6; i <= 7; i++ {
+		if _, err := client.FetchMenu(ctx, i); err != nil {
+			t.Fatalf("FetchMenu(%d) after recovery error = %v", i, err)
+		}
+	}
+	if got := server.singleCalls.Load(); got != 5 {
+		t.Fatalf("menu service called %d times, want 5 after recovery", got)
+	}
+}
+
+func TestMenuClientCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantCalls int32
+	}{
+		{"untracked stock is cached", `{"id": 1, "name": "Nasi Goreng", "price": 25000}`, 1},
+		{"tracked stock is not cached", `{"id": 1, "name": "Nasi Goreng", "price": 25000, "stock": 4}`, 2},
+		{"availability is not cached", `{"id": 1, "name": "Nasi Goreng", "price": 25000, "available": true}`, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMenuServer(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.body)
+			}, nil)
+			client := newTestMenuClient(server.URL, MenuClientOptions{})
+
+			for range 2 {
+				if _, err := client.FetchMenu(context.Background(), 1); err != nil {
+					t.Fatalf("FetchMenu() error = %v", err)
+				}
+			}
+			if got := server.singleCalls.Load(); got != tt.wantCalls {
+				t.Fatalf("menu service called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMenuClientFetchMenus(t *testing.T) {
+	unsupported := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}
+	}
+
+	tests := []struct {
+		name string
+		// batch answers GET /menus?ids=...; nil responds 404.
+		batch           http.HandlerFunc
+		wantBatchCalls  int32
+		wantSingleCalls int32
+	}{
+		{"batch endpoint", serveMenus(), 2, 0},
+		{"falls back on 404", nil, 1, 4},
+		{"falls back on 405", unsupported(http.StatusMethodNotAllowed), 1, 4},
+		{"falls back on 501", unsupported(http.StatusNotImplemented), 1, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMenuServer(t, serveMenu, tt.batch)
+			client := newTestMenuClient(server.URL, MenuClientOptions{})
+			ctx := context.Background()
+
+			// The second lookup shares menu 2 with the first, which is
+			// cached by then.
+			for _, ids := range [][]int{{1, 2, 2, 3}, {2, 4}} {
+				menus, err := client.FetchMenus(ctx, ids)
+				if err != nil {
+					t.Fatalf("FetchMenus(%v) error = %v", ids, err)
+				}
+				for _, id := range ids {
+					if menus[id] == nil || menus[id].ID != id {
+						t.Fatalf("FetchMenus(%v) is missing menu %d", ids, id)
+					}
+				}
+			}
+
+			if got := server.batchCalls.Load(); got != tt.wantBatchCalls {
+				t.Fatalf("batch endpoint called %d times, want %d", got, tt.wantBatchCalls)
+			}
+			if got := server.singleCalls.Load(); got != tt.wantSingleCalls {
+				t.Fatalf("single endpoint called %d times, want %d", got, tt.wantSingleCalls)
+			}
+		})
+	}
+}
+
+func TestMenuClientFetchMenusMissing(t *testing.T) {
+	tests := []struct {
+		name  string
+		batch http.HandlerFunc
+	}{
+		{"left out of the response", serveMenus("2")},
+		{"reported as not found", func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.URL.Query().Get("ids"), "2") {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"error": "menu 2 not found"}`)
+				return
+			}
+			serveMenus()(w, r)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMenuServer(t, serveMenu, tt.batch)
+			client := newTestMenuClient(server.URL, MenuClientOptions{})
+			ctx := context.Background()
+
+			if _, err := client.FetchMenus(ctx, []int{1, 2}); !errors.Is(err, ErrMenuNotFound) {
+				t.Fatalf("FetchMenus() error = %v, want ErrMenuNotFound", err)
+			}
+
+			// An unknown menu says nothing about the batch endpoint.
+			if _, err := client.FetchMenus(ctx, []int{3}); err != nil {
+				t.Fatalf("FetchMenus() error = %v", err)
+			}
+			if got := server.batchCalls.Load(); got != 2 {
+				t.Fatalf("batch endpoint called %d times, want 2", got)
+			}
+			if got := server.singleCalls.Load(); got != 0 {
+				t.Fatalf("single endpoint called %d times, want 0", got)
+			}
+		})
+	}
+}
+
+func TestMenuClientFetchMenusRetriesBatch(t *testing.T) {
+	var supported atomic.Bool
+	server := newMenuServer(t, serveMenu, func(w http.ResponseWriter, r *http.Request) {
+		if !supported.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		serveMenus()(w, r)
+	})
+	client := newTestMenuClient(server.URL, MenuClientOptions{})
+	ctx := context.Background()
+
+	if _, err := client.FetchMenus(ctx, []int{1}); err != nil {
+		t.Fatalf("FetchMenus() error = %v", err)
+	}
+
+	// The menu service is upgraded and the fallback period has passed.
+	supported.Store(true)
+	client.noBatchUntil.Store(time.Now().Add(-time.Second).UnixNano())
+
+	if _, err := client.FetchMenus(ctx, []int{2}); err != nil {
+		t.Fatalf("FetchMenus() error = %v", err)
+	}
+	if got := server.batchCalls.Load(); got != 2 {
+		t.Fatalf("batch endpoint called %d times, want 2", got)
+	}
+	if got := server.singleCalls.Load(); got != 1 {
+		t.Fatalf("single endpoint called %d times, want 1", got)
+	}
+}
@@ -0,0 +1,162 @@
+package orders
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError points at the part of a request that was rejected, e.g.
+// items[0].modifiers[1].quantity.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found in a request so the client can
+// fix them in one go.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return "invalid order: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// BuildOrderItems prices the requested cart from menus, which must hold every
+// requested menu, checks that the menus can be ordered in the quantities
+// asked for, and checks modifier selections against the rules of their
+// groups. Items keep the order they were requested in. Invalid selections
+// are reported together as a *ValidationError.
+func BuildOrderItems(items []MenuItemRequest, menus map[int]*MenuResponse) ([]CreateOrderItemInput, error) {
+	verr := &ValidationError{}
+	orderItems := make([]CreateOrderItemInput, 0, len(items))
+	ordered := make(map[int]int)
+
+	for i, item := range items {
+		field := fmt.Sprintf("items[%d]", i)
+
+		if item.Quantity <= 0 {
+			verr.add(field+".quantity", "must be at least 1")
+		}
+
+		menu, ok := menus[int(item.MenuID)]
+		if !ok {
+			verr.add(field+".menu_id", "menu %d not found", item.MenuID)
+			continue
+		}
+
+		if !menu.IsAvailable() {
+			verr.add(field+".menu_id", "%s is not available", menu.Name)
+		} else if menu.Stock != nil {
+			// Lines for the same menu share its stock.
+			ordered[menu.ID] += int(item.Quantity)
+			if ordered[menu.ID] > *menu.Stock {
+				verr.add(field+".quantity", "only %d %s left", *menu.Stock, menu.Name)
+			}
+		}
+
+		modifiers := buildModifiers(field, item, menu, verr)
+
+		orderItems = append(orderItems, CreateOrderItemInput{
+			MenuID:    int(item.MenuID),
+			MenuName:  menu.Name,
+			Quantity:  int(item.Quantity),
+			Price:     int(menu.Price),
+			Modifiers: modifiers,
+			Stock:     menu.Stock,
+		})
+	}
+
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+	return orderItems, nil
+}
+
+// selection is one requested modifier and the request field it came from.
+type selection struct {
+	field    string
+	id       int
+	quantity int
+}
+
+func buildModifiers(field string, item MenuItemRequest, menu *MenuResponse, verr *ValidationError) []CreateOrderItemModifierInput {
+	var selections []selection
+	for j, id := range item.ModifiersItemsID {
+		selections = append(selections, selection{
+			field:    fmt.Sprintf("%s.modifiers-items-id[%d]", field, j),
+			id:       id,
+			quantity: 1,
+		})
+	}
+	for j, mod := range item.Modifiers {
+		selections = append(selections, selection{
+			field:    fmt.Sprintf("%s.modifiers[%d]", field, j),
+			id:       mod.ID,
+			quantity: mod.Quantity,
+		})
+	}
+
+	groupOf := make(map[int]int)
+	for g, group := range menu.ModifierGroups {
+		for _, modItem := range group.Items {
+			groupOf[modItem.ID] = g
+		}
+	}
+
+	var modifiers []CreateOrderItemModifierInput
+	selected := make(map[int]bool)
+	counts := make([]int, len(menu.ModifierGroups))
+
+	for _, sel := range selections {
+		g, ok := groupOf[sel.id]
+		if !ok {
+			verr.add(sel.field, "modifier %d is not offered for %s", sel.id, menu.Name)
+			continue
+		}
+		if selected[sel.id] {
+			verr.add(sel.field, "modifier %d is selected more than once; use its quantity instead", sel.id)
+			continue
+		}
+		if sel.quantity <= 0 {
+			verr.add(sel.field+".quantity", "must be at least 1")
+			continue
+		}
+		selected[sel.id] = true
+
+		group := menu.ModifierGroups[g]
+		counts[g] += sel.quantity
+
+		for _, modItem := range group.Items {
+			if modItem.ID == sel.id {
+				modifiers = append(modifiers, CreateOrderItemModifierInput{
+					ModifierID:        sel.id,
+					ModifierItemName:  modItem.Name,
+					ModifierGroupName: group.Name,
+					Price:             int(modItem.Price),
+					Quantity:          sel.quantity,
+				})
+				break
+			}
+		}
+	}
+
+	for g, group := range menu.ModifierGroups {
+		if minimum := group.MinRequired(); counts[g] < minimum {
+			verr.add(field+".modifiers", "%s needs at least %d selection(s), got %d", group.Name, minimum, counts[g])
+		}
+		if group.MaxSelections > 0 && counts[g] > group.MaxSelections {
+			verr.add(field+".modifiers", "%s allows at most %d selection(s), got %d", group.Name, group.MaxSelections, counts[g])
+		}
+	}
+
+	return modifiers
+}
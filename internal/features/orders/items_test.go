@@ -0,0 +1,187 @@
+package orders
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func stockOf(n int) *int {
+	return &n
+}
+
+// testMenus is a small menu: coffee with a required size and up to two
+// extras, dim sum that needs two sauce portions, a croissant with three left
+// and fried rice that is off the menu today.
+func testMenus() map[int]*MenuResponse {
+	unavailable := false
+
+	return map[int]*MenuResponse{
+		1: {
+			ID:    1,
+			Name:  "Kopi Susu",
+			Price: 18000,
+			ModifierGroups: []ModifierGroupResponse{
+				{ID: 1, Name: "Size", Required: true, MaxSelections: 1, Items: []ModifierItemResponse{
+					{ID: 11, Name: "Regular", Price: 0},
+					{ID: 12, Name: "Large", Price: 5000},
+				}},
+				{ID: 2, Name: "Extra", MaxSelections: 2, Items: []ModifierItemResponse{
+					{ID: 21, Name: "Espresso Shot", Price: 4000},
+					{ID: 22, Name: "Syrup", Price: 3000},
+				}},
+			},
+		},
+		2: {ID: 2, Name: "Croissant", Price: 22000, Stock: stockOf(3)},
+		3: {ID: 3, Name: "Nasi Goreng", Price: 30000, Available: &unavailable},
+		4: {
+			ID:    4,
+			Name:  "Dimsum",
+			Price: 25000,
+			ModifierGroups: []ModifierGroupResponse{
+				{ID: 4, Name: "Sauce", MinSelections: 2, Items: []ModifierItemResponse{
+					{ID: 41, Name: "Chili", Price: 1000},
+					{ID: 42, Name: "Soy", Price: 1000},
+				}},
+			},
+		},
+	}
+}
+
+func TestBuildOrderItems(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []MenuItemRequest
+		want  []CreateOrderItemInput
+		// wantFields are the fields a *ValidationError must report, in order.
+		wantFields []string
+	}{
+		{
+			name: "modifiers from both lists",
+			items: []MenuItemRequest{
+				{MenuID: 1, Quantity: 2, ModifiersItemsID: []int{12}, Modifiers: []ModifierRequest{{ID: 21, Quantity: 2}}},
+			},
+			want: []CreateOrderItemInput{
+				{MenuID: 1, MenuName: "Kopi Susu", Quantity: 2, Price: 18000, Modifiers: []CreateOrderItemModifierInput{
+					{ModifierID: 12, ModifierItemName: "Large", ModifierGroupName: "Size", Price: 5000, Quantity: 1},
+					{ModifierID: 21, ModifierItemName: "Espresso Shot", ModifierGroupName: "Extra", Price: 4000, Quantity: 2},
+				}},
+			},
+		},
+		{
+			name:       "required group missing",
+			items:      []MenuItemRequest{{MenuID: 1, Quantity: 1, Modifiers: []ModifierRequest{{ID: 21, Quantity: 1}}}},
+			wantFields: []string{"items[0].modifiers"},
+		},
+		{
+			name:       "required group over its maximum",
+			items:      []MenuItemRequest{{MenuID: 1, Quantity: 1, ModifiersItemsID: []int{11, 12}}},
+			wantFields: []string{"items[0].modifiers"},
+		},
+		{
+			name: "maximum counts modifier quantities",
+			items: []MenuItemRequest{
+				{MenuID: 1, Quantity: 1, ModifiersItemsID: []int{11}, Modifiers: []ModifierRequest{{ID: 21, Quantity: 2}, {ID: 22, Quantity: 1}}},
+			},
+			wantFields: []string{"items[0].modifiers"},
+		},
+		{
+			name:       "minimum not met",
+			items:      []MenuItemRequest{{MenuID: 4, Quantity: 1, ModifiersItemsID: []int{41}}},
+			wantFields: []string{"items[0].modifiers"},
+		},
+		{
+			name:  "minimum met by quantity",
+			items: []MenuItemRequest{{MenuID: 4, Quantity: 1, Modifiers: []ModifierRequest{{ID: 41, Quantity: 2}}}},
+			want: []CreateOrderItemInput{
+				{MenuID: 4, MenuName: "Dimsum", Quantity: 1, Price: 25000, Modifiers: []CreateOrderItemModifierInput{
+					{ModifierID: 41, ModifierItemName: "Chili", ModifierGroupName: "Sauce", Price: 1000, Quantity: 2},
+				}},
+			},
+		},
+		{
+			name:       "duplicate selection in one list",
+			items:      []MenuItemRequest{{MenuID: 1, Quantity: 1, ModifiersItemsID: []int{11, 11}}},
+			wantFields: []string{"items[0].modifiers-items-id[1]"},
+		},
+		{
+			name: "duplicate selection across lists",
+			items: []MenuItemRequest{
+				{MenuID: 1, Quantity: 1, ModifiersItemsID: []int{11}, Modifiers: []ModifierRequest{{ID: 11, Quantity: 1}}},
+			},
+			wantFields: []string{"items[0].modifiers[0]"},
+		},
+		{
+			name:       "modifier not offered for the menu",
+			items:      []MenuItemRequest{{MenuID: 1, Quantity: 1, ModifiersItemsID: []int{11, 41}}},
+			wantFields: []string{"items[0].modifiers-items-id[1]"},
+		},
+		{
+			name: "modifier quantity zero",
+			items: []MenuItemRequest{
+				{MenuID: 1, Quantity: 1, ModifiersItemsID: []int{11}, Modifiers: []ModifierRequest{{ID: 21, Quantity: 0}}},
+			},
+			wantFields: []string{"items[0].modifiers[0].quantity"},
+		},
+		{
+			name:       "item quantity zero",
+			items:      []MenuItemRequest{{MenuID: 2, Quantity: 0}},
+			wantFields: []string{"items[0].quantity"},
+		},
+		{
+			name:       "item quantity negative",
+			items:      []MenuItemRequest{{MenuID: 2, Quantity: -1}},
+			wantFields: []string{"items[0].quantity"},
+		},
+		{
+			name:  "stock shared across lines",
+			items: []MenuItemRequest{{MenuID: 2, Quantity: 1}, {MenuID: 2, Quantity: 2}},
+			want: []CreateOrderItemInput{
+				{MenuID: 2, MenuName: "Croissant", Quantity: 1, Price: 22000, Stock: stockOf(3)},
+				{MenuID: 2, MenuName: "Croissant", Quantity: 2, Price: 22000, Stock: stockOf(3)},
+			},
+		},
+		{
+			name:       "stock exceeded across lines",
+			items:      []MenuItemRequest{{MenuID: 2, Quantity: 2}, {MenuID: 2, Quantity: 2}},
+			wantFields: []string{"items[1].quantity"},
+		},
+		{
+			name:       "menu unavailable",
+			items:      []MenuItemRequest{{MenuID: 3, Quantity: 1}},
+			wantFields: []string{"items[0].menu_id"},
+		},
+		{
+			name:       "every problem reported",
+			items:      []MenuItemRequest{{MenuID: 99, Quantity: 1}, {MenuID: 2, Quantity: 0}, {MenuID: 1, Quantity: 1}},
+			wantFields: []string{"items[0].menu_id", "items[1].quantity", "items[2].modifiers"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BuildOrderItems(tt.items, testMenus())
+			if tt.wantFields != nil {
+				var verr *ValidationError
+				if !errors.As(err, &verr) {
+					t.Fatalf("BuildOrderItems() error = %v, want a *ValidationError", err)
+				}
+
+				fields := make([]string, len(verr.Fields))
+				for i, f := range verr.Fields {
+					fields[i] = f.Field
+				}
+				if !reflect.DeepEqual(fields, tt.wantFields) {
+					t.Fatalf("BuildOrderItems() rejected %v, want %v", fields, tt.wantFields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildOrderItems() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BuildOrderItems() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
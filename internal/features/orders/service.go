@@ -3,37 +3,51 @@ package orders
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/outbox"
 )
 
 type svc struct {
 	*db.Queries
-	connPool *sql.DB
+	connPool  *sql.DB
+	stockHold time.Duration
 }
 
-func NewService(connPool *sql.DB) *svc {
+// NewService returns the order service. Stock reserved for a new order is
+// held for stockHold unless the order is paid or ends before that.
+func NewService(connPool *sql.DB, stockHold time.Duration) *svc {
 	return &svc{
-		Queries:  db.New(connPool),
-		connPool: connPool,
+		Queries:   db.New(connPool),
+		connPool:  connPool,
+		stockHold: stockHold,
 	}
 }
 
 func calculateOrderTotal(items []CreateOrderItemInput) int {
 	total := 0
 	for _, item := range items {
-		unitPrice := item.Price
-
-		for _, mod := range item.Modifiers {
-			unitPrice += mod.Price
-		}
-
-		total += unitPrice * item.Quantity
+		total += item.Price*item.Quantity + modifiersTotal(item)
 	}
 	return total
 }
 
+// modifiersTotal is what the modifiers add to an order item: each modifier's
+// price times its quantity, for every unit of the item.
+func modifiersTotal(item CreateOrderItemInput) int {
+	perUnit := 0
+	for _, mod := range item.Modifiers {
+		perUnit += mod.Price * max(mod.Quantity, 1)
+	}
+	return perUnit * item.Quantity
+}
+
 func (s *svc) Create(ctx context.Context, params CreateOrderInput) (*Order, error) {
 	total := calculateOrderTotal(params.Items)
 
@@ -63,14 +77,38 @@ func (s *svc) Create(ctx context.Context, params CreateOrderInput) (*Order, erro
 		return nil, fmt.Errorf("create order: %w", err)
 	}
 
+	if err := qtx.CreateCheckoutSaga(ctx, db.CreateCheckoutSagaParams{
+		OrderID:     dbOrder.ID,
+		GatewayName: params.GatewayName,
+	}); err != nil {
+		return nil, fmt.Errorf("create checkout saga: %w", err)
+	}
+
+	actor := Actor{Source: SourceUser}
+	if userIDParam.Valid {
+		actor.UserID = int(userIDParam.Int32)
+	}
+
+	if err := RecordStatusEvent(ctx, qtx, StatusTransition{
+		OrderID:             int(dbOrder.ID),
+		ToPaymentStatus:     dbOrder.PaymentStatus,
+		ToFulfillmentStatus: dbOrder.FulfillmentStatus,
+		Actor:               actor,
+	}); err != nil {
+		return nil, err
+	}
+
 	for _, item := range params.Items {
+		modsTotal := modifiersTotal(item)
+
 		dbOrderItem, err := qtx.CreateOrderItem(ctx, db.CreateOrderItemParams{
 			OrderID:          dbOrder.ID,
 			MenuID:           int32(item.MenuID),
 			MenuNameSnapshot: item.MenuName,
 			UnitPrice:        int32(item.Price),
 			Quantity:         int32(item.Quantity),
-			ItemTotal:        int32(item.Price * item.Quantity),
+			ModifiersTotal:   int32(modsTotal),
+			ItemTotal:        int32(item.Price*item.Quantity + modsTotal),
 		})
 		if err != nil {
 			return nil, fmt.Errorf("create order item: %w", err)
@@ -82,7 +120,7 @@ func (s *svc) Create(ctx context.Context, params CreateOrderInput) (*Order, erro
 				ModifierGroupNameSnapshot: mod.ModifierGroupName,
 				ModifierItemNameSnapshot:  mod.ModifierItemName,
 				ModifierPrice:             int32(mod.Price),
-				Quantity:                  1,
+				Quantity:                  int32(max(mod.Quantity, 1)),
 			})
 			if err != nil {
 				return nil, fmt.Errorf("create order item modifier: %w", err)
@@ -90,106 +128,612 @@ func (s *svc) Create(ctx context.Context, params CreateOrderInput) (*Order, erro
 		}
 	}
 
+	if err := reserveStock(ctx, qtx, dbOrder.ID, params.Items, s.stockHold); err != nil {
+		return nil, err
+	}
+
+	order := toOrder(dbOrder)
+
+	if err := outbox.Enqueue(ctx, qtx, outbox.AggregateOrder, order.ID, outbox.EventOrderCreated, OrderEvent{
+		OrderID:           order.ID,
+		UserID:            order.UserID,
+		Total:             order.Total,
+		PaymentStatus:     order.PaymentStatus,
+		FulfillmentStatus: order.FulfillmentStatus,
+		Actor:             actor,
+		Items:             params.Items,
+	}); err != nil {
+		return nil, err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("commit tx: %w", err)
 	}
 
-	return &Order{
-		ID:                int(dbOrder.ID),
-		UserID:            nil,
-		CustomerName:      dbOrder.CustomerName,
-		Phone:             dbOrder.CustomerPhone,
-		Address:           dbOrder.DeliveryAddress,
-		Total:             int(dbOrder.OrderTotal),
-		PaymentStatus:     dbOrder.PaymentStatus,
-		FulfillmentStatus: dbOrder.FulfillmentStatus,
-		CreatedAt:         dbOrder.CreatedAt,
-		UpdatedAt:         dbOrder.UpdatedAt,
-	}, nil
+	return order, nil
 }
 
-func (s *svc) GetAll(ctx context.Context, offset, limit int) ([]*Order, error) {
-	dbOrders, err := s.Queries.GetAllOrders(ctx, db.GetAllOrdersParams{
-		Offset: int32(offset),
-		Limit:  int32(limit),
-	})
+// orderCursor marks the last row of a page. It carries the sort it was issued
+// for so a cursor cannot be replayed against a different ordering.
+type orderCursor struct {
+	SortBy    string    `json:"s"`
+	SortDesc  bool      `json:"d"`
+	CreatedAt time.Time `json:"c"`
+	Total     int       `json:"t"`
+	ID        int       `json:"i"`
+}
+
+func encodeCursor(c orderCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(value string) (*orderCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
 	if err != nil {
-		return nil, fmt.Errorf("list orders: %w", err)
+		return nil, ErrInvalidCursor
 	}
 
-	orders := make([]*Order, 0, len(dbOrders))
-	for _, dbOrder := range dbOrders {
-		var userIDPtr *int
-		if dbOrder.UserID.Valid {
-			uid := int(dbOrder.UserID.Int32)
-			userIDPtr = &uid
-		}
+	var c orderCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
 
-		order := &Order{
-			ID:                int(dbOrder.ID),
-			UserID:            userIDPtr,
-			CustomerName:      dbOrder.CustomerName,
-			Phone:             dbOrder.CustomerPhone,
-			Address:           dbOrder.DeliveryAddress,
-			Total:             int(dbOrder.OrderTotal),
-			PaymentStatus:     dbOrder.PaymentStatus,
-			FulfillmentStatus: dbOrder.FulfillmentStatus,
-			CreatedAt:         dbOrder.CreatedAt,
-			UpdatedAt:         dbOrder.UpdatedAt,
+// GetAll lists orders for the back office using keyset pagination, so pages
+// stay stable while new orders keep arriving.
+func (s *svc) GetAll(ctx context.Context, filter OrderFilter) (*OrderPage, error) {
+	if filter.SortBy == "" {
+		filter.SortBy = SortByCreatedAt
+	}
+	if filter.SortBy != SortByCreatedAt && filter.SortBy != SortByOrderTotal {
+		return nil, ErrInvalidSort
+	}
+
+	countParams := db.CountOrdersParams{
+		PaymentStatus:     sql.NullString{String: filter.PaymentStatus, Valid: filter.PaymentStatus != ""},
+		FulfillmentStatus: sql.NullString{String: filter.FulfillmentStatus, Valid: filter.FulfillmentStatus != ""},
+		CustomerPhone:     sql.NullString{String: filter.Phone, Valid: filter.Phone != ""},
+		CustomerName:      sql.NullString{String: escapeLike(filter.Name), Valid: filter.Name != ""},
+	}
+	if filter.CreatedFrom != nil {
+		countParams.CreatedFrom = sql.NullTime{Time: *filter.CreatedFrom, Valid: true}
+	}
+	if filter.CreatedTo != nil {
+		countParams.CreatedTo = sql.NullTime{Time: *filter.CreatedTo, Valid: true}
+	}
+
+	searchParams := db.SearchOrdersParams{
+		PaymentStatus:     countParams.PaymentStatus,
+		FulfillmentStatus: countParams.FulfillmentStatus,
+		CreatedFrom:       countParams.CreatedFrom,
+		CreatedTo:         countParams.CreatedTo,
+		CustomerPhone:     countParams.CustomerPhone,
+		CustomerName:      countParams.CustomerName,
+		SortBy:            filter.SortBy,
+		SortDesc:          filter.SortDesc,
+		Limit:             int32(filter.Limit + 1),
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
 		}
-		orders = append(orders, order)
+		if cursor.SortBy != filter.SortBy || cursor.SortDesc != filter.SortDesc {
+			return nil, ErrInvalidCursor
+		}
+
+		searchParams.CursorID = sql.NullInt32{Int32: int32(cursor.ID), Valid: true}
+		searchParams.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		searchParams.CursorTotal = sql.NullInt32{Int32: int32(cursor.Total), Valid: true}
 	}
 
-	return orders, nil
+	dbOrders, err := s.Queries.SearchOrders(ctx, searchParams)
+	if err != nil {
+		return nil, fmt.Errorf("list orders: %w", err)
+	}
+
+	total, err := s.Queries.CountOrders(ctx, countParams)
+	if err != nil {
+		return nil, fmt.Errorf("count orders: %w", err)
+	}
+
+	page := &OrderPage{Total: int(total)}
+
+	if len(dbOrders) > filter.Limit {
+		dbOrders = dbOrders[:filter.Limit]
+		last := dbOrders[len(dbOrders)-1]
+		page.NextCursor = encodeCursor(orderCursor{
+			SortBy:    filter.SortBy,
+			SortDesc:  filter.SortDesc,
+			CreatedAt: last.CreatedAt,
+			Total:     int(last.OrderTotal),
+			ID:        int(last.ID),
+		})
+	}
+
+	page.Data = make([]*Order, 0, len(dbOrders))
+	for _, dbOrder := range dbOrders {
+		page.Data = append(page.Data, toOrder(dbOrder))
+	}
+
+	return page, nil
 }
 
-func (s *svc) GetAllByUserID(ctx context.Context, userID int) ([]*Order, error) {
-	dbOrders, err := s.Queries.GetOrdersByUserId(ctx, sql.NullInt32{Int32: int32(userID), Valid: true})
+func (s *svc) GetAllByUserID(ctx context.Context, userID int, filter UserOrderFilter) (*OrderPage, error) {
+	userIDParam := sql.NullInt32{Int32: int32(userID), Valid: true}
+	paymentStatus := sql.NullString{String: filter.PaymentStatus, Valid: filter.PaymentStatus != ""}
+	fulfillmentStatus := sql.NullString{String: filter.FulfillmentStatus, Valid: filter.FulfillmentStatus != ""}
+
+	dbOrders, err := s.Queries.ListUserOrders(ctx, db.ListUserOrdersParams{
+		UserID:            userIDParam,
+		PaymentStatus:     paymentStatus,
+		FulfillmentStatus: fulfillmentStatus,
+		Offset:            int32(filter.Offset),
+		Limit:             int32(filter.Limit),
+	})
 	if err != nil {
 		return nil, fmt.Errorf("get orders by user id: %w", err)
 	}
 
+	total, err := s.Queries.CountUserOrders(ctx, db.CountUserOrdersParams{
+		UserID:            userIDParam,
+		PaymentStatus:     paymentStatus,
+		FulfillmentStatus: fulfillmentStatus,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("count orders by user id: %w", err)
+	}
+
 	orders := make([]*Order, 0, len(dbOrders))
 	for _, dbOrder := range dbOrders {
-		var userIDPtr *int
-		if dbOrder.UserID.Valid {
-			uid := int(dbOrder.UserID.Int32)
-			userIDPtr = &uid
+		orders = append(orders, toOrder(dbOrder))
+	}
+
+	return &OrderPage{
+		Data:  orders,
+		Total: int(total),
+	}, nil
+}
+
+func (s *svc) GetOrdersReadyToPrepare(ctx context.Context) ([]*Order, error) {
+	return s.getOrdersToFulfill(ctx, "new")
+}
+
+func (s *svc) GetOrdersInPreparation(ctx context.Context) ([]*Order, error) {
+	return s.getOrdersToFulfill(ctx, "preparing")
+}
+
+func (s *svc) GetOrdersForDelivery(ctx context.Context) ([]*Order, error) {
+	return s.getOrdersToFulfill(ctx, "delivering")
+}
+
+func (s *svc) getOrdersToFulfill(ctx context.Context, fulfillmentStatus string) ([]*Order, error) {
+	dbOrders, err := s.Queries.GetOrdersToFulfill(ctx, fulfillmentStatus)
+	if err != nil {
+		return nil, fmt.Errorf("get orders to fulfill: %w", err)
+	}
+
+	orders := make([]*Order, 0, len(dbOrders))
+	for _, dbOrder := range dbOrders {
+		orders = append(orders, toOrder(dbOrder))
+	}
+
+	return orders, nil
+}
+
+func (s *svc) MarkOrderPreparing(ctx context.Context, orderId int, actor Actor) error {
+	return s.advanceFulfillment(ctx, orderId, actor, TriggerStartPreparing, (*db.Queries).StartPreparingOrder)
+}
+
+func (s *svc) MarkOrderDelivering(ctx context.Context, orderId int, actor Actor) error {
+	return s.advanceFulfillment(ctx, orderId, actor, TriggerStartDelivering, (*db.Queries).MarkOrderDelivering)
+}
+
+func (s *svc) MarkOrderCompleted(ctx context.Context, orderId int, actor Actor) error {
+	return s.advanceFulfillment(ctx, orderId, actor, TriggerComplete, (*db.Queries).CompleteOrder)
+}
+
+// advanceFulfillment runs one of the guarded kitchen updates on a paid order
+// and records the move on the order timeline in the same transaction.
+func (s *svc) advanceFulfillment(
+	ctx context.Context,
+	orderId int,
+	actor Actor,
+	trigger Trigger,
+	update func(q *db.Queries, ctx context.Context, id int32) (int64, error),
+) error {
+	tx, err := s.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	qtx := s.Queries.WithTx(tx)
+
+	current, err := qtx.GetOrderById(ctx, int32(orderId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return fmt.Errorf("get order: %w", err)
+	}
+
+	if _, err := ApplyTransition(ctx, qtx, current, trigger, actor, "", func() (int64, error) {
+		return update(qtx, ctx, current.ID)
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// CancelOrder lets the owner cancel an order that has not been paid yet, and
+// lets admins additionally cancel paid orders that have not left the kitchen,
+// which moves their payment into refund_pending.
+func (s *svc) CancelOrder(ctx context.Context, input CancelOrderInput) (*Order, error) {
+	tx, err := s.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	qtx := s.Queries.WithTx(tx)
+
+	current, err := qtx.GetOrderById(ctx, int32(input.OrderID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
 		}
+		return nil, fmt.Errorf("get order: %w", err)
+	}
 
-		order := &Order{
-			ID:                int(dbOrder.ID),
-			UserID:            userIDPtr,
-			CustomerName:      dbOrder.CustomerName,
-			Phone:             dbOrder.CustomerPhone,
-			Address:           dbOrder.DeliveryAddress,
-			Total:             int(dbOrder.OrderTotal),
-			PaymentStatus:     dbOrder.PaymentStatus,
-			FulfillmentStatus: dbOrder.FulfillmentStatus,
-			CreatedAt:         dbOrder.CreatedAt,
-			UpdatedAt:         dbOrder.UpdatedAt,
+	isOwner := current.UserID.Valid && int(current.UserID.Int32) == input.Actor.UserID
+	if !isOwner && !input.IsAdmin {
+		return nil, ErrUnauthorizedAccess
+	}
+
+	trigger := TriggerCancel
+	if current.PaymentStatus == "paid" {
+		if !input.IsAdmin {
+			return nil, ErrInvalidOrderStatus
 		}
-		orders = append(orders, order)
+		trigger = TriggerCancelPaid
 	}
 
-	return orders, nil
+	canceledBy := sql.NullInt32{Int32: int32(input.Actor.UserID), Valid: input.Actor.UserID > 0}
+	cancelReason := sql.NullString{String: input.Reason, Valid: input.Reason != ""}
+
+	var canceled db.Order
+	if _, err := ApplyTransition(ctx, qtx, current, trigger, input.Actor, input.Reason, func() (int64, error) {
+		if trigger == TriggerCancelPaid {
+			canceled, err = qtx.CancelPaidOrder(ctx, db.CancelPaidOrderParams{
+				CanceledBy:   canceledBy,
+				CancelReason: cancelReason,
+				ID:           current.ID,
+			})
+		} else {
+			canceled, err = qtx.CancelOrder(ctx, db.CancelOrderParams{
+				CanceledBy:   canceledBy,
+				CancelReason: cancelReason,
+				ID:           current.ID,
+			})
+		}
+		return rowsReturned(err)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return toOrder(canceled), nil
+}
+
+func (s *svc) GetStalledCheckouts(ctx context.Context, staleAfter time.Duration, limit int) ([]*Checkout, error) {
+	rows, err := s.Queries.GetStalledCheckoutSagas(ctx, db.GetStalledCheckoutSagasParams{
+		StaleSeconds: int32(staleAfter.Seconds()),
+		Limit:        int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get stalled checkouts: %w", err)
+	}
+
+	checkouts := make([]*Checkout, 0, len(rows))
+	for _, row := range rows {
+		checkouts = append(checkouts, &Checkout{
+			OrderID:       int(row.OrderID),
+			GatewayName:   row.GatewayName,
+			Amount:        int(row.OrderTotal),
+			Attempts:      int(row.Attempts),
+			PaymentStatus: row.PaymentStatus,
+		})
+	}
+
+	return checkouts, nil
+}
+
+func (s *svc) RecordCheckoutFailure(ctx context.Context, orderId int, reason string) error {
+	if err := s.Queries.RecordCheckoutSagaFailure(ctx, db.RecordCheckoutSagaFailureParams{
+		LastError: sql.NullString{String: reason, Valid: reason != ""},
+		OrderID:   int32(orderId),
+	}); err != nil {
+		return fmt.Errorf("record checkout failure: %w", err)
+	}
+
+	return nil
 }
 
-func (s *svc) GetUserOrderDetails(ctx context.Context, userID, orderID int) (*OrderDetail, error) {
+// CompensateCheckout closes a checkout that could not get a payment request.
+// An order that is still awaiting payment is canceled by the system; orders
+// that already moved on are left as they are.
+func (s *svc) CompensateCheckout(ctx context.Context, orderId int, reason string) error {
+	tx, err := s.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	qtx := s.Queries.WithTx(tx)
+
+	current, err := qtx.GetOrderById(ctx, int32(orderId))
+	if err != nil {
+		return fmt.Errorf("get order: %w", err)
+	}
+
+	if StateOf(current).Can(TriggerCancel) {
+		note := "checkout failed: " + reason
+		if _, err := ApplyTransition(ctx, qtx, current, TriggerCancel, Actor{Source: SourceSystem}, note, func() (int64, error) {
+			_, err := qtx.CancelOrder(ctx, db.CancelOrderParams{
+				CancelReason: sql.NullString{String: note, Valid: true},
+				ID:           current.ID,
+			})
+			return rowsReturned(err)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := qtx.CompensateCheckoutSaga(ctx, db.CompensateCheckoutSagaParams{
+		LastError: sql.NullString{String: reason, Valid: reason != ""},
+		OrderID:   int32(orderId),
+	}); err != nil {
+		return fmt.Errorf("compensate checkout saga: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// escapeLike escapes the LIKE wildcards in a user supplied prefix, so that
+// searching for "50%" does not match every name starting with "50".
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// rowsReturned turns the error of a guarded UPDATE ... RETURNING into the
+// number of rows it changed.
+func rowsReturned(err error) (int64, error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// GetUserOrderDetails returns the full order for its owner; staff (admin
+// source) may read any order.
+func (s *svc) GetUserOrderDetails(ctx context.Context, actor Actor, orderID int) (*OrderDetail, error) {
+	dbOrder, err := s.Queries.GetOrderById(ctx, int32(orderID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("get order: %w", err)
+	}
+
+	isOwner := dbOrder.UserID.Valid && int(dbOrder.UserID.Int32) == actor.UserID
+	if !isOwner && actor.Source != SourceAdmin {
+		return nil, ErrUnauthorizedAccess
+	}
+
 	dbOrderItems, err := s.Queries.GetAllOrderItems(ctx, int32(orderID))
 	if err != nil {
 		return nil, fmt.Errorf("get order items: %w", err)
 	}
 
-	orderItems := TransformOrderRows(dbOrderItems)
+	dbPayments, err := s.Queries.GetPaymentsByOrderID(ctx, db.GetPaymentsByOrderIDParams{
+		OrderID: int32(orderID),
+		Offset:  0,
+		Limit:   1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get order payments: %w", err)
+	}
+
+	dbEvents, err := s.Queries.GetOrderStatusEvents(ctx, int32(orderID))
+	if err != nil {
+		return nil, fmt.Errorf("get order status events: %w", err)
+	}
 
 	orderDetail := OrderDetail{
-		Items: orderItems,
+		Order:    toOrder(dbOrder),
+		Items:    TransformOrderRows(dbOrderItems),
+		Timeline: toStatusEvents(dbEvents),
+	}
+
+	if len(dbPayments) > 0 {
+		orderDetail.Payment = toOrderPayment(dbPayments[0])
 	}
 
 	return &orderDetail, nil
 }
 
+func toOrderPayment(dbPayment db.Payment) *OrderPayment {
+	payment := &OrderPayment{
+		ID:             int(dbPayment.ID),
+		ExternalID:     dbPayment.ExternalID,
+		GatewayName:    dbPayment.GatewayName,
+		Amount:         int(dbPayment.Amount),
+		PaymentMethod:  dbPayment.PaymentMethod.String,
+		PaymentChannel: dbPayment.PaymentChannel.String,
+		Status:         dbPayment.Status,
+		Attempt:        int(dbPayment.Attempt),
+		CreatedAt:      dbPayment.CreatedAt,
+	}
+	if dbPayment.PaidAt.Valid {
+		payment.PaidAt = &dbPayment.PaidAt.Time
+	}
+
+	return payment
+}
+
+func toOrder(dbOrder db.Order) *Order {
+	var userIDPtr *int
+	if dbOrder.UserID.Valid {
+		uid := int(dbOrder.UserID.Int32)
+		userIDPtr = &uid
+	}
+
+	order := &Order{
+		ID:                int(dbOrder.ID),
+		UserID:            userIDPtr,
+		CustomerName:      dbOrder.CustomerName,
+		Phone:             dbOrder.CustomerPhone,
+		Address:           dbOrder.DeliveryAddress,
+		Total:             int(dbOrder.OrderTotal),
+		PaymentStatus:     dbOrder.PaymentStatus,
+		FulfillmentStatus: dbOrder.FulfillmentStatus,
+		CancelReason:      dbOrder.CancelReason.String,
+		CreatedAt:         dbOrder.CreatedAt,
+		UpdatedAt:         dbOrder.UpdatedAt,
+	}
+
+	if dbOrder.CanceledBy.Valid {
+		canceledBy := int(dbOrder.CanceledBy.Int32)
+		order.CanceledBy = &canceledBy
+	}
+	if dbOrder.CanceledAt.Valid {
+		order.CanceledAt = &dbOrder.CanceledAt.Time
+	}
+
+	return order
+}
+
+// RecordStatusEvent appends a transition to the order timeline. It must be
+// called with the same transactional queries that performed the transition.
+func RecordStatusEvent(ctx context.Context, q *db.Queries, t StatusTransition) error {
+	err := q.CreateOrderStatusEvent(ctx, db.CreateOrderStatusEventParams{
+		OrderID:               int32(t.OrderID),
+		FromPaymentStatus:     sql.NullString{String: t.FromPaymentStatus, Valid: t.FromPaymentStatus != ""},
+		ToPaymentStatus:       t.ToPaymentStatus,
+		FromFulfillmentStatus: sql.NullString{String: t.FromFulfillmentStatus, Valid: t.FromFulfillmentStatus != ""},
+		ToFulfillmentStatus:   t.ToFulfillmentStatus,
+		ActorID:               sql.NullInt32{Int32: int32(t.Actor.UserID), Valid: t.Actor.UserID > 0},
+		Source:                t.Actor.Source,
+		Note:                  sql.NullString{String: t.Note, Valid: t.Note != ""},
+	})
+	if err != nil {
+		return fmt.Errorf("record order status event: %w", err)
+	}
+
+	eventType := statusEventType(t)
+	if eventType == "" {
+		return nil
+	}
+
+	return outbox.Enqueue(ctx, q, outbox.AggregateOrder, t.OrderID, eventType, OrderEvent{
+		OrderID:                   t.OrderID,
+		PaymentStatus:             t.ToPaymentStatus,
+		FulfillmentStatus:         t.ToFulfillmentStatus,
+		PreviousPaymentStatus:     t.FromPaymentStatus,
+		PreviousFulfillmentStatus: t.FromFulfillmentStatus,
+		Actor:                     t.Actor,
+		Note:                      t.Note,
+	})
+}
+
+// statusEventType names the outbox event published for a transition. The
+// initial event of an order has no event type of its own since Create
+// publishes order.created with the full order instead.
+func statusEventType(t StatusTransition) string {
+	if t.FromPaymentStatus == "" && t.FromFulfillmentStatus == "" {
+		return ""
+	}
+
+	switch t.ToPaymentStatus {
+	case "failed":
+		return outbox.EventOrderPaymentFailed
+	case "expired":
+		return outbox.EventOrderPaymentExpired
+	case "pending":
+		if t.FromPaymentStatus != "pending" {
+			return outbox.EventOrderPaymentRetried
+		}
+	case "partially_refunded":
+		return outbox.EventOrderPartiallyRefunded
+	case "refunded":
+		return outbox.EventOrderRefunded
+	}
+
+	if t.ToFulfillmentStatus != t.FromFulfillmentStatus {
+		switch t.ToFulfillmentStatus {
+		case "preparing":
+			return outbox.EventOrderPreparing
+		case "delivering":
+			return outbox.EventOrderDelivering
+		case "completed":
+			return outbox.EventOrderCompleted
+		case "canceled":
+			return outbox.EventOrderCanceled
+		}
+	}
+
+	if t.ToPaymentStatus == "paid" && t.FromPaymentStatus != "paid" {
+		return outbox.EventOrderPaid
+	}
+
+	return ""
+}
+
+func toStatusEvents(rows []db.OrderStatusEvent) []OrderStatusEvent {
+	events := make([]OrderStatusEvent, 0, len(rows))
+	for _, row := range rows {
+		event := OrderStatusEvent{
+			ID:                    int(row.ID),
+			FromPaymentStatus:     row.FromPaymentStatus.String,
+			ToPaymentStatus:       row.ToPaymentStatus,
+			FromFulfillmentStatus: row.FromFulfillmentStatus.String,
+			ToFulfillmentStatus:   row.ToFulfillmentStatus,
+			Source:                row.Source,
+			Note:                  row.Note.String,
+			CreatedAt:             row.CreatedAt,
+		}
+		if row.ActorID.Valid {
+			actorID := int(row.ActorID.Int32)
+			event.ActorID = &actorID
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
 func TransformOrderRows(rows []db.GetAllOrderItemsRow) []OrderItem {
 	itemMap := make(map[int32]*OrderItem)
 	var order []int32
@@ -0,0 +1,231 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+)
+
+// State is where an order stands on its payment and fulfillment axes.
+type State struct {
+	Payment     string `json:"payment_status"`
+	Fulfillment string `json:"fulfillment_status"`
+}
+
+func (s State) String() string {
+	return s.Payment + "/" + s.Fulfillment
+}
+
+// StateOf returns the state a stored order is in.
+func StateOf(order db.Order) State {
+	return State{Payment: order.PaymentStatus, Fulfillment: order.FulfillmentStatus}
+}
+
+// Trigger is something that happens to an order and may move it to another
+// state.
+type Trigger string
+
+const (
+	TriggerPay             Trigger = "pay"
+	TriggerPayCanceled     Trigger = "pay_canceled"
+	TriggerFailPayment     Trigger = "fail_payment"
+	TriggerExpirePayment   Trigger = "expire_payment"
+	TriggerRetryPayment    Trigger = "retry_payment"
+	TriggerStartPreparing  Trigger = "start_preparing"
+	TriggerStartDelivering Trigger = "start_delivering"
+	TriggerComplete        Trigger = "complete"
+	TriggerCancel          Trigger = "cancel"
+	TriggerCancelPaid      Trigger = "cancel_paid"
+	TriggerPartialRefund   Trigger = "partial_refund"
+	TriggerRefund          Trigger = "refund"
+)
+
+type transition struct {
+	from []State
+	// to is the resulting state. An empty payment or fulfillment status keeps
+	// the one the order is in.
+	to State
+}
+
+var (
+	activeFulfillment = []string{"new", "preparing", "delivering", "completed"}
+	anyFulfillment    = []string{"new", "preparing", "delivering", "completed", "canceled"}
+)
+
+// transitions is the order state machine. The guarded UPDATE behind each
+// trigger must only match the states listed here.
+var transitions = map[Trigger]transition{
+	TriggerPay: {
+		from: states("pending", "new"),
+		to:   State{Payment: "paid", Fulfillment: "new"},
+	},
+	// A payment captured after the order was canceled, e.g. because voiding
+	// its request failed, is kept and queued for a refund.
+	TriggerPayCanceled: {
+		from: states("canceled", "canceled"),
+		to:   State{Payment: "refund_pending", Fulfillment: "canceled"},
+	},
+	TriggerFailPayment: {
+		from: states("pending", "new"),
+		to:   State{Payment: "failed", Fulfillment: "canceled"},
+	},
+	TriggerExpirePayment: {
+		from: states("pending", "new"),
+		to:   State{Payment: "expired", Fulfillment: "canceled"},
+	},
+	TriggerRetryPayment: {
+		from: slices.Concat(
+			states("pending", "new"),
+			states("failed", "canceled"),
+			states("expired", "canceled"),
+		),
+		to: State{Payment: "pending", Fulfillment: "new"},
+	},
+	// An order refunded in part is still fulfilled.
+	TriggerStartPreparing: {
+		from: fulfillable("new"),
+		to:   State{Fulfillment: "preparing"},
+	},
+	TriggerStartDelivering: {
+		from: fulfillable("preparing"),
+		to:   State{Fulfillment: "delivering"},
+	},
+	TriggerComplete: {
+		from: fulfillable("delivering"),
+		to:   State{Fulfillment: "completed"},
+	},
+	TriggerCancel: {
+		from: states("pending", "new"),
+		to:   State{Payment: "canceled", Fulfillment: "canceled"},
+	},
+	TriggerCancelPaid: {
+		from: states("paid", "new", "preparing"),
+		to:   State{Payment: "refund_pending", Fulfillment: "canceled"},
+	},
+	TriggerPartialRefund: {
+		from: slices.Concat(
+			states("paid", activeFulfillment...),
+			states("refund_pending", "canceled"),
+			states("partially_refunded", anyFulfillment...),
+		),
+		to: State{Payment: "partially_refunded"},
+	},
+	TriggerRefund: {
+		from: slices.Concat(
+			states("paid", activeFulfillment...),
+			states("refund_pending", "canceled"),
+			states("partially_refunded", anyFulfillment...),
+		),
+		to: State{Payment: "refunded"},
+	},
+}
+
+// validStates are the states an order may be stored in. The
+// chk_payment_fulfillment constraint allows exactly these.
+var validStates = slices.Concat(
+	states("pending", "new"),
+	states("paid", activeFulfillment...),
+	states("failed", "canceled"),
+	states("expired", "canceled"),
+	states("canceled", "canceled"),
+	states("refund_pending", "canceled"),
+	states("partially_refunded", anyFulfillment...),
+	states("refunded", anyFulfillment...),
+)
+
+// Valid reports whether an order may be stored in s.
+func (s State) Valid() bool {
+	return slices.Contains(validStates, s)
+}
+
+// Can reports whether trigger is allowed from s.
+func (s State) Can(trigger Trigger) bool {
+	t, ok := transitions[trigger]
+	return ok && slices.Contains(t.from, s)
+}
+
+// Next returns the state trigger moves an order in current to, or
+// ErrInvalidOrderStatus when the trigger is not allowed from there.
+func Next(current State, trigger Trigger) (State, error) {
+	if !current.Can(trigger) {
+		return State{}, fmt.Errorf("%w: cannot %s an order that is %s", ErrInvalidOrderStatus, trigger, current)
+	}
+
+	next := transitions[trigger].to
+	if next.Payment == "" {
+		next.Payment = current.Payment
+	}
+	if next.Fulfillment == "" {
+		next.Fulfillment = current.Fulfillment
+	}
+
+	return next, nil
+}
+
+// ApplyTransition moves current with trigger, records the move on the order
+// timeline and commits, releases or renews the order's stock reservations to
+// match. update runs the guarded UPDATE for the trigger and reports
+// the rows it changed; none means the order changed since current was read,
+// which is reported as ErrInvalidOrderStatus. It must be called with the same
+// transactional queries update uses.
+func ApplyTransition(
+	ctx context.Context,
+	q *db.Queries,
+	current db.Order,
+	trigger Trigger,
+	actor Actor,
+	note string,
+	update func() (int64, error),
+) (State, error) {
+	from := StateOf(current)
+
+	to, err := Next(from, trigger)
+	if err != nil {
+		return State{}, err
+	}
+
+	affected, err := update()
+	if err != nil {
+		return State{}, fmt.Errorf("%s order %d: %w", trigger, current.ID, err)
+	}
+	if affected == 0 {
+		return State{}, fmt.Errorf("%w: order %d changed while trying to %s it", ErrInvalidOrderStatus, current.ID, trigger)
+	}
+
+	if err := RecordStatusEvent(ctx, q, StatusTransition{
+		OrderID:               int(current.ID),
+		FromPaymentStatus:     from.Payment,
+		ToPaymentStatus:       to.Payment,
+		FromFulfillmentStatus: from.Fulfillment,
+		ToFulfillmentStatus:   to.Fulfillment,
+		Actor:                 actor,
+		Note:                  note,
+	}); err != nil {
+		return State{}, err
+	}
+
+	if err := applyStock(ctx, q, current.ID, trigger); err != nil {
+		return State{}, err
+	}
+
+	return to, nil
+}
+
+// fulfillable returns the states an order that is being fulfilled can be in
+// at the given fulfillment step.
+func fulfillable(fulfillment string) []State {
+	return slices.Concat(
+		states("paid", fulfillment),
+		states("partially_refunded", fulfillment),
+	)
+}
+
+func states(payment string, fulfillment ...string) []State {
+	result := make([]State, 0, len(fulfillment))
+	for _, f := range fulfillment {
+		result = append(result, State{Payment: payment, Fulfillment: f})
+	}
+	return result
+}
@@ -0,0 +1,119 @@
+package orders
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    State
+		trigger Trigger
+		want    State
+		wantErr bool
+	}{
+		{"pay pending order", State{"pending", "new"}, TriggerPay, State{"paid", "new"}, false},
+		{"pay paid order", State{"paid", "new"}, TriggerPay, State{}, true},
+		{"pay canceled order", State{"canceled", "canceled"}, TriggerPay, State{}, true},
+		{"pay expired order", State{"expired", "canceled"}, TriggerPay, State{}, true},
+		{"pay canceled order late", State{"canceled", "canceled"}, TriggerPayCanceled, State{"refund_pending", "canceled"}, false},
+		{"pay pending order late", State{"pending", "new"}, TriggerPayCanceled, State{}, true},
+		{"fail pending payment", State{"pending", "new"}, TriggerFailPayment, State{"failed", "canceled"}, false},
+		{"fail paid payment", State{"paid", "preparing"}, TriggerFailPayment, State{}, true},
+		{"expire pending payment", State{"pending", "new"}, TriggerExpirePayment, State{"expired", "canceled"}, false},
+		{"expire failed payment", State{"failed", "canceled"}, TriggerExpirePayment, State{}, true},
+		{"retry failed payment", State{"failed", "canceled"}, TriggerRetryPayment, State{"pending", "new"}, false},
+		{"retry expired payment", State{"expired", "canceled"}, TriggerRetryPayment, State{"pending", "new"}, false},
+		{"replace pending payment", State{"pending", "new"}, TriggerRetryPayment, State{"pending", "new"}, false},
+		{"retry canceled order", State{"canceled", "canceled"}, TriggerRetryPayment, State{}, true},
+		{"retry paid order", State{"paid", "new"}, TriggerRetryPayment, State{}, true},
+		{"start preparing", State{"paid", "new"}, TriggerStartPreparing, State{"paid", "preparing"}, false},
+		{"start preparing unpaid", State{"pending", "new"}, TriggerStartPreparing, State{}, true},
+		{"start delivering", State{"paid", "preparing"}, TriggerStartDelivering, State{"paid", "delivering"}, false},
+		{"start delivering before preparing", State{"paid", "new"}, TriggerStartDelivering, State{}, true},
+		{"complete", State{"paid", "delivering"}, TriggerComplete, State{"paid", "completed"}, false},
+		{"complete twice", State{"paid", "completed"}, TriggerComplete, State{}, true},
+		{"start preparing partially refunded", State{"partially_refunded", "new"}, TriggerStartPreparing, State{"partially_refunded", "preparing"}, false},
+		{"start delivering partially refunded", State{"partially_refunded", "preparing"}, TriggerStartDelivering, State{"partially_refunded", "delivering"}, false},
+		{"complete partially refunded", State{"partially_refunded", "delivering"}, TriggerComplete, State{"partially_refunded", "completed"}, false},
+		{"start preparing refunded", State{"refunded", "new"}, TriggerStartPreparing, State{}, true},
+		{"complete canceled partially refunded", State{"partially_refunded", "canceled"}, TriggerComplete, State{}, true},
+		{"cancel pending", State{"pending", "new"}, TriggerCancel, State{"canceled", "canceled"}, false},
+		{"cancel paid as unpaid", State{"paid", "new"}, TriggerCancel, State{}, true},
+		{"cancel paid new", State{"paid", "new"}, TriggerCancelPaid, State{"refund_pending", "canceled"}, false},
+		{"cancel paid preparing", State{"paid", "preparing"}, TriggerCancelPaid, State{"refund_pending", "canceled"}, false},
+		{"cancel paid delivering", State{"paid", "delivering"}, TriggerCancelPaid, State{}, true},
+		{"partially refund completed", State{"paid", "completed"}, TriggerPartialRefund, State{"partially_refunded", "completed"}, false},
+		{"refund canceled", State{"refund_pending", "canceled"}, TriggerRefund, State{"refunded", "canceled"}, false},
+		{"refund rest", State{"partially_refunded", "delivering"}, TriggerRefund, State{"refunded", "delivering"}, false},
+		{"refund refunded", State{"refunded", "completed"}, TriggerRefund, State{}, true},
+		{"refund unpaid", State{"pending", "new"}, TriggerPartialRefund, State{}, true},
+		{"unknown trigger", State{"pending", "new"}, Trigger("ship"), State{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Next(tt.from, tt.trigger)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidOrderStatus) {
+					t.Fatalf("Next(%s, %s) error = %v, want ErrInvalidOrderStatus", tt.from, tt.trigger, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Next(%s, %s) error = %v", tt.from, tt.trigger, err)
+			}
+			if got != tt.want {
+				t.Errorf("Next(%s, %s) = %s, want %s", tt.from, tt.trigger, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransitionsKeepOrdersValid(t *testing.T) {
+	for trigger, transition := range transitions {
+		for _, from := range transition.from {
+			if !from.Valid() {
+				t.Errorf("%s is allowed from %s, which is not a valid state", trigger, from)
+			}
+
+			to, err := Next(from, trigger)
+			if err != nil {
+				t.Errorf("Next(%s, %s) error = %v", from, trigger, err)
+				continue
+			}
+			if !to.Valid() {
+				t.Errorf("%s moves %s to %s, which is not a valid state", trigger, from, to)
+			}
+		}
+	}
+}
+
+func TestStateValid(t *testing.T) {
+	tests := []struct {
+		state State
+		want  bool
+	}{
+		{State{"pending", "new"}, true},
+		{State{"pending", "canceled"}, false},
+		{State{"paid", "completed"}, true},
+		{State{"paid", "canceled"}, false},
+		{State{"failed", "canceled"}, true},
+		{State{"failed", "new"}, false},
+		{State{"expired", "canceled"}, true},
+		{State{"canceled", "new"}, false},
+		{State{"refund_pending", "preparing"}, false},
+		{State{"partially_refunded", "canceled"}, true},
+		{State{"refunded", "completed"}, true},
+		{State{"settled", "new"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.state.String(), func(t *testing.T) {
+			if got := tt.state.Valid(); got != tt.want {
+				t.Errorf("%s.Valid() = %v, want %v", tt.state, got, tt.want)
+			}
+		})
+	}
+}
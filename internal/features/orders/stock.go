@@ -0,0 +1,202 @@
+package orders
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/outbox"
+)
+
+// stockMetrics is published under /debug/vars as "stock_reservations".
+var stockMetrics = expvar.NewMap("stock_reservations")
+
+// StockEvent is the payload of stock.committed. Stock is reserved when an
+// order is placed, committed when it is paid and released when it ends
+// unpaid. The menu service owns stock levels: it reports the level with each
+// menu, this service holds parts of it for pending orders, and this event
+// hands paid parts back so the menu service can deduct them. Committed stock
+// keeps counting against the reported level until the event is delivered.
+type StockEvent struct {
+	OrderID int         `json:"order_id"`
+	Items   []StockItem `json:"items"`
+}
+
+type StockItem struct {
+	MenuID   int `json:"menu_id"`
+	Quantity int `json:"quantity"`
+}
+
+// reserveStock holds stock for every tracked menu in items, or returns
+// ErrOutOfStock when a menu does not have enough left. Menus are locked in
+// ID order so concurrent checkouts cannot deadlock.
+func reserveStock(ctx context.Context, q *db.Queries, orderID int32, items []CreateOrderItemInput, hold time.Duration) error {
+	type request struct {
+		name     string
+		quantity int
+		level    int
+	}
+
+	requests := make(map[int]*request)
+	for _, item := range items {
+		if item.Stock == nil {
+			continue
+		}
+		if r, ok := requests[item.MenuID]; ok {
+			r.quantity += item.Quantity
+			continue
+		}
+		requests[item.MenuID] = &request{name: item.MenuName, quantity: item.Quantity, level: *item.Stock}
+	}
+
+	menuIDs := make([]int, 0, len(requests))
+	for id := range requests {
+		menuIDs = append(menuIDs, id)
+	}
+	slices.Sort(menuIDs)
+
+	for _, id := range menuIDs {
+		r := requests[id]
+
+		if err := q.LockMenuStock(ctx, int32(id)); err != nil {
+			return fmt.Errorf("lock stock of menu %d: %w", id, err)
+		}
+
+		reserved, err := q.ReserveStock(ctx, db.ReserveStockParams{
+			OrderID:     orderID,
+			MenuID:      int32(id),
+			Quantity:    int32(r.quantity),
+			StockLevel:  int32(r.level),
+			HoldSeconds: int32(hold.Seconds()),
+		})
+		if err != nil {
+			return fmt.Errorf("reserve stock of menu %d: %w", id, err)
+		}
+		if reserved == 0 {
+			return fmt.Errorf("%w: not enough %s left", ErrOutOfStock, r.name)
+		}
+	}
+
+	return nil
+}
+
+// applyStock keeps an order's reservations in step with a transition that
+// was just applied to it.
+func applyStock(ctx context.Context, q *db.Queries, orderID int32, trigger Trigger) error {
+	switch trigger {
+	case TriggerPay:
+		committed, err := q.CommitOrderStock(ctx, orderID)
+		if err != nil {
+			return fmt.Errorf("commit stock: %w", err)
+		}
+		if len(committed) == 0 {
+			return nil
+		}
+
+		event := StockEvent{OrderID: int(orderID)}
+		for _, row := range committed {
+			event.Items = append(event.Items, StockItem{MenuID: int(row.MenuID), Quantity: int(row.Quantity)})
+		}
+		return outbox.Enqueue(ctx, q, outbox.AggregateOrder, int(orderID), outbox.EventStockCommitted, event)
+
+	case TriggerFailPayment, TriggerExpirePayment, TriggerCancel:
+		if _, err := q.ReleaseOrderStock(ctx, orderID); err != nil {
+			return fmt.Errorf("release stock: %w", err)
+		}
+		return nil
+
+	case TriggerRetryPayment:
+		return renewStock(ctx, q, orderID)
+
+	default:
+		return nil
+	}
+}
+
+// renewStock holds an order's reservations again for a new payment attempt.
+// Stock released when the previous attempt failed or expired may have been
+// taken by other orders since, in which case ErrOutOfStock is returned.
+func renewStock(ctx context.Context, q *db.Queries, orderID int32) error {
+	reservations, err := q.GetOrderStockReservations(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("get stock reservations: %w", err)
+	}
+
+	for _, r := range reservations {
+		if r.Status == "committed" {
+			continue
+		}
+
+		if err := q.LockMenuStock(ctx, r.MenuID); err != nil {
+			return fmt.Errorf("lock stock of menu %d: %w", r.MenuID, err)
+		}
+
+		renewed, err := q.RenewStockReservation(ctx, r.ID)
+		if err != nil {
+			return fmt.Errorf("renew stock reservation %d: %w", r.ID, err)
+		}
+		if renewed == 0 {
+			return fmt.Errorf("%w: menu %d sold out since the order was placed", ErrOutOfStock, r.MenuID)
+		}
+	}
+
+	return nil
+}
+
+func (s *svc) ReleaseStaleStock(ctx context.Context) (int, error) {
+	released, err := s.Queries.ReleaseStaleStock(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("release stale stock: %w", err)
+	}
+
+	return int(released), nil
+}
+
+// StockSweeper releases reservations that are no longer backed by a pending
+// order: holds that outlived their expiry, e.g. because the payment expiry
+// never arrived, and holds of orders that ended without their release.
+type StockSweeper struct {
+	orders   OrderRepository
+	interval time.Duration
+}
+
+func NewStockSweeper(orderRepo OrderRepository, interval time.Duration) *StockSweeper {
+	return &StockSweeper{
+		orders:   orderRepo,
+		interval: interval,
+	}
+}
+
+func (s *StockSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep(ctx)
+		}
+	}
+}
+
+func (s *StockSweeper) sweep(ctx context.Context) {
+	stockMetrics.Add("sweeps", 1)
+
+	released, err := s.orders.ReleaseStaleStock(ctx)
+	if err != nil {
+		stockMetrics.Add("errors", 1)
+		log.Printf("stock sweeper: %v", err)
+		return
+	}
+
+	if released > 0 {
+		stockMetrics.Add("released", int64(released))
+		log.Printf("stock sweeper: released %d stale reservations", released)
+	}
+}
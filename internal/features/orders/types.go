@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"time"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
 )
 
 var (
@@ -11,19 +13,42 @@ var (
 	ErrUnauthorizedAccess = errors.New("unauthorized access to order")
 	ErrInvalidOrderStatus = errors.New("invalid order status for this operation")
 	ErrEmptyOrderItems    = errors.New("order must have at least one item")
+	ErrInvalidCursor      = errors.New("invalid pagination cursor")
+	ErrInvalidSort        = errors.New("invalid sort option")
+	ErrMenuNotFound       = errors.New("menu not found")
+	ErrMenuUnavailable    = errors.New("menu service unavailable")
+	ErrInvalidMenu        = errors.New("invalid menu from menu service")
+	ErrOutOfStock         = errors.New("menu item out of stock")
+)
+
+const (
+	SourceUser    = "user"
+	SourceAdmin   = "admin"
+	SourceWebhook = "webhook"
+	SourceSystem  = "system"
 )
 
+// Actor identifies who triggered a status change. UserID is zero when the
+// change did not come from a logged-in user.
+type Actor struct {
+	UserID int    `json:"user_id,omitempty"`
+	Source string `json:"source"`
+}
+
 type Order struct {
-	ID                int       `json:"id"`
-	UserID            *int      `json:"user_id,omitempty"`
-	CustomerName      string    `json:"customer_name"`
-	Phone             string    `json:"phone"`
-	Address           string    `json:"address"`
-	Total             int       `json:"total"`
-	PaymentStatus     string    `json:"payment_status"`
-	FulfillmentStatus string    `json:"fulfillment_status"`
-	CreatedAt         time.Time `json:"created_at"`
-	UpdatedAt         time.Time `json:"updated_at"`
+	ID                int        `json:"id"`
+	UserID            *int       `json:"user_id,omitempty"`
+	CustomerName      string     `json:"customer_name"`
+	Phone             string     `json:"phone"`
+	Address           string     `json:"address"`
+	Total             int        `json:"total"`
+	PaymentStatus     string     `json:"payment_status"`
+	FulfillmentStatus string     `json:"fulfillment_status"`
+	CanceledBy        *int       `json:"canceled_by,omitempty"`
+	CancelReason      string     `json:"cancel_reason,omitempty"`
+	CanceledAt        *time.Time `json:"canceled_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
 }
 
 type OrderItem struct {
@@ -43,12 +68,66 @@ type OrderItemModifier struct {
 	Quantity          int    `json:"quantity"`
 }
 
+type OrderStatusEvent struct {
+	ID                    int       `json:"id"`
+	FromPaymentStatus     string    `json:"from_payment_status,omitempty"`
+	ToPaymentStatus       string    `json:"to_payment_status"`
+	FromFulfillmentStatus string    `json:"from_fulfillment_status,omitempty"`
+	ToFulfillmentStatus   string    `json:"to_fulfillment_status"`
+	ActorID               *int      `json:"actor_id,omitempty"`
+	Source                string    `json:"source"`
+	Note                  string    `json:"note,omitempty"`
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+type OrderPayment struct {
+	ID             int        `json:"id"`
+	ExternalID     string     `json:"external_id"`
+	GatewayName    string     `json:"gateway_name"`
+	Amount         int        `json:"amount"`
+	PaymentMethod  string     `json:"payment_method,omitempty"`
+	PaymentChannel string     `json:"payment_channel,omitempty"`
+	Status         string     `json:"status"`
+	Attempt        int        `json:"attempt"`
+	PaidAt         *time.Time `json:"paid_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
 type OrderDetail struct {
-	Items []OrderItem `json:"items"`
+	Order    *Order             `json:"order"`
+	Items    []OrderItem        `json:"items"`
+	Payment  *OrderPayment      `json:"payment"`
+	Timeline []OrderStatusEvent `json:"timeline"`
+}
+
+type StatusTransition struct {
+	OrderID               int
+	FromPaymentStatus     string
+	ToPaymentStatus       string
+	FromFulfillmentStatus string
+	ToFulfillmentStatus   string
+	Actor                 Actor
+	Note                  string
+}
+
+// OrderEvent is the payload of the order events published through the
+// outbox. Total, UserID and Items are only set on order.created.
+type OrderEvent struct {
+	OrderID                   int                    `json:"order_id"`
+	UserID                    *int                   `json:"user_id,omitempty"`
+	Total                     int                    `json:"total,omitempty"`
+	PaymentStatus             string                 `json:"payment_status"`
+	FulfillmentStatus         string                 `json:"fulfillment_status"`
+	PreviousPaymentStatus     string                 `json:"previous_payment_status,omitempty"`
+	PreviousFulfillmentStatus string                 `json:"previous_fulfillment_status,omitempty"`
+	Actor                     Actor                  `json:"actor"`
+	Note                      string                 `json:"note,omitempty"`
+	Items                     []CreateOrderItemInput `json:"items,omitempty"`
 }
 
 type CreateOrderInput struct {
 	UserID       *int                   `json:"user_id,omitempty"`
+	GatewayName  string                 `json:"gateway_name"`
 	CustomerName string                 `json:"customer_name"`
 	Phone        string                 `json:"phone"`
 	Address      string                 `json:"address"`
@@ -61,6 +140,9 @@ type CreateOrderItemInput struct {
 	Quantity  int                            `json:"quantity"`
 	Price     int                            `json:"price"`
 	Modifiers []CreateOrderItemModifierInput `json:"modifiers"`
+	// Stock is the menu's stock level as reported by the menu service, or
+	// nil when its stock is not tracked.
+	Stock *int `json:"-"`
 }
 
 type CreateOrderItemModifierInput struct {
@@ -68,11 +150,65 @@ type CreateOrderItemModifierInput struct {
 	ModifierItemName  string `json:"modifier_item_name"`
 	ModifierGroupName string `json:"modifier_group_name"`
 	Price             int    `json:"price"`
+	Quantity          int    `json:"quantity"`
+}
+
+type UserOrderFilter struct {
+	PaymentStatus     string `json:"payment_status"`
+	FulfillmentStatus string `json:"fulfillment_status"`
+	Limit             int    `json:"limit"`
+	Offset            int    `json:"offset"`
+}
+
+const (
+	SortByCreatedAt  = "created_at"
+	SortByOrderTotal = "order_total"
+)
+
+type OrderFilter struct {
+	PaymentStatus     string     `json:"payment_status"`
+	FulfillmentStatus string     `json:"fulfillment_status"`
+	CreatedFrom       *time.Time `json:"created_from"`
+	CreatedTo         *time.Time `json:"created_to"`
+	Phone             string     `json:"phone"`
+	Name              string     `json:"name"`
+	SortBy            string     `json:"sort_by"`
+	SortDesc          bool       `json:"sort_desc"`
+	Cursor            string     `json:"cursor"`
+	Limit             int        `json:"limit"`
+}
+
+type OrderPage struct {
+	Data       []*Order `json:"data"`
+	Total      int      `json:"total"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+type CancelOrderInput struct {
+	OrderID int    `json:"order_id"`
+	Actor   Actor  `json:"actor"`
+	IsAdmin bool   `json:"is_admin"`
+	Reason  string `json:"reason"`
+}
+
+type CancelOrderRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Checkout is an order whose payment request has not been confirmed yet.
+type Checkout struct {
+	OrderID       int    `json:"order_id"`
+	GatewayName   string `json:"gateway_name"`
+	Amount        int    `json:"amount"`
+	Attempts      int    `json:"attempts"`
+	PaymentStatus string `json:"payment_status"`
 }
 
 type OrderRequest struct {
-	Customer CustomerRequest   `json:"customer"`
-	Items    []MenuItemRequest `json:"items"`
+	Customer      CustomerRequest              `json:"customer"`
+	Gateway       string                       `json:"gateway,omitempty"`
+	PaymentMethod paymentgateway.PaymentMethod `json:"payment_method"`
+	Items         []MenuItemRequest            `json:"items"`
 }
 
 type CustomerRequest struct {
@@ -82,25 +218,42 @@ type CustomerRequest struct {
 }
 
 type MenuItemRequest struct {
-	MenuID           int64 `json:"menu_id"`
-	Quantity         int64 `json:"quantity"`
-	ModifiersItemsID []int `json:"modifiers-items-id"`
+	MenuID   int64 `json:"menu_id"`
+	Quantity int64 `json:"quantity"`
+	// ModifiersItemsID selects modifiers once each. Modifiers selects them
+	// with a quantity; a modifier may only appear once across both.
+	ModifiersItemsID []int             `json:"modifiers-items-id"`
+	Modifiers        []ModifierRequest `json:"modifiers"`
+}
+
+type ModifierRequest struct {
+	ID       int `json:"id"`
+	Quantity int `json:"quantity"`
 }
 
 type OrderRepository interface {
 	// Order operations
 	Create(ctx context.Context, params CreateOrderInput) (*Order, error)
-	GetAll(ctx context.Context, offset, limit int) ([]*Order, error)
-	GetAllByUserID(ctx context.Context, userID int) ([]*Order, error)
-	GetUserOrderDetails(ctx context.Context, userID, orderID int) (*OrderDetail, error)
+	GetAll(ctx context.Context, filter OrderFilter) (*OrderPage, error)
+	GetAllByUserID(ctx context.Context, userID int, filter UserOrderFilter) (*OrderPage, error)
+	GetUserOrderDetails(ctx context.Context, actor Actor, orderID int) (*OrderDetail, error)
 
 	// Kitchen workflow queries
-	// GetOrdersReadyToPrepare(ctx context.Context) ([]*Order, error)
-	// GetOrdersInPreparation(ctx context.Context) ([]*Order, error)
-	// GetOrdersForDelivery(ctx context.Context) ([]*Order, error)
+	GetOrdersReadyToPrepare(ctx context.Context) ([]*Order, error)
+	GetOrdersInPreparation(ctx context.Context) ([]*Order, error)
+	GetOrdersForDelivery(ctx context.Context) ([]*Order, error)
 
 	// Status transition
-	// MarkOrderPreparing(ctx context.Context, orderId int) error
-	// MarkOrderDelivering(ctx context.Context, orderId int) error
-	// MarkOrderCompleted(ctx context.Context, orderId int) error
+	MarkOrderPreparing(ctx context.Context, orderId int, actor Actor) error
+	MarkOrderDelivering(ctx context.Context, orderId int, actor Actor) error
+	MarkOrderCompleted(ctx context.Context, orderId int, actor Actor) error
+	CancelOrder(ctx context.Context, input CancelOrderInput) (*Order, error)
+
+	// Checkout saga
+	GetStalledCheckouts(ctx context.Context, staleAfter time.Duration, limit int) ([]*Checkout, error)
+	RecordCheckoutFailure(ctx context.Context, orderId int, reason string) error
+	CompensateCheckout(ctx context.Context, orderId int, reason string) error
+
+	// Stock
+	ReleaseStaleStock(ctx context.Context) (int, error)
 }
@@ -0,0 +1,30 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+)
+
+// Enqueue stores an event for the relay. q must be bound to the transaction
+// that makes the change the event describes, so the event is only published
+// if that change commits.
+func Enqueue(ctx context.Context, q *db.Queries, aggregateType string, aggregateID int, eventType string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal %s event: %w", eventType, err)
+	}
+
+	if err := q.CreateOutboxEvent(ctx, db.CreateOutboxEventParams{
+		AggregateType: aggregateType,
+		AggregateID:   int32(aggregateID),
+		EventType:     eventType,
+		Payload:       body,
+	}); err != nil {
+		return fmt.Errorf("enqueue %s event: %w", eventType, err)
+	}
+
+	return nil
+}
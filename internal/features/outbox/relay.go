@@ -0,0 +1,136 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+)
+
+const (
+	relayBatchSize = 100
+	relayLease     = time.Minute
+	maxRetryDelay  = 5 * time.Minute
+)
+
+// Relay publishes stored events. Each claim returns at most the oldest
+// pending event per aggregate, so events for one order are delivered in the
+// order they were written even when a publish has to be retried. Events that
+// fail maxAttempts times are left failed for an admin to replay.
+type Relay struct {
+	*db.Queries
+	publisher   Publisher
+	interval    time.Duration
+	maxAttempts int
+}
+
+func NewRelay(connPool *sql.DB, publisher Publisher, interval time.Duration, maxAttempts int) *Relay {
+	return &Relay{
+		Queries:     db.New(connPool),
+		publisher:   publisher,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.drain(ctx)
+		}
+	}
+}
+
+// drain keeps claiming until no event is ready, so a burst of events for the
+// same order does not wait one tick per event.
+func (r *Relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := r.Queries.ClaimOutboxEvents(ctx, db.ClaimOutboxEventsParams{
+			LeaseSeconds: int32(relayLease.Seconds()),
+			Limit:        relayBatchSize,
+		})
+		if err != nil {
+			log.Printf("outbox relay: claim events: %v", err)
+			return
+		}
+		if len(events) == 0 {
+			return
+		}
+
+		sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+		for _, event := range events {
+			r.publish(ctx, event)
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, event db.OutboxEvent) {
+	err := r.publisher.Publish(ctx, Event{
+		ID:            event.ID,
+		Type:          event.EventType,
+		AggregateType: event.AggregateType,
+		AggregateID:   int(event.AggregateID),
+		Payload:       event.Payload,
+		Attempt:       int(event.Attempts),
+		OccurredAt:    event.CreatedAt,
+	})
+	if err == nil {
+		if err := r.Queries.MarkOutboxEventPublished(ctx, event.ID); err != nil {
+			log.Printf("outbox relay: mark event %d published: %v", event.ID, err)
+		}
+		// Until the menu service has the deduction, its stock levels still
+		// include the committed stock, so reservations keep counting it.
+		if event.EventType == EventStockCommitted {
+			if _, err := r.Queries.ConfirmOrderStock(ctx, event.AggregateID); err != nil {
+				log.Printf("outbox relay: confirm stock of order %d: %v", event.AggregateID, err)
+			}
+		}
+		return
+	}
+
+	// Undelivered committed stock is kept out of sale, so stock.committed is
+	// retried until it is delivered instead of being given up on.
+	maxAttempts := r.maxAttempts
+	if event.EventType == EventStockCommitted {
+		maxAttempts = math.MaxInt32
+	}
+
+	if int(event.Attempts) >= maxAttempts {
+		log.Printf("outbox relay: giving up on event %d (%s) after %d attempts: %v", event.ID, event.EventType, event.Attempts, err)
+	} else {
+		log.Printf("outbox relay: publish event %d (%s): %v", event.ID, event.EventType, err)
+	}
+
+	if err := r.Queries.RecordOutboxEventFailure(ctx, db.RecordOutboxEventFailureParams{
+		MaxAttempts:  int32(maxAttempts),
+		LastError:    sql.NullString{String: err.Error(), Valid: true},
+		RetrySeconds: int32(retryDelay(int(event.Attempts)).Seconds()),
+		ID:           event.ID,
+	}); err != nil {
+		log.Printf("outbox relay: record failure for event %d: %v", event.ID, err)
+	}
+}
+
+// retryDelay backs off exponentially from one second up to maxRetryDelay.
+func retryDelay(attempt int) time.Duration {
+	if attempt > 16 {
+		return maxRetryDelay
+	}
+
+	delay := time.Second << attempt
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,67 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+)
+
+type svc struct {
+	*db.Queries
+}
+
+func NewService(connPool *sql.DB) *svc {
+	return &svc{
+		Queries: db.New(connPool),
+	}
+}
+
+func (s *svc) GetFailedEvents(ctx context.Context, limit, offset int) ([]*StoredEvent, error) {
+	dbEvents, err := s.Queries.GetFailedOutboxEvents(ctx, db.GetFailedOutboxEventsParams{
+		Offset: int32(offset),
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get failed outbox events: %w", err)
+	}
+
+	events := make([]*StoredEvent, 0, len(dbEvents))
+	for _, dbEvent := range dbEvents {
+		events = append(events, toStoredEvent(dbEvent))
+	}
+
+	return events, nil
+}
+
+func (s *svc) Replay(ctx context.Context, id int64) error {
+	replayed, err := s.Queries.ReplayOutboxEvent(ctx, id)
+	if err != nil {
+		return fmt.Errorf("replay outbox event: %w", err)
+	}
+	if replayed == 0 {
+		return ErrEventNotReplayable
+	}
+
+	return nil
+}
+
+func toStoredEvent(event db.OutboxEvent) *StoredEvent {
+	result := &StoredEvent{
+		ID:            event.ID,
+		AggregateType: event.AggregateType,
+		AggregateID:   int(event.AggregateID),
+		EventType:     event.EventType,
+		Payload:       event.Payload,
+		Status:        event.Status,
+		Attempts:      int(event.Attempts),
+		LastError:     event.LastError.String,
+		CreatedAt:     event.CreatedAt,
+	}
+	if event.PublishedAt.Valid {
+		result.PublishedAt = &event.PublishedAt.Time
+	}
+
+	return result
+}
@@ -0,0 +1,69 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const AggregateOrder = "order"
+
+const (
+	EventOrderCreated           = "order.created"
+	EventOrderPaid              = "order.paid"
+	EventOrderPaymentFailed     = "order.payment_failed"
+	EventOrderPaymentExpired    = "order.payment_expired"
+	EventOrderPaymentRetried    = "order.payment_retried"
+	EventOrderPreparing         = "order.preparing"
+	EventOrderDelivering        = "order.delivering"
+	EventOrderCompleted         = "order.completed"
+	EventOrderCanceled          = "order.canceled"
+	EventOrderPartiallyRefunded = "order.partially_refunded"
+	EventOrderRefunded          = "order.refunded"
+	EventPaymentSettled         = "payment.settled"
+	EventRefundSucceeded        = "refund.succeeded"
+	EventRefundFailed           = "refund.failed"
+	EventStockCommitted         = "stock.committed"
+)
+
+// Event is a domain event as it is handed to a Publisher.
+type Event struct {
+	ID            int64           `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   int             `json:"aggregate_id"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempt       int             `json:"attempt"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+}
+
+// Publisher delivers events to other services. Delivery is at least once, so
+// consumers should dedupe on Event.ID.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+var ErrEventNotReplayable = errors.New("outbox event not found or not failed")
+
+// StoredEvent is an event as the outbox keeps it.
+type StoredEvent struct {
+	ID            int64           `json:"id"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   int             `json:"aggregate_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	PublishedAt   *time.Time      `json:"published_at,omitempty"`
+}
+
+type OutboxService interface {
+	// GetFailedEvents lists events the relay gave up on, newest first.
+	GetFailedEvents(ctx context.Context, limit, offset int) ([]*StoredEvent, error)
+	// Replay hands a failed event back to the relay. Later events of the
+	// same aggregate may already have been published by then.
+	Replay(ctx context.Context, id int64) error
+}
@@ -0,0 +1,138 @@
+package payments
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
+)
+
+const expiryBatchSize = 100
+
+// expiryMetrics is published under /debug/vars as "payment_expiry".
+var expiryMetrics = expvar.NewMap("payment_expiry")
+
+// ExpirySweeper expires pending payments whose TTL has run out, so an order
+// whose expiry callback never arrived does not stay pending forever. The
+// gateway is asked for the request's status first, because a payment that
+// was made just before the deadline must not be expired locally. It also
+// voids the pending payments of canceled orders, which the cancellation
+// leaves to it, and hands payments captured on them to refunds.
+type ExpirySweeper struct {
+	payments PaymentService
+	gateways *paymentgateway.Registry
+	interval time.Duration
+	grace    time.Duration
+}
+
+func NewExpirySweeper(paymentService PaymentService, gateways *paymentgateway.Registry, interval, grace time.Duration) *ExpirySweeper {
+	return &ExpirySweeper{
+		payments: paymentService,
+		gateways: gateways,
+		interval: interval,
+		grace:    grace,
+	}
+}
+
+func (s *ExpirySweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep(ctx)
+		}
+	}
+}
+
+func (s *ExpirySweeper) sweep(ctx context.Context) {
+	expiryMetrics.Add("sweeps", 1)
+
+	overdue, err := s.payments.GetOverduePayments(ctx, s.grace, expiryBatchSize)
+	if err != nil {
+		expiryMetrics.Add("errors", 1)
+		log.Printf("expiry sweeper: %v", err)
+		return
+	}
+
+	for _, payment := range overdue {
+		s.settle(ctx, payment, "expired")
+	}
+
+	canceled, err := s.payments.GetCanceledOrderPayments(ctx, expiryBatchSize)
+	if err != nil {
+		expiryMetrics.Add("errors", 1)
+		log.Printf("expiry sweeper: %v", err)
+		return
+	}
+
+	for _, payment := range canceled {
+		s.settle(ctx, payment, "canceled")
+	}
+}
+
+func (s *ExpirySweeper) settle(ctx context.Context, payment *Payment, voided string) {
+	status, err := s.resolve(ctx, payment, voided)
+	if err != nil {
+		expiryMetrics.Add("errors", 1)
+		log.Printf("expiry sweeper: payment %s: %v", payment.ExternalID, err)
+		return
+	}
+	expiryMetrics.Add(status, 1)
+}
+
+// resolve moves a payment to the state the gateway reports, voiding requests
+// that are still open and giving those the voided status, and returns the
+// status it was given.
+func (s *ExpirySweeper) resolve(ctx context.Context, payment *Payment, voided string) (string, error) {
+	gateway, err := s.gateways.Get(payment.GatewayName)
+	if err != nil {
+		return "", err
+	}
+
+	remote, err := gateway.GetPaymentStatus(ctx, payment.ExternalID)
+	if err != nil {
+		return "", fmt.Errorf("get gateway status: %w", err)
+	}
+
+	status, transactionID := voided, ""
+	switch remote.Status {
+	case paymentgateway.StatusPaid:
+		// A capture of the wrong amount is left pending for an admin.
+		if remote.Amount != payment.Amount {
+			return "", fmt.Errorf("%w: captured %d, expected %d", ErrAmountMismatch, remote.Amount, payment.Amount)
+		}
+		status, transactionID = "paid", remote.TransactionID
+	case paymentgateway.StatusFailed:
+		status = "failed"
+	case paymentgateway.StatusPending:
+		// Void the request first so it cannot be paid after the order has
+		// been expired or canceled.
+		if err := gateway.CancelPaymentRequest(ctx, payment.ExternalID); err != nil {
+			return "", fmt.Errorf("void payment request: %w", err)
+		}
+	}
+
+	if status == "canceled" {
+		return status, s.payments.MarkPaymentCanceled(ctx, payment.ExternalID)
+	}
+
+	if err := s.payments.UpdatePaymentStatus(ctx, UpdatePaymentStatusInput{
+		OrderID:              payment.OrderID,
+		PaymentRequestID:     payment.ExternalID,
+		GatewayTransactionID: transactionID,
+		Status:               status,
+		Source:               orders.SourceSystem,
+	}); err != nil {
+		return "", err
+	}
+
+	return status, nil
+}
@@ -3,11 +3,20 @@ package payments
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/outbox"
+	"github.com/lib/pq"
 )
 
+// uniqueViolation is the Postgres error code for a unique constraint
+// violation.
+const uniqueViolation = "23505"
+
 type svc struct {
 	*db.Queries
 	connPool *sql.DB
@@ -20,32 +29,272 @@ func NewService(connPool *sql.DB) *svc {
 	}
 }
 
-func (s *svc) CreatePayment(ctx context.Context, input CreatePaymentInput) (*Payment, error) {
-	payment, err := s.Queries.CreatePayment(ctx, db.CreatePaymentParams{
+// CompleteCheckout stores the payment record for a checkout and closes its
+// saga in one transaction, so a payment never exists for a compensated order.
+func (s *svc) CompleteCheckout(ctx context.Context, input CreatePaymentInput) (*Payment, error) {
+	tx, err := s.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	qtx := s.Queries.WithTx(tx)
+
+	payment, err := qtx.CreatePayment(ctx, db.CreatePaymentParams{
 		OrderID:     int32(input.OrderID),
 		ExternalID:  input.ExternalID,
 		GatewayName: input.GatewayName,
 		Amount:      int32(input.Amount),
+		Attempt:     int32(max(input.Attempt, 1)),
+		PaymentMethod: sql.NullString{
+			String: input.PaymentMethod,
+			Valid:  input.PaymentMethod != "",
+		},
+		PaymentChannel: sql.NullString{
+			String: input.PaymentChannel,
+			Valid:  input.PaymentChannel != "",
+		},
+		TtlSeconds: sql.NullInt32{
+			Int32: int32(input.TTL.Seconds()),
+			Valid: input.TTL > 0,
+		},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create payment record: %w", err)
 	}
 
-	return &Payment{
-		ID:                   int(payment.ID),
-		OrderID:              int(payment.OrderID),
-		ExternalID:           payment.ExternalID,
-		GatewayTransactionID: payment.GatewayTransactionID.String,
-		GatewayName:          payment.GatewayName,
-		Amount:               int(payment.Amount),
-		PaymentChannel:       payment.PaymentChannel.String,
-		Status:               payment.Status,
-		PaidAt:               payment.PaidAt.Time,
-		CreatedAt:            payment.CreatedAt,
-		UpdatedAt:            payment.UpdatedAt,
-	}, nil
+	completed, err := qtx.CompleteCheckoutSaga(ctx, int32(input.OrderID))
+	if err != nil {
+		return nil, fmt.Errorf("complete checkout saga: %w", err)
+	}
+	if completed == 0 {
+		return nil, ErrCheckoutClosed
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return toPayment(payment), nil
+}
+
+// CompletePaidCheckout stores the payment record of a stalled checkout whose
+// gateway request turned out to be paid already, and marks the payment and
+// its order paid in the same transaction that closes the saga.
+func (s *svc) CompletePaidCheckout(ctx context.Context, input CreatePaymentInput) (*Payment, error) {
+	tx, err := s.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	qtx := s.Queries.WithTx(tx)
+
+	payment, err := qtx.CreatePayment(ctx, db.CreatePaymentParams{
+		OrderID:     int32(input.OrderID),
+		ExternalID:  input.ExternalID,
+		GatewayName: input.GatewayName,
+		Amount:      int32(input.Amount),
+		Attempt:     int32(max(input.Attempt, 1)),
+		PaymentMethod: sql.NullString{
+			String: input.PaymentMethod,
+			Valid:  input.PaymentMethod != "",
+		},
+		PaymentChannel: sql.NullString{
+			String: input.PaymentChannel,
+			Valid:  input.PaymentChannel != "",
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payment record: %w", err)
+	}
+
+	completed, err := qtx.CompleteCheckoutSaga(ctx, int32(input.OrderID))
+	if err != nil {
+		return nil, fmt.Errorf("complete checkout saga: %w", err)
+	}
+	if completed == 0 {
+		return nil, ErrCheckoutClosed
+	}
+
+	current, err := qtx.GetOrderById(ctx, int32(input.OrderID))
+	if err != nil {
+		return nil, fmt.Errorf("get order: %w", err)
+	}
+
+	if err := markPaid(ctx, qtx, current, orders.Actor{Source: orders.SourceSystem}, UpdatePaymentStatusInput{
+		OrderID:          input.OrderID,
+		PaymentRequestID: input.ExternalID,
+		PaymentChannel:   input.PaymentChannel,
+		Status:           "paid",
+		Source:           orders.SourceSystem,
+	}); err != nil {
+		return nil, err
+	}
+
+	payment, err = qtx.LockPaymentByExternalID(ctx, input.ExternalID)
+	if err != nil {
+		return nil, fmt.Errorf("get payment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return toPayment(payment), nil
+}
+
+// RetryPayment stores a new payment attempt for an order. The attempt it
+// replaces, if still pending, is marked superseded, and an order whose
+// payment failed or expired is put back to awaiting payment.
+func (s *svc) RetryPayment(ctx context.Context, input RetryPaymentInput) (*Payment, error) {
+	tx, err := s.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	qtx := s.Queries.WithTx(tx)
+
+	if input.Supersedes != "" {
+		superseded, err := qtx.MarkPaymentSuperseded(ctx, input.Supersedes)
+		if err != nil {
+			return nil, fmt.Errorf("mark payment superseded: %w", err)
+		}
+		if superseded == 0 {
+			return nil, ErrInvalidPaymentStatus
+		}
+	}
+
+	current, err := qtx.GetOrderById(ctx, int32(input.Payment.OrderID))
+	if err != nil {
+		return nil, fmt.Errorf("get order: %w", err)
+	}
+
+	note := fmt.Sprintf("payment attempt %d", input.Payment.Attempt)
+	if _, err := orders.ApplyTransition(ctx, qtx, current, orders.TriggerRetryPayment, input.Actor, note, func() (int64, error) {
+		return qtx.ReopenOrderPayment(ctx, current.ID)
+	}); err != nil {
+		return nil, err
+	}
+
+	payment, err := qtx.CreatePayment(ctx, db.CreatePaymentParams{
+		OrderID:     int32(input.Payment.OrderID),
+		ExternalID:  input.Payment.ExternalID,
+		GatewayName: input.Payment.GatewayName,
+		Amount:      int32(input.Payment.Amount),
+		Attempt:     int32(input.Payment.Attempt),
+		PaymentMethod: sql.NullString{
+			String: input.Payment.PaymentMethod,
+			Valid:  input.Payment.PaymentMethod != "",
+		},
+		PaymentChannel: sql.NullString{
+			String: input.Payment.PaymentChannel,
+			Valid:  input.Payment.PaymentChannel != "",
+		},
+		TtlSeconds: sql.NullInt32{
+			Int32: int32(input.Payment.TTL.Seconds()),
+			Valid: input.Payment.TTL > 0,
+		},
+	})
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return nil, ErrAttemptConflict
+		}
+		return nil, fmt.Errorf("failed to create payment record: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return toPayment(payment), nil
+}
+
+func (s *svc) GetPaymentByGatewayID(ctx context.Context, gatewayID string) (*Payment, error) {
+	dbPayments, err := s.Queries.GetPaymentByExternalID(ctx, db.GetPaymentByExternalIDParams{
+		ExternalID: gatewayID,
+		Offset:     0,
+		Limit:      1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get payment by external id: %w", err)
+	}
+	if len(dbPayments) == 0 {
+		return nil, ErrPaymentNotFound
+	}
+
+	return toPayment(dbPayments[0]), nil
+}
+
+func (s *svc) GetLatestPaymentByOrderID(ctx context.Context, orderID int) (*Payment, error) {
+	dbPayments, err := s.Queries.GetPaymentsByOrderID(ctx, db.GetPaymentsByOrderIDParams{
+		OrderID: int32(orderID),
+		Offset:  0,
+		Limit:   1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get payments by order id: %w", err)
+	}
+	if len(dbPayments) == 0 {
+		return nil, ErrPaymentNotFound
+	}
+
+	return toPayment(dbPayments[0]), nil
 }
 
+// GetOverduePayments returns pending payments whose expiry passed more than
+// grace ago, oldest first.
+func (s *svc) GetOverduePayments(ctx context.Context, grace time.Duration, limit int) ([]*Payment, error) {
+	dbPayments, err := s.Queries.GetOverduePayments(ctx, db.GetOverduePaymentsParams{
+		GraceSeconds: int32(grace.Seconds()),
+		Limit:        int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get overdue payments: %w", err)
+	}
+
+	overdue := make([]*Payment, 0, len(dbPayments))
+	for _, dbPayment := range dbPayments {
+		overdue = append(overdue, toPayment(dbPayment))
+	}
+
+	return overdue, nil
+}
+
+// GetCanceledOrderPayments returns pending payments of canceled orders, whose
+// gateway requests still have to be voided.
+func (s *svc) GetCanceledOrderPayments(ctx context.Context, limit int) ([]*Payment, error) {
+	dbPayments, err := s.Queries.GetCanceledOrderPayments(ctx, int32(limit))
+	if err != nil {
+		return nil, fmt.Errorf("get canceled order payments: %w", err)
+	}
+
+	canceled := make([]*Payment, 0, len(dbPayments))
+	for _, dbPayment := range dbPayments {
+		canceled = append(canceled, toPayment(dbPayment))
+	}
+
+	return canceled, nil
+}
+
+func (s *svc) MarkPaymentCanceled(ctx context.Context, externalID string) error {
+	affected, err := s.Queries.MarkPaymentCanceled(ctx, externalID)
+	if err != nil {
+		return fmt.Errorf("mark payment canceled failed: %w", err)
+	}
+	if affected == 0 {
+		return ErrInvalidPaymentStatus
+	}
+
+	return nil
+}
+
+// UpdatePaymentStatus applies a gateway outcome to a payment attempt and
+// moves its order through the state machine. A failed or expired attempt of
+// an order that already moved on only ends the payment, but a capture for
+// such an order is an error, since the money has to be returned.
 func (s *svc) UpdatePaymentStatus(ctx context.Context, input UpdatePaymentStatusInput) error {
 	tx, err := s.connPool.BeginTx(ctx, nil)
 	if err != nil {
@@ -55,49 +304,104 @@ func (s *svc) UpdatePaymentStatus(ctx context.Context, input UpdatePaymentStatus
 
 	qtx := s.Queries.WithTx(tx)
 
-	switch input.Status {
-	case "paid":
-		if err := qtx.MarkOrderPaid(ctx, int32(input.OrderID)); err != nil {
-			return fmt.Errorf("mark order paid failed: %w", err)
+	payment, err := qtx.LockPaymentByExternalID(ctx, input.PaymentRequestID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPaymentNotFound
 		}
+		return fmt.Errorf("lock payment: %w", err)
+	}
 
-		if err := qtx.MarkPaymentPaid(ctx, db.MarkPaymentPaidParams{
+	// Only the pending attempt may move the order. Outcomes for attempts that
+	// already ended, e.g. a late expiry of a retried payment, are ignored,
+	// except captures. Money captured on a superseded attempt is recorded for
+	// the refund worker to return, and a capture on an attempt voided by a
+	// cancellation is applied, so the money is refunded.
+	if input.Status == "paid" && payment.Status == "superseded" {
+		captured, err := qtx.MarkSupersededPaymentCaptured(ctx, db.MarkSupersededPaymentCapturedParams{
 			PaymentChannel: sql.NullString{
 				String: input.PaymentChannel,
-				Valid:  true,
+				Valid:  input.PaymentChannel != "",
 			},
 			GatewayTransactionID: sql.NullString{
 				String: input.GatewayTransactionID,
-				Valid:  true,
+				Valid:  input.GatewayTransactionID != "",
 			},
 			ExternalID: input.PaymentRequestID,
-		}); err != nil {
-			return fmt.Errorf("mark payment paid failed: %w", err)
+		})
+		if err := requireRow(captured, err, "mark superseded payment captured"); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit tx: %w", err)
+		}
+		return nil
+	}
+
+	voidedCapture := input.Status == "paid" && payment.Status == "canceled"
+	if input.Status != "settled" && payment.Status != "pending" && !voidedCapture {
+		return nil
+	}
+
+	current, err := qtx.GetOrderById(ctx, int32(input.OrderID))
+	if err != nil {
+		return fmt.Errorf("get order: %w", err)
+	}
+
+	actor := orders.Actor{Source: input.Source}
+
+	switch input.Status {
+	case "paid":
+		if err := markPaid(ctx, qtx, current, actor, input); err != nil {
+			return err
 		}
 
 	case "failed":
-		if err := qtx.MarkOrderPaymentFailed(ctx, int32(input.OrderID)); err != nil {
-			return fmt.Errorf("mark order payment failed failed: %w", err)
+		failed, err := qtx.MarkPaymentFailed(ctx, input.PaymentRequestID)
+		if err := requireRow(failed, err, "mark payment failed"); err != nil {
+			return err
 		}
 
-		if err := qtx.MarkOrderPaymentFailed(ctx, int32(input.OrderID)); err != nil {
-			return fmt.Errorf("mark order payment failed failed: %w", err)
+		if orders.StateOf(current).Can(orders.TriggerFailPayment) {
+			if _, err := orders.ApplyTransition(ctx, qtx, current, orders.TriggerFailPayment, actor, "", func() (int64, error) {
+				return qtx.MarkOrderPaymentFailed(ctx, current.ID)
+			}); err != nil {
+				return err
+			}
 		}
 
 	case "expired":
-		if err := qtx.MarkOrderPaymentExpired(ctx, int32(input.OrderID)); err != nil {
-			return fmt.Errorf("mark order payment expired failed: %w", err)
+		expired, err := qtx.MarkPaymentExpired(ctx, input.PaymentRequestID)
+		if err := requireRow(expired, err, "mark payment expired"); err != nil {
+			return err
 		}
 
-		if err := qtx.MarkOrderPaymentExpired(ctx, int32(input.OrderID)); err != nil {
-			return fmt.Errorf("mark order payment expired failed: %w", err)
+		if orders.StateOf(current).Can(orders.TriggerExpirePayment) {
+			if _, err := orders.ApplyTransition(ctx, qtx, current, orders.TriggerExpirePayment, actor, "", func() (int64, error) {
+				return qtx.MarkOrderPaymentExpired(ctx, current.ID)
+			}); err != nil {
+				return err
+			}
 		}
 
 	case "settled":
-		if err := qtx.MarkPaymentSettled(ctx, input.PaymentRequestID); err != nil {
+		settled, err := qtx.MarkPaymentSettled(ctx, input.PaymentRequestID)
+		if err != nil {
 			return fmt.Errorf("mark payment settled failed: %w", err)
 		}
 
+		if settled > 0 {
+			if err := outbox.Enqueue(ctx, qtx, outbox.AggregateOrder, input.OrderID, outbox.EventPaymentSettled, PaymentEvent{
+				OrderID:          input.OrderID,
+				PaymentRequestID: input.PaymentRequestID,
+				Status:           input.Status,
+				Source:           input.Source,
+			}); err != nil {
+				return err
+			}
+		}
+
 	default:
 		return fmt.Errorf("unsupported payment status: %s", input.Status)
 	}
@@ -108,3 +412,68 @@ func (s *svc) UpdatePaymentStatus(ctx context.Context, input UpdatePaymentStatus
 
 	return nil
 }
+
+// markPaid moves a payment attempt to paid, and its order to paid, or to
+// refund_pending when the order was canceled before the payment arrived. The
+// channel and transaction ID stored with the payment are kept unless the
+// gateway reported them.
+func markPaid(ctx context.Context, qtx *db.Queries, current db.Order, actor orders.Actor, input UpdatePaymentStatusInput) error {
+	trigger, note, update := orders.TriggerPay, "", qtx.MarkOrderPaid
+	if orders.StateOf(current).Can(orders.TriggerPayCanceled) {
+		trigger, note, update = orders.TriggerPayCanceled, "paid after cancellation", qtx.MarkCanceledOrderPaid
+	}
+
+	if _, err := orders.ApplyTransition(ctx, qtx, current, trigger, actor, note, func() (int64, error) {
+		return update(ctx, current.ID)
+	}); err != nil {
+		return fmt.Errorf("payment %s captured: %w", input.PaymentRequestID, err)
+	}
+
+	paid, err := qtx.MarkPaymentPaid(ctx, db.MarkPaymentPaidParams{
+		PaymentChannel: sql.NullString{
+			String: input.PaymentChannel,
+			Valid:  input.PaymentChannel != "",
+		},
+		GatewayTransactionID: sql.NullString{
+			String: input.GatewayTransactionID,
+			Valid:  input.GatewayTransactionID != "",
+		},
+		ExternalID: input.PaymentRequestID,
+	})
+	return requireRow(paid, err, "mark payment paid")
+}
+
+// requireRow checks that a guarded payment update changed the payment; the
+// payment row is locked, so no rows means it was not pending.
+func requireRow(affected int64, err error, op string) error {
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, ErrInvalidPaymentStatus)
+	}
+	return nil
+}
+
+func toPayment(payment db.Payment) *Payment {
+	result := &Payment{
+		ID:                   int(payment.ID),
+		OrderID:              int(payment.OrderID),
+		ExternalID:           payment.ExternalID,
+		GatewayTransactionID: payment.GatewayTransactionID.String,
+		GatewayName:          payment.GatewayName,
+		Amount:               int(payment.Amount),
+		PaymentMethod:        payment.PaymentMethod.String,
+		PaymentChannel:       payment.PaymentChannel.String,
+		Status:               payment.Status,
+		Attempt:              int(payment.Attempt),
+		PaidAt:               payment.PaidAt.Time,
+		CreatedAt:            payment.CreatedAt,
+		UpdatedAt:            payment.UpdatedAt,
+	}
+	if payment.ExpiresAt.Valid {
+		result.ExpiresAt = &payment.ExpiresAt.Time
+	}
+
+	return result
+}
@@ -2,30 +2,57 @@ package payments
 
 import (
 	"context"
+	"errors"
 	"time"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+)
+
+var (
+	ErrPaymentNotFound      = errors.New("payment not found")
+	ErrInvalidPaymentStatus = errors.New("invalid payment status for this operation")
+	ErrCheckoutClosed       = errors.New("checkout is no longer in progress")
+	ErrAttemptConflict      = errors.New("another payment attempt was created for this order")
+	ErrAmountMismatch       = errors.New("gateway captured a different amount than the payment was created for")
 )
 
 type Payment struct {
-	ID                   int       `json:"id"`
-	OrderID              int       `json:"order_id"`
-	ExternalID           string    `json:"external_id"`
-	GatewayTransactionID string    `json:"gateway_transaction_id"`
-	GatewayName          string    `json:"gateway_name"`
-	Amount               int       `json:"amount"`
-	PaymentMethod        string    `json:"payment_method"`
-	PaymentChannel       string    `json:"payment_channel"`
-	Status               string    `json:"status"`
-	PaidAt               time.Time `json:"paid_at"`
-	CreatedAt            time.Time `json:"created_at"`
-	UpdatedAt            time.Time `json:"updated_at"`
+	ID                   int        `json:"id"`
+	OrderID              int        `json:"order_id"`
+	ExternalID           string     `json:"external_id"`
+	GatewayTransactionID string     `json:"gateway_transaction_id"`
+	GatewayName          string     `json:"gateway_name"`
+	Amount               int        `json:"amount"`
+	PaymentMethod        string     `json:"payment_method"`
+	PaymentChannel       string     `json:"payment_channel"`
+	Status               string     `json:"status"`
+	Attempt              int        `json:"attempt"`
+	PaidAt               time.Time  `json:"paid_at"`
+	ExpiresAt            *time.Time `json:"expires_at,omitempty"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
 }
 
 type CreatePaymentInput struct {
-	OrderID        int    `json:"order_id"`
-	ExternalID     string `json:"external_id"`
-	GatewayName    string `json:"gateway_name"`
-	PaymentChannel string `json:"payment_channel"`
-	Amount         int    `json:"amount"`
+	OrderID        int           `json:"order_id"`
+	ExternalID     string        `json:"external_id"`
+	GatewayName    string        `json:"gateway_name"`
+	PaymentMethod  string        `json:"payment_method"`
+	PaymentChannel string        `json:"payment_channel"`
+	Amount         int           `json:"amount"`
+	TTL            time.Duration `json:"ttl"`
+	// Attempt numbers the payments of an order from 1; zero means 1.
+	Attempt int `json:"attempt"`
+}
+
+// RetryPaymentInput is a new payment attempt for an order whose previous
+// attempt failed, expired or is being replaced.
+type RetryPaymentInput struct {
+	Payment CreatePaymentInput
+	// Supersedes is the payment request of the pending attempt being
+	// replaced, if any.
+	Supersedes string
+	Actor      orders.Actor
 }
 
 type UpdatePaymentStatusInput struct {
@@ -34,9 +61,25 @@ type UpdatePaymentStatusInput struct {
 	PaymentChannel       string `json:"payment_channel"`
 	GatewayTransactionID string `json:"gateway_transaction_id"`
 	Status               string `json:"status"`
+	Source               string `json:"source"`
+}
+
+// PaymentEvent is the payload of payment events published through the outbox.
+type PaymentEvent struct {
+	OrderID          int    `json:"order_id"`
+	PaymentRequestID string `json:"payment_request_id"`
+	Status           string `json:"status"`
+	Source           string `json:"source"`
 }
+
 type PaymentService interface {
-	CreatePayment(ctx context.Context, input CreatePaymentInput) (*Payment, error)
-	// GetPaymentByGatewayID(ctx context.Context, gatewayID string) (*Payment, error)
+	CompleteCheckout(ctx context.Context, input CreatePaymentInput) (*Payment, error)
+	CompletePaidCheckout(ctx context.Context, input CreatePaymentInput) (*Payment, error)
+	RetryPayment(ctx context.Context, input RetryPaymentInput) (*Payment, error)
+	GetPaymentByGatewayID(ctx context.Context, gatewayID string) (*Payment, error)
+	GetLatestPaymentByOrderID(ctx context.Context, orderID int) (*Payment, error)
+	GetOverduePayments(ctx context.Context, grace time.Duration, limit int) ([]*Payment, error)
+	GetCanceledOrderPayments(ctx context.Context, limit int) ([]*Payment, error)
 	UpdatePaymentStatus(ctx context.Context, input UpdatePaymentStatusInput) error
+	MarkPaymentCanceled(ctx context.Context, externalID string) error
 }
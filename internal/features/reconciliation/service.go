@@ -0,0 +1,327 @@
+package reconciliation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/payments"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
+)
+
+const pageSize = 100
+
+// svc compares the payments created in a date range with the gateway's view
+// of them and writes what differs to the reconciliation tables.
+type svc struct {
+	*db.Queries
+	payments payments.PaymentService
+	gateways *paymentgateway.Registry
+}
+
+func NewService(connPool *sql.DB, paymentService payments.PaymentService, gateways *paymentgateway.Registry) *svc {
+	return &svc{
+		Queries:  db.New(connPool),
+		payments: paymentService,
+		gateways: gateways,
+	}
+}
+
+// orderKey identifies the gateway requests made for one order.
+type orderKey struct {
+	orderID     int
+	gatewayName string
+}
+
+// Reconcile checks every payment created in [From, To). Payments that cannot
+// be checked are counted as errors and do not stop the run.
+func (s *svc) Reconcile(ctx context.Context, input RunInput) (*Run, error) {
+	if !input.To.After(input.From) {
+		return nil, ErrInvalidRange
+	}
+
+	dbRun, err := s.Queries.CreateReconciliationRun(ctx, db.CreateReconciliationRunParams{
+		RangeStart: input.From,
+		RangeEnd:   input.To,
+		Apply:      input.Apply,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create reconciliation run: %w", err)
+	}
+
+	run := &Run{
+		ID:    dbRun.ID,
+		From:  input.From,
+		To:    input.To,
+		Apply: input.Apply,
+	}
+
+	err = s.reconcile(ctx, run)
+
+	status := "completed"
+	var runError sql.NullString
+	if err != nil {
+		status = "failed"
+		runError = sql.NullString{String: err.Error(), Valid: true}
+	}
+
+	if finishErr := s.Queries.FinishReconciliationRun(context.WithoutCancel(ctx), db.FinishReconciliationRunParams{
+		Status:     status,
+		Checked:    int32(run.Checked),
+		Mismatches: int32(len(run.Mismatches)),
+		Errors:     int32(run.Errors),
+		Error:      runError,
+		ID:         run.ID,
+	}); finishErr != nil {
+		log.Printf("reconciliation run %d: finish: %v", run.ID, finishErr)
+	}
+
+	return run, err
+}
+
+func (s *svc) reconcile(ctx context.Context, run *Run) error {
+	seen := make(map[orderKey]bool)
+	var keys []orderKey
+
+	for offset := 0; ; offset += pageSize {
+		page, err := s.Queries.GetAllPayments(ctx, db.GetAllPaymentsParams{
+			CreatedFrom: sql.NullTime{Time: run.From, Valid: true},
+			CreatedTo:   sql.NullTime{Time: run.To, Valid: true},
+			Offset:      int32(offset),
+			Limit:       pageSize,
+		})
+		if err != nil {
+			return fmt.Errorf("get payments: %w", err)
+		}
+
+		for _, dbPayment := range page {
+			payment := toPayment(dbPayment)
+			run.Checked++
+
+			if err := s.checkPayment(ctx, run, payment); err != nil {
+				run.Errors++
+				log.Printf("reconciliation run %d: payment %s: %v", run.ID, payment.ExternalID, err)
+			}
+
+			key := orderKey{orderID: payment.OrderID, gatewayName: payment.GatewayName}
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+
+		if len(page) < pageSize {
+			break
+		}
+	}
+
+	for _, key := range keys {
+		if err := s.checkOrder(ctx, run, key); err != nil {
+			run.Errors++
+			log.Printf("reconciliation run %d: order %d: %v", run.ID, key.orderID, err)
+		}
+	}
+
+	return nil
+}
+
+// checkPayment compares a payment with the gateway request it was made for.
+func (s *svc) checkPayment(ctx context.Context, run *Run, payment *payments.Payment) error {
+	gateway, err := s.gateways.Get(payment.GatewayName)
+	if err != nil {
+		return err
+	}
+
+	mismatch := &Mismatch{
+		OrderID:     payment.OrderID,
+		PaymentID:   payment.ID,
+		GatewayName: payment.GatewayName,
+		GatewayID:   payment.ExternalID,
+		LocalStatus: payment.Status,
+		LocalAmount: payment.Amount,
+	}
+
+	remote, err := gateway.GetPaymentStatus(ctx, payment.ExternalID)
+	if errors.Is(err, paymentgateway.ErrPaymentRequestNotFound) {
+		mismatch.Kind = MismatchMissingAtGateway
+		return s.record(ctx, run, mismatch)
+	}
+	if err != nil {
+		return fmt.Errorf("get gateway status: %w", err)
+	}
+
+	mismatch.GatewayStatus = remote.Status
+	mismatch.GatewayAmount = remote.Amount
+
+	if remote.Amount != payment.Amount {
+		amount := *mismatch
+		amount.Kind = MismatchAmount
+		if err := s.record(ctx, run, &amount); err != nil {
+			return err
+		}
+	}
+
+	if sameStatus(payment.Status, remote.Status) {
+		return nil
+	}
+
+	mismatch.Kind = MismatchStatus
+	if remote.Status == paymentgateway.StatusPaid && payment.Status == "pending" {
+		mismatch.Kind = MismatchPaidAtGateway
+	}
+
+	if run.Apply {
+		s.apply(ctx, payment, remote, mismatch)
+	}
+
+	return s.record(ctx, run, mismatch)
+}
+
+// checkOrder reports gateway requests made for the order that have no
+// payment record, e.g. ones created by a checkout that crashed.
+func (s *svc) checkOrder(ctx context.Context, run *Run, key orderKey) error {
+	gateway, err := s.gateways.Get(key.gatewayName)
+	if err != nil {
+		return err
+	}
+
+	remotes, err := gateway.ListPaymentRequests(ctx, fmt.Sprint(key.orderID))
+	if err != nil {
+		return fmt.Errorf("list gateway requests: %w", err)
+	}
+
+	local, err := s.Queries.GetPaymentsByOrderID(ctx, db.GetPaymentsByOrderIDParams{
+		OrderID: int32(key.orderID),
+		Offset:  0,
+		Limit:   pageSize,
+	})
+	if err != nil {
+		return fmt.Errorf("get payments by order id: %w", err)
+	}
+
+	known := make(map[string]bool, len(local))
+	for _, payment := range local {
+		known[payment.ExternalID] = true
+	}
+
+	for _, remote := range remotes {
+		if known[remote.GatewayID] {
+			continue
+		}
+
+		if err := s.record(ctx, run, &Mismatch{
+			Kind:          MismatchUnknownTransaction,
+			OrderID:       key.orderID,
+			GatewayName:   key.gatewayName,
+			GatewayID:     remote.GatewayID,
+			GatewayStatus: remote.Status,
+			GatewayAmount: remote.Amount,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply moves a pending payment to the state the gateway reports. Payments
+// that already left pending are only reported, since UpdatePaymentStatus
+// has no transition out of a final state, and so are captures of an amount
+// other than the payment's.
+func (s *svc) apply(ctx context.Context, payment *payments.Payment, remote *paymentgateway.PaymentStatus, mismatch *Mismatch) {
+	if payment.Status != "pending" {
+		return
+	}
+
+	var status string
+	switch remote.Status {
+	case paymentgateway.StatusPaid:
+		// A capture of the wrong amount is left for an admin.
+		if remote.Amount != payment.Amount {
+			mismatch.ApplyError = fmt.Sprintf("%v: captured %d, expected %d", payments.ErrAmountMismatch, remote.Amount, payment.Amount)
+			return
+		}
+		status = "paid"
+	case paymentgateway.StatusFailed:
+		status = "failed"
+	case paymentgateway.StatusExpired, paymentgateway.StatusCanceled:
+		status = "expired"
+	default:
+		return
+	}
+
+	if err := s.payments.UpdatePaymentStatus(ctx, payments.UpdatePaymentStatusInput{
+		OrderID:              payment.OrderID,
+		PaymentRequestID:     payment.ExternalID,
+		PaymentChannel:       payment.PaymentChannel,
+		GatewayTransactionID: remote.TransactionID,
+		Status:               status,
+		Source:               orders.SourceSystem,
+	}); err != nil {
+		mismatch.ApplyError = err.Error()
+		return
+	}
+
+	mismatch.Applied = true
+}
+
+func (s *svc) record(ctx context.Context, run *Run, mismatch *Mismatch) error {
+	if err := s.Queries.CreateReconciliationMismatch(ctx, db.CreateReconciliationMismatchParams{
+		RunID:         run.ID,
+		Kind:          mismatch.Kind,
+		OrderID:       nullInt(mismatch.OrderID),
+		PaymentID:     nullInt(mismatch.PaymentID),
+		GatewayName:   mismatch.GatewayName,
+		GatewayID:     mismatch.GatewayID,
+		LocalStatus:   nullString(mismatch.LocalStatus),
+		GatewayStatus: nullString(mismatch.GatewayStatus),
+		LocalAmount:   nullInt(mismatch.LocalAmount),
+		GatewayAmount: nullInt(mismatch.GatewayAmount),
+		Applied:       mismatch.Applied,
+		ApplyError:    nullString(mismatch.ApplyError),
+	}); err != nil {
+		return fmt.Errorf("record mismatch: %w", err)
+	}
+
+	run.Mismatches = append(run.Mismatches, mismatch)
+	return nil
+}
+
+// sameStatus reports whether a local payment status agrees with the
+// gateway's. Settlement, refunds and captures of superseded attempts are
+// tracked by us alone, the gateway still reports the request as paid, and a request we canceled or superseded
+// may be reported by the gateway as expired.
+func sameStatus(local, remote string) bool {
+	switch local {
+	case "settled", "partially_refunded", "refunded", "captured_superseded":
+		return remote == paymentgateway.StatusPaid
+	case "canceled", "superseded":
+		return remote == paymentgateway.StatusCanceled || remote == paymentgateway.StatusExpired
+	default:
+		return local == remote
+	}
+}
+
+func toPayment(payment db.Payment) *payments.Payment {
+	return &payments.Payment{
+		ID:             int(payment.ID),
+		OrderID:        int(payment.OrderID),
+		ExternalID:     payment.ExternalID,
+		GatewayName:    payment.GatewayName,
+		Amount:         int(payment.Amount),
+		PaymentChannel: payment.PaymentChannel.String,
+		Status:         payment.Status,
+	}
+}
+
+func nullInt(n int) sql.NullInt32 {
+	return sql.NullInt32{Int32: int32(n), Valid: n != 0}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
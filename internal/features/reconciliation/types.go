@@ -0,0 +1,59 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrInvalidRange = errors.New("reconciliation range must end after it starts")
+
+// Kinds of mismatch between the payments table and the gateway.
+const (
+	// MismatchPaidAtGateway is a payment the customer completed whose
+	// callback never reached us.
+	MismatchPaidAtGateway = "paid_at_gateway"
+	MismatchStatus        = "status"
+	MismatchAmount        = "amount"
+	// MismatchMissingAtGateway is a payment the gateway has no record of.
+	MismatchMissingAtGateway = "missing_at_gateway"
+	// MismatchUnknownTransaction is a gateway request for one of our orders
+	// that has no payment record.
+	MismatchUnknownTransaction = "unknown_transaction"
+)
+
+type Mismatch struct {
+	Kind          string `json:"kind"`
+	OrderID       int    `json:"order_id"`
+	PaymentID     int    `json:"payment_id,omitempty"`
+	GatewayName   string `json:"gateway_name"`
+	GatewayID     string `json:"gateway_id"`
+	LocalStatus   string `json:"local_status,omitempty"`
+	GatewayStatus string `json:"gateway_status,omitempty"`
+	LocalAmount   int    `json:"local_amount,omitempty"`
+	GatewayAmount int    `json:"gateway_amount,omitempty"`
+	Applied       bool   `json:"applied"`
+	ApplyError    string `json:"apply_error,omitempty"`
+}
+
+type Run struct {
+	ID         int64       `json:"id"`
+	From       time.Time   `json:"from"`
+	To         time.Time   `json:"to"`
+	Apply      bool        `json:"apply"`
+	Checked    int         `json:"checked"`
+	Errors     int         `json:"errors"`
+	Mismatches []*Mismatch `json:"mismatches"`
+}
+
+type RunInput struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Apply moves pending payments to the state the gateway reports, the
+	// same way the payment callback would have.
+	Apply bool `json:"apply"`
+}
+
+type ReconciliationService interface {
+	Reconcile(ctx context.Context, input RunInput) (*Run, error)
+}
@@ -0,0 +1,56 @@
+package reconciliation
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Worker reconciles the payments created in the trailing window on every
+// tick, e.g. the last day's payments once a day.
+type Worker struct {
+	svc      ReconciliationService
+	interval time.Duration
+	window   time.Duration
+	apply    bool
+}
+
+func NewWorker(service ReconciliationService, interval, window time.Duration, apply bool) *Worker {
+	return &Worker{
+		svc:      service,
+		interval: interval,
+		window:   window,
+		apply:    apply,
+	}
+}
+
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.reconcile(ctx)
+		}
+	}
+}
+
+func (w *Worker) reconcile(ctx context.Context) {
+	now := time.Now()
+
+	run, err := w.svc.Reconcile(ctx, RunInput{
+		From:  now.Add(-w.window),
+		To:    now,
+		Apply: w.apply,
+	})
+	if err != nil {
+		log.Printf("reconciliation worker: %v", err)
+		return
+	}
+
+	log.Printf("reconciliation run %d: checked %d payments, %d mismatches, %d errors",
+		run.ID, run.Checked, len(run.Mismatches), run.Errors)
+}
@@ -0,0 +1,394 @@
+package refunds
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/outbox"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
+)
+
+// svc records a refund before asking the gateway for it. A pending refund
+// counts against the captured amount from the moment it is stored, so
+// concurrent requests cannot refund more than was paid even while the
+// gateway call is in flight.
+type svc struct {
+	*db.Queries
+	connPool *sql.DB
+	gateways *paymentgateway.Registry
+}
+
+func NewService(connPool *sql.DB, gateways *paymentgateway.Registry) *svc {
+	return &svc{
+		Queries:  db.New(connPool),
+		connPool: connPool,
+		gateways: gateways,
+	}
+}
+
+func (s *svc) RequestRefund(ctx context.Context, input CreateRefundInput) (*Refund, error) {
+	if input.Amount < 0 {
+		return nil, ErrInvalidRefundAmount
+	}
+	if input.Reason == "" {
+		input.Reason = paymentgateway.RefundReasonRequestedByCustomer
+	}
+	if !validReason(input.Reason) {
+		return nil, ErrInvalidRefundReason
+	}
+
+	dbRefund, payment, err := s.reserve(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.send(ctx, dbRefund, payment.ExternalID)
+}
+
+// send asks the gateway for a stored refund. The refund's reference is the
+// idempotency key of the request, so sending a refund again never refunds
+// twice.
+func (s *svc) send(ctx context.Context, dbRefund db.Refund, gatewayID string) (*Refund, error) {
+	gateway, err := s.gateways.Get(dbRefund.GatewayName)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := gateway.Refund(ctx, paymentgateway.RefundInput{
+		GatewayID:   gatewayID,
+		ReferenceID: referenceID(int(dbRefund.ID)),
+		Amount:      int(dbRefund.Amount),
+		Reason:      dbRefund.Reason,
+	})
+	if err != nil {
+		if !refused(err) {
+			// The gateway may have received the request, so the refund stays
+			// pending and its amount reserved. The refund callback settles
+			// it, or the Worker sends it again under the same key.
+			log.Printf("refund %d: request refund: %v", dbRefund.ID, err)
+			return s.getRefund(ctx, dbRefund.ID)
+		}
+
+		// The amount is released so the refund can be requested again.
+		if completeErr := s.CompleteRefund(context.WithoutCancel(ctx), CompleteRefundInput{
+			RefundID:    int(dbRefund.ID),
+			Status:      StatusFailed,
+			FailureCode: "REQUEST_REJECTED",
+			Source:      orders.SourceSystem,
+		}); completeErr != nil {
+			log.Printf("refund %d: release after gateway error: %v", dbRefund.ID, completeErr)
+		}
+		return nil, fmt.Errorf("request refund: %w", err)
+	}
+
+	if err := s.Queries.SetRefundGatewayID(ctx, db.SetRefundGatewayIDParams{
+		GatewayRefundID: sql.NullString{String: result.ID, Valid: true},
+		ID:              dbRefund.ID,
+	}); err != nil {
+		return nil, fmt.Errorf("store gateway refund id: %w", err)
+	}
+
+	if result.Status != paymentgateway.RefundPending {
+		if err := s.CompleteRefund(ctx, CompleteRefundInput{
+			RefundID:        int(dbRefund.ID),
+			GatewayRefundID: result.ID,
+			Status:          result.Status,
+			Source:          orders.SourceSystem,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.getRefund(ctx, dbRefund.ID)
+}
+
+// reserve stores a pending refund after checking it against what is left of
+// the order's captured payment, or of the payment input names.
+func (s *svc) reserve(ctx context.Context, input CreateRefundInput) (db.Refund, db.Payment, error) {
+	tx, err := s.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return db.Refund{}, db.Payment{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	qtx := s.Queries.WithTx(tx)
+
+	var payment db.Payment
+	if input.PaymentID > 0 {
+		payment, err = qtx.LockPaymentByID(ctx, int32(input.PaymentID))
+		if err == nil && (int(payment.OrderID) != input.OrderID || !refundable(payment.Status)) {
+			err = sql.ErrNoRows
+		}
+	} else {
+		payment, err = qtx.LockRefundablePayment(ctx, int32(input.OrderID))
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.Refund{}, db.Payment{}, ErrNotRefundable
+		}
+		return db.Refund{}, db.Payment{}, fmt.Errorf("lock payment: %w", err)
+	}
+
+	reserved, err := qtx.GetReservedRefundAmount(ctx, payment.ID)
+	if err != nil {
+		return db.Refund{}, db.Payment{}, fmt.Errorf("get reserved refund amount: %w", err)
+	}
+
+	remaining := int(payment.Amount - reserved)
+	amount := input.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return db.Refund{}, db.Payment{}, fmt.Errorf("%w: %d requested, %d left", ErrExceedsCaptured, amount, remaining)
+	}
+
+	refund, err := qtx.CreateRefund(ctx, db.CreateRefundParams{
+		OrderID:     int32(input.OrderID),
+		PaymentID:   payment.ID,
+		GatewayName: payment.GatewayName,
+		Amount:      int32(amount),
+		Reason:      input.Reason,
+		RequestedBy: sql.NullInt32{
+			Int32: int32(input.Actor.UserID),
+			Valid: input.Actor.UserID > 0,
+		},
+	})
+	if err != nil {
+		return db.Refund{}, db.Payment{}, fmt.Errorf("create refund: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return db.Refund{}, db.Payment{}, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return refund, payment, nil
+}
+
+// CompleteRefund applies the gateway's outcome. A succeeded refund moves the
+// payment and order to partially_refunded, or refunded once the succeeded
+// refunds cover the whole payment. Outcomes for a refund that is no longer
+// pending are ignored, so redelivered callbacks are harmless.
+func (s *svc) CompleteRefund(ctx context.Context, input CompleteRefundInput) error {
+	if input.Status != StatusSucceeded && input.Status != StatusFailed {
+		return ErrInvalidRefundOutcome
+	}
+
+	tx, err := s.connPool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	qtx := s.Queries.WithTx(tx)
+
+	var refund db.Refund
+	if input.RefundID > 0 {
+		refund, err = qtx.GetRefundByID(ctx, int32(input.RefundID))
+	} else {
+		refund, err = qtx.GetRefundByGatewayRefundID(ctx, sql.NullString{String: input.GatewayRefundID, Valid: true})
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRefundNotFound
+		}
+		return fmt.Errorf("get refund: %w", err)
+	}
+
+	completed, err := qtx.CompleteRefund(ctx, db.CompleteRefundParams{
+		Status:          input.Status,
+		GatewayRefundID: sql.NullString{String: input.GatewayRefundID, Valid: input.GatewayRefundID != ""},
+		FailureCode:     sql.NullString{String: input.FailureCode, Valid: input.FailureCode != ""},
+		ID:              refund.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("complete refund: %w", err)
+	}
+	if completed == 0 {
+		return nil
+	}
+
+	eventType := outbox.EventRefundFailed
+	if input.Status == StatusSucceeded {
+		eventType = outbox.EventRefundSucceeded
+
+		if err := s.applyToPayment(ctx, qtx, refund, input.Source); err != nil {
+			return err
+		}
+	}
+
+	if err := outbox.Enqueue(ctx, qtx, outbox.AggregateOrder, int(refund.OrderID), eventType, RefundEvent{
+		RefundID:    int(refund.ID),
+		OrderID:     int(refund.OrderID),
+		Amount:      int(refund.Amount),
+		Status:      input.Status,
+		FailureCode: input.FailureCode,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// applyToPayment counts a succeeded refund against the payment it was made
+// from, which need not be the order's latest captured payment. Refunding a
+// capture of a superseded attempt returns money that never paid for the
+// order, so the order is left as it is.
+func (s *svc) applyToPayment(ctx context.Context, qtx *db.Queries, refund db.Refund, source string) error {
+	payment, err := qtx.LockPaymentByID(ctx, refund.PaymentID)
+	if err != nil {
+		return fmt.Errorf("lock payment: %w", err)
+	}
+	supersededCapture := payment.Status == "captured_superseded"
+
+	refunded, err := qtx.GetRefundedAmount(ctx, payment.ID)
+	if err != nil {
+		return fmt.Errorf("get refunded amount: %w", err)
+	}
+
+	status := "partially_refunded"
+	if refunded >= payment.Amount {
+		status = "refunded"
+	}
+
+	if _, err := qtx.MarkPaymentRefunded(ctx, db.MarkPaymentRefundedParams{
+		Status: status,
+		ID:     payment.ID,
+	}); err != nil {
+		return fmt.Errorf("mark payment refunded: %w", err)
+	}
+	if supersededCapture {
+		return nil
+	}
+
+	current, err := qtx.GetOrderById(ctx, refund.OrderID)
+	if err != nil {
+		return fmt.Errorf("get order: %w", err)
+	}
+	if current.PaymentStatus == status {
+		return nil
+	}
+
+	trigger := orders.TriggerPartialRefund
+	if status == "refunded" {
+		trigger = orders.TriggerRefund
+	}
+
+	_, err = orders.ApplyTransition(
+		ctx,
+		qtx,
+		current,
+		trigger,
+		orders.Actor{Source: source},
+		fmt.Sprintf("refund %d of %d", refund.ID, refund.Amount),
+		func() (int64, error) {
+			return qtx.MarkOrderRefunded(ctx, db.MarkOrderRefundedParams{
+				PaymentStatus: status,
+				ID:            refund.OrderID,
+			})
+		},
+	)
+	return err
+}
+
+func (s *svc) GetRefundsByOrderID(ctx context.Context, orderID int) ([]*Refund, error) {
+	dbRefunds, err := s.Queries.GetRefundsByOrderID(ctx, int32(orderID))
+	if err != nil {
+		return nil, fmt.Errorf("get refunds by order id: %w", err)
+	}
+
+	refunds := make([]*Refund, 0, len(dbRefunds))
+	for _, dbRefund := range dbRefunds {
+		refunds = append(refunds, toRefund(dbRefund))
+	}
+
+	return refunds, nil
+}
+
+func (s *svc) getRefund(ctx context.Context, id int32) (*Refund, error) {
+	refund, err := s.Queries.GetRefundByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get refund: %w", err)
+	}
+	return toRefund(refund), nil
+}
+
+// referenceID is the ID a refund is known by at the gateway. It is also the
+// idempotency key of the gateway request.
+func referenceID(refundID int) string {
+	return fmt.Sprintf("refund-%d", refundID)
+}
+
+// ParseReferenceID returns the refund ID encoded in a gateway reference, or
+// false for references this service did not create.
+func ParseReferenceID(reference string) (int, bool) {
+	var id int
+	if _, err := fmt.Sscanf(reference, "refund-%d", &id); err != nil || referenceID(id) != reference {
+		return 0, false
+	}
+	return id, true
+}
+
+// refundable reports whether a payment holds captured money that can be
+// refunded.
+func refundable(status string) bool {
+	switch status {
+	case "paid", "settled", "partially_refunded", "captured_superseded":
+		return true
+	default:
+		return false
+	}
+}
+
+// refused reports whether the gateway definitely did not accept a refund.
+func refused(err error) bool {
+	return errors.Is(err, paymentgateway.ErrRefundRejected) ||
+		errors.Is(err, paymentgateway.ErrPaymentRequestNotFound)
+}
+
+func validReason(reason string) bool {
+	switch reason {
+	case paymentgateway.RefundReasonRequestedByCustomer,
+		paymentgateway.RefundReasonCancellation,
+		paymentgateway.RefundReasonDuplicate,
+		paymentgateway.RefundReasonFraudulent,
+		paymentgateway.RefundReasonOthers:
+		return true
+	default:
+		return false
+	}
+}
+
+func toRefund(refund db.Refund) *Refund {
+	result := &Refund{
+		ID:              int(refund.ID),
+		OrderID:         int(refund.OrderID),
+		PaymentID:       int(refund.PaymentID),
+		GatewayName:     refund.GatewayName,
+		GatewayRefundID: refund.GatewayRefundID.String,
+		Amount:          int(refund.Amount),
+		Reason:          refund.Reason,
+		Status:          refund.Status,
+		FailureCode:     refund.FailureCode.String,
+		CreatedAt:       refund.CreatedAt,
+		UpdatedAt:       refund.UpdatedAt,
+	}
+	if refund.RequestedBy.Valid {
+		requestedBy := int(refund.RequestedBy.Int32)
+		result.RequestedBy = &requestedBy
+	}
+	if refund.CompletedAt.Valid {
+		result.CompletedAt = &refund.CompletedAt.Time
+	}
+
+	return result
+}
@@ -0,0 +1,83 @@
+package refunds
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+)
+
+var (
+	ErrRefundNotFound       = errors.New("refund not found")
+	ErrNotRefundable        = errors.New("order has no captured payment to refund")
+	ErrExceedsCaptured      = errors.New("refund exceeds the amount left to refund")
+	ErrInvalidRefundAmount  = errors.New("refund amount must be positive")
+	ErrInvalidRefundReason  = errors.New("invalid refund reason")
+	ErrInvalidRefundOutcome = errors.New("refund outcome must be succeeded or failed")
+)
+
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+type Refund struct {
+	ID              int        `json:"id"`
+	OrderID         int        `json:"order_id"`
+	PaymentID       int        `json:"payment_id"`
+	GatewayName     string     `json:"gateway_name"`
+	GatewayRefundID string     `json:"gateway_refund_id,omitempty"`
+	Amount          int        `json:"amount"`
+	Reason          string     `json:"reason"`
+	Status          string     `json:"status"`
+	FailureCode     string     `json:"failure_code,omitempty"`
+	RequestedBy     *int       `json:"requested_by,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+}
+
+type CreateRefundRequest struct {
+	// Amount is refunded from the order's captured payment; zero refunds
+	// whatever is left.
+	Amount int    `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+type CreateRefundInput struct {
+	OrderID int
+	// PaymentID refunds that payment of the order instead of its latest
+	// captured one, e.g. a capture of a superseded attempt.
+	PaymentID int
+	Amount    int
+	Reason    string
+	Actor     orders.Actor
+}
+
+// CompleteRefundInput is the outcome of a refund as reported by the gateway.
+// The refund is identified by RefundID when known, otherwise by the
+// gateway's refund ID.
+type CompleteRefundInput struct {
+	RefundID        int
+	GatewayRefundID string
+	Status          string
+	FailureCode     string
+	Source          string
+}
+
+// RefundEvent is the payload of refund events published through the outbox.
+type RefundEvent struct {
+	RefundID    int    `json:"refund_id"`
+	OrderID     int    `json:"order_id"`
+	Amount      int    `json:"amount"`
+	Status      string `json:"status"`
+	FailureCode string `json:"failure_code,omitempty"`
+}
+
+type RefundService interface {
+	RequestRefund(ctx context.Context, input CreateRefundInput) (*Refund, error)
+	GetRefundsByOrderID(ctx context.Context, orderID int) ([]*Refund, error)
+	CompleteRefund(ctx context.Context, input CompleteRefundInput) error
+}
@@ -0,0 +1,146 @@
+package refunds
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/platform/paymentgateway"
+)
+
+const workerBatchSize = 50
+
+// workerMetrics is published under /debug/vars as "refund_worker".
+var workerMetrics = expvar.NewMap("refund_worker")
+
+// Worker makes sure money owed back is refunded without anyone asking twice.
+// It requests the refund of orders waiting in refund_pending without one,
+// e.g. orders canceled after payment or paid after cancellation, and of
+// payments captured on superseded attempts, and sends again the refunds the
+// gateway never confirmed, because the process stopped before sending them
+// or the outcome of the request was unknown.
+type Worker struct {
+	svc         *svc
+	interval    time.Duration
+	resendAfter time.Duration
+	maxAttempts int
+}
+
+func NewWorker(service *svc, interval, resendAfter time.Duration, maxAttempts int) *Worker {
+	return &Worker{
+		svc:         service,
+		interval:    interval,
+		resendAfter: resendAfter,
+		maxAttempts: maxAttempts,
+	}
+}
+
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.sweep(ctx)
+		}
+	}
+}
+
+func (w *Worker) sweep(ctx context.Context) {
+	workerMetrics.Add("sweeps", 1)
+
+	orderIDs, err := w.svc.Queries.GetOrdersAwaitingRefund(ctx, db.GetOrdersAwaitingRefundParams{
+		MaxAttempts: int32(w.maxAttempts),
+		Limit:       workerBatchSize,
+	})
+	if err != nil {
+		workerMetrics.Add("errors", 1)
+		log.Printf("refund worker: %v", err)
+		return
+	}
+
+	for _, orderID := range orderIDs {
+		refund, err := w.svc.RequestRefund(ctx, CreateRefundInput{
+			OrderID: int(orderID),
+			Reason:  paymentgateway.RefundReasonCancellation,
+			Actor:   orders.Actor{Source: orders.SourceSystem},
+		})
+		if err != nil {
+			// A refund requested meanwhile already reserved the amount.
+			if errors.Is(err, ErrExceedsCaptured) {
+				continue
+			}
+			workerMetrics.Add("errors", 1)
+			log.Printf("refund worker: order %d: %v", orderID, err)
+			continue
+		}
+		workerMetrics.Add("requested", 1)
+		log.Printf("refund worker: refund %d requested for order %d", refund.ID, orderID)
+	}
+
+	captures, err := w.svc.Queries.GetSupersededCaptures(ctx, db.GetSupersededCapturesParams{
+		MaxAttempts: int32(w.maxAttempts),
+		Limit:       workerBatchSize,
+	})
+	if err != nil {
+		workerMetrics.Add("errors", 1)
+		log.Printf("refund worker: %v", err)
+		return
+	}
+
+	for _, payment := range captures {
+		refund, err := w.svc.RequestRefund(ctx, CreateRefundInput{
+			OrderID:   int(payment.OrderID),
+			PaymentID: int(payment.ID),
+			Reason:    paymentgateway.RefundReasonDuplicate,
+			Actor:     orders.Actor{Source: orders.SourceSystem},
+		})
+		if err != nil {
+			if errors.Is(err, ErrExceedsCaptured) {
+				continue
+			}
+			workerMetrics.Add("errors", 1)
+			log.Printf("refund worker: payment %d: %v", payment.ID, err)
+			continue
+		}
+		workerMetrics.Add("requested", 1)
+		log.Printf("refund worker: refund %d requested for superseded payment %d", refund.ID, payment.ID)
+	}
+
+	unsent, err := w.svc.Queries.GetUnsentRefunds(ctx, db.GetUnsentRefundsParams{
+		StaleSeconds: int32(w.resendAfter.Seconds()),
+		Limit:        workerBatchSize,
+	})
+	if err != nil {
+		workerMetrics.Add("errors", 1)
+		log.Printf("refund worker: %v", err)
+		return
+	}
+
+	for _, refund := range unsent {
+		if err := w.resend(ctx, refund); err != nil {
+			workerMetrics.Add("errors", 1)
+			log.Printf("refund worker: refund %d: %v", refund.ID, err)
+			continue
+		}
+		workerMetrics.Add("resent", 1)
+	}
+}
+
+func (w *Worker) resend(ctx context.Context, refund db.Refund) error {
+	payment, err := w.svc.Queries.GetPaymentByID(ctx, refund.PaymentID)
+	if err != nil {
+		return fmt.Errorf("get payment: %w", err)
+	}
+
+	_, err = w.svc.send(ctx, refund, payment.ExternalID)
+	return err
+}
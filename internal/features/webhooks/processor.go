@@ -0,0 +1,149 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+)
+
+const (
+	processBatchSize = 100
+	processLease     = time.Minute
+	maxRetryDelay    = 5 * time.Minute
+)
+
+// Processor applies stored events with the handler registered for their
+// provider. It polls on an interval and can be woken early with Notify, so a
+// callback is normally applied right after it is stored.
+type Processor struct {
+	*db.Queries
+	handlers    map[string]Handler
+	interval    time.Duration
+	maxAttempts int
+	// unmatchedWindow is how long after it was received an event failing
+	// with ErrUnmatched keeps being retried.
+	unmatchedWindow time.Duration
+	wake            chan struct{}
+}
+
+func NewProcessor(connPool *sql.DB, interval time.Duration, maxAttempts int, unmatchedWindow time.Duration) *Processor {
+	return &Processor{
+		Queries:         db.New(connPool),
+		handlers:        make(map[string]Handler),
+		interval:        interval,
+		maxAttempts:     maxAttempts,
+		unmatchedWindow: unmatchedWindow,
+		wake:            make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler for a provider's events. It must be called
+// before Run.
+func (p *Processor) Register(provider string, handler Handler) {
+	p.handlers[provider] = handler
+}
+
+// Notify wakes the processor without waiting for the next tick.
+func (p *Processor) Notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Processor) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+		p.drain(ctx)
+	}
+}
+
+func (p *Processor) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := p.Queries.ClaimWebhookEvents(ctx, db.ClaimWebhookEventsParams{
+			LeaseSeconds: int32(processLease.Seconds()),
+			Limit:        processBatchSize,
+		})
+		if err != nil {
+			log.Printf("webhook processor: claim events: %v", err)
+			return
+		}
+		if len(events) == 0 {
+			return
+		}
+
+		sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+		for _, event := range events {
+			p.process(ctx, event)
+		}
+	}
+}
+
+func (p *Processor) process(ctx context.Context, event db.WebhookEvent) {
+	err := p.handle(ctx, toEvent(event))
+	if err == nil {
+		if err := p.Queries.MarkWebhookEventProcessed(ctx, event.ID); err != nil {
+			log.Printf("webhook processor: mark event %d processed: %v", event.ID, err)
+		}
+		return
+	}
+
+	maxAttempts := p.maxAttempts
+	switch {
+	case errors.Is(err, ErrRejected):
+		maxAttempts = 0
+	case errors.Is(err, ErrUnmatched) && time.Since(event.ReceivedAt) < p.unmatchedWindow:
+		maxAttempts = max(maxAttempts, int(event.Attempts)+1)
+	}
+
+	if int(event.Attempts) >= maxAttempts {
+		log.Printf("webhook processor: giving up on event %d (%s) after %d attempts: %v", event.ID, event.EventType, event.Attempts, err)
+	} else {
+		log.Printf("webhook processor: event %d (%s): %v", event.ID, event.EventType, err)
+	}
+
+	if err := p.Queries.RecordWebhookEventFailure(ctx, db.RecordWebhookEventFailureParams{
+		MaxAttempts:  int32(maxAttempts),
+		LastError:    sql.NullString{String: err.Error(), Valid: true},
+		RetrySeconds: int32(retryDelay(int(event.Attempts)).Seconds()),
+		ID:           event.ID,
+	}); err != nil {
+		log.Printf("webhook processor: record failure for event %d: %v", event.ID, err)
+	}
+}
+
+func (p *Processor) handle(ctx context.Context, event Event) error {
+	handler, ok := p.handlers[event.Provider]
+	if !ok {
+		return fmt.Errorf("no handler for provider %q", event.Provider)
+	}
+	return handler.Handle(ctx, event)
+}
+
+// retryDelay backs off exponentially from one second up to maxRetryDelay.
+func retryDelay(attempt int) time.Duration {
+	if attempt > 16 {
+		return maxRetryDelay
+	}
+
+	delay := time.Second << attempt
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
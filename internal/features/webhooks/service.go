@@ -0,0 +1,100 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	db "github.com/duniandewon/madkunyah-transactions-service/internal/db/sqlc"
+)
+
+// redactedHeaders are credentials that must not be stored with the event.
+var redactedHeaders = []string{"X-Callback-Token", "Authorization"}
+
+type svc struct {
+	*db.Queries
+}
+
+func NewService(connPool *sql.DB) *svc {
+	return &svc{
+		Queries: db.New(connPool),
+	}
+}
+
+func (s *svc) Record(ctx context.Context, event ReceivedEvent) (bool, error) {
+	headers := event.Headers.Clone()
+	for _, name := range redactedHeaders {
+		headers.Del(name)
+	}
+
+	encodedHeaders, err := json.Marshal(headers)
+	if err != nil {
+		return false, fmt.Errorf("marshal headers: %w", err)
+	}
+
+	stored, err := s.Queries.CreateWebhookEvent(ctx, db.CreateWebhookEventParams{
+		Provider:  event.Provider,
+		DedupeKey: event.DedupeKey,
+		EventType: event.EventType,
+		Reference: event.Reference,
+		Headers:   encodedHeaders,
+		Body:      string(event.Body),
+	})
+	if err != nil {
+		return false, fmt.Errorf("store webhook event: %w", err)
+	}
+
+	return stored == 0, nil
+}
+
+func (s *svc) GetFailedEvents(ctx context.Context, limit, offset int) ([]*Event, error) {
+	dbEvents, err := s.Queries.GetFailedWebhookEvents(ctx, db.GetFailedWebhookEventsParams{
+		Offset: int32(offset),
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get failed webhook events: %w", err)
+	}
+
+	events := make([]*Event, 0, len(dbEvents))
+	for _, dbEvent := range dbEvents {
+		event := toEvent(dbEvent)
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+func (s *svc) Replay(ctx context.Context, id int64) error {
+	replayed, err := s.Queries.ReplayWebhookEvent(ctx, id)
+	if err != nil {
+		return fmt.Errorf("replay webhook event: %w", err)
+	}
+	if replayed == 0 {
+		return ErrEventNotReplayable
+	}
+
+	return nil
+}
+
+func toEvent(event db.WebhookEvent) Event {
+	result := Event{
+		ID:         event.ID,
+		Provider:   event.Provider,
+		DedupeKey:  event.DedupeKey,
+		EventType:  event.EventType,
+		Reference:  event.Reference,
+		Headers:    event.Headers,
+		Body:       event.Body,
+		Status:     event.Status,
+		Attempts:   int(event.Attempts),
+		LastError:  event.LastError.String,
+		ReceivedAt: event.ReceivedAt,
+	}
+	if event.ProcessedAt.Valid {
+		result.ProcessedAt = &event.ProcessedAt.Time
+	}
+
+	return result
+}
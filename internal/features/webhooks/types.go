@@ -0,0 +1,66 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrEventNotReplayable = errors.New("webhook event not found or not failed")
+	// ErrRejected marks events a handler can never apply, such as malformed
+	// payloads or amounts that do not match. They fail without retries.
+	ErrRejected = errors.New("webhook event rejected")
+	// ErrUnmatched marks events for something this service has not recorded
+	// yet, such as a payment captured before its checkout stored the payment.
+	// They are retried with backoff for as long as that may take, however
+	// many attempts it needs.
+	ErrUnmatched = errors.New("webhook event does not match a record yet")
+)
+
+const ProviderXendit = "xendit"
+
+// ReceivedEvent is a callback as it arrived, before it is stored.
+type ReceivedEvent struct {
+	Provider string
+	// DedupeKey identifies the event at the provider; redeliveries share it.
+	DedupeKey string
+	EventType string
+	// Reference groups events that must be applied in order, e.g. all
+	// callbacks for one payment request.
+	Reference string
+	Headers   http.Header
+	Body      []byte
+}
+
+type Event struct {
+	ID          int64           `json:"id"`
+	Provider    string          `json:"provider"`
+	DedupeKey   string          `json:"dedupe_key"`
+	EventType   string          `json:"event_type"`
+	Reference   string          `json:"reference"`
+	Headers     json.RawMessage `json:"headers"`
+	Body        string          `json:"body"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+}
+
+// Handler applies a stored event. Events are delivered at least once, so
+// handlers must be safe to run again for an event they already applied.
+type Handler interface {
+	Handle(ctx context.Context, event Event) error
+}
+
+type WebhookService interface {
+	// Record stores a received event and reports whether it was a duplicate
+	// of one already stored.
+	Record(ctx context.Context, event ReceivedEvent) (duplicate bool, err error)
+	GetFailedEvents(ctx context.Context, limit, offset int) ([]*Event, error)
+	// Replay queues a failed event to be processed again.
+	Replay(ctx context.Context, id int64) error
+}
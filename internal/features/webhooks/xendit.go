@@ -0,0 +1,270 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/orders"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/payments"
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/refunds"
+)
+
+// Xendit payment-request v3 events. The v2 names Xendit still sends to older
+// integrations are accepted as aliases.
+const (
+	XenditPaymentCapture       = "payment.capture"
+	XenditPaymentSucceeded     = "payment.succeeded"
+	XenditPaymentAuthorization = "payment.authorization"
+	XenditPaymentFailure       = "payment.failure"
+	XenditPaymentFailed        = "payment.failed"
+	XenditPaymentSettlement    = "payment.settlement"
+	XenditPaymentRequestExpiry = "payment_request.expiry"
+	XenditRefundSucceeded      = "refund.succeeded"
+	XenditRefundFailed         = "refund.failed"
+)
+
+// xenditCurrency is the only currency payments are created in.
+const xenditCurrency = "IDR"
+
+// XenditWebhookPayload is the envelope of every Xendit callback. Data is
+// decoded once the event type is known.
+type XenditWebhookPayload struct {
+	Event      string          `json:"event"`
+	BusinessID string          `json:"business_id"`
+	Created    string          `json:"created"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// XenditPaymentData is the data of payment and payment request events.
+// PaymentID is sent by v3; ID carries the same value in v2 callbacks.
+type XenditPaymentData struct {
+	ID               string          `json:"id"`
+	PaymentID        string          `json:"payment_id"`
+	PaymentRequestID string          `json:"payment_request_id"`
+	ReferenceID      string          `json:"reference_id"`
+	Status           string          `json:"status"`
+	ChannelCode      string          `json:"channel_code"`
+	Currency         string          `json:"currency"`
+	Amount           float64         `json:"amount"`
+	RequestAmount    float64         `json:"request_amount"`
+	Captures         []XenditCapture `json:"captures"`
+	FailureCode      string          `json:"failure_code"`
+}
+
+type XenditCapture struct {
+	CaptureID     string  `json:"capture_id"`
+	CaptureAmount float64 `json:"capture_amount"`
+}
+
+// XenditRefundData is the data of refund events.
+type XenditRefundData struct {
+	ID               string  `json:"id"`
+	PaymentRequestID string  `json:"payment_request_id"`
+	ReferenceID      string  `json:"reference_id"`
+	Status           string  `json:"status"`
+	Currency         string  `json:"currency"`
+	Amount           float64 `json:"amount"`
+	Reason           string  `json:"reason"`
+	FailureCode      string  `json:"failure_code"`
+}
+
+func (d XenditPaymentData) paymentID() string {
+	if d.PaymentID != "" {
+		return d.PaymentID
+	}
+	return d.ID
+}
+
+// paidAmount is what was captured, falling back to the requested amount for
+// events that carry no captures.
+func (d XenditPaymentData) paidAmount() int {
+	if len(d.Captures) > 0 {
+		var captured float64
+		for _, capture := range d.Captures {
+			captured += capture.CaptureAmount
+		}
+		return int(captured)
+	}
+	if d.RequestAmount > 0 {
+		return int(d.RequestAmount)
+	}
+	return int(d.Amount)
+}
+
+// NewXenditEvent prepares a Xendit callback for storage. Xendit's webhook-id
+// header is the same on every redelivery; callbacks without it are keyed on
+// the event and object ID, and bodies that cannot be parsed on their hash so
+// they are still kept for inspection.
+func NewXenditEvent(header http.Header, body []byte) ReceivedEvent {
+	event := ReceivedEvent{
+		Provider: ProviderXendit,
+		Headers:  header,
+		Body:     body,
+	}
+
+	var payload XenditWebhookPayload
+	if err := json.Unmarshal(body, &payload); err == nil {
+		event.EventType = payload.Event
+
+		var data struct {
+			ID               string `json:"id"`
+			PaymentID        string `json:"payment_id"`
+			PaymentRequestID string `json:"payment_request_id"`
+		}
+		if err := json.Unmarshal(payload.Data, &data); err == nil {
+			event.Reference = data.PaymentRequestID
+
+			objectID := data.PaymentID
+			if objectID == "" {
+				objectID = data.ID
+			}
+			if objectID != "" && payload.Event != "" {
+				event.DedupeKey = payload.Event + ":" + objectID
+			}
+		}
+	}
+
+	if webhookID := header.Get("Webhook-Id"); webhookID != "" {
+		event.DedupeKey = webhookID
+	}
+	if event.DedupeKey == "" {
+		sum := sha256.Sum256(body)
+		event.DedupeKey = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	return event
+}
+
+// XenditHandler applies Xendit callbacks to the stored payments, refunds and
+// their orders.
+type XenditHandler struct {
+	payments payments.PaymentService
+	refunds  refunds.RefundService
+}
+
+func NewXenditHandler(paymentService payments.PaymentService, refundService refunds.RefundService) *XenditHandler {
+	return &XenditHandler{
+		payments: paymentService,
+		refunds:  refundService,
+	}
+}
+
+func (h *XenditHandler) Handle(ctx context.Context, event Event) error {
+	var payload XenditWebhookPayload
+	if err := json.Unmarshal([]byte(event.Body), &payload); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", ErrRejected, err)
+	}
+
+	switch payload.Event {
+	case XenditPaymentCapture, XenditPaymentSucceeded:
+		return h.handlePayment(ctx, payload, "paid")
+	case XenditPaymentFailure, XenditPaymentFailed:
+		return h.handlePayment(ctx, payload, "failed")
+	case XenditPaymentRequestExpiry:
+		return h.handlePayment(ctx, payload, "expired")
+	case XenditPaymentSettlement:
+		return h.handlePayment(ctx, payload, "settled")
+	case XenditPaymentAuthorization:
+		// Cards are captured automatically; the capture event follows.
+		return nil
+	case XenditRefundSucceeded, XenditRefundFailed:
+		return h.handleRefund(ctx, payload)
+	default:
+		return fmt.Errorf("%w: unknown event %q", ErrRejected, payload.Event)
+	}
+}
+
+func (h *XenditHandler) handlePayment(ctx context.Context, payload XenditWebhookPayload, status string) error {
+	var data XenditPaymentData
+	if err := json.Unmarshal(payload.Data, &data); err != nil {
+		return fmt.Errorf("%w: invalid %s data: %v", ErrRejected, payload.Event, err)
+	}
+	if data.PaymentRequestID == "" {
+		return fmt.Errorf("%w: missing payment_request_id", ErrRejected)
+	}
+
+	payment, err := h.payments.GetPaymentByGatewayID(ctx, data.PaymentRequestID)
+	if err != nil {
+		if errors.Is(err, payments.ErrPaymentNotFound) {
+			// The callback may have beaten the checkout that is about to
+			// store the payment, or that stalled and is left to the
+			// checkout worker.
+			return fmt.Errorf("%w: unknown payment request %s", ErrUnmatched, data.PaymentRequestID)
+		}
+		return err
+	}
+
+	if status == "paid" {
+		if err := verifyAmount(payment, data); err != nil {
+			return err
+		}
+	}
+
+	err = h.payments.UpdatePaymentStatus(ctx, payments.UpdatePaymentStatusInput{
+		OrderID:              payment.OrderID,
+		PaymentRequestID:     payment.ExternalID,
+		PaymentChannel:       data.ChannelCode,
+		GatewayTransactionID: data.paymentID(),
+		Status:               status,
+		Source:               orders.SourceWebhook,
+	})
+	if errors.Is(err, orders.ErrInvalidOrderStatus) {
+		// Left for an admin to resolve; retrying will not change the
+		// outcome.
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return err
+}
+
+// verifyAmount refuses to mark a payment paid when the gateway captured a
+// different amount or currency than the payment was created for.
+func verifyAmount(payment *payments.Payment, data XenditPaymentData) error {
+	if data.Currency != xenditCurrency {
+		return fmt.Errorf("%w: payment %s was made in %q, expected %s", ErrRejected, payment.ExternalID, data.Currency, xenditCurrency)
+	}
+	if paid := data.paidAmount(); paid != payment.Amount {
+		return fmt.Errorf("%w: payment %s captured %d, expected %d", ErrRejected, payment.ExternalID, paid, payment.Amount)
+	}
+	return nil
+}
+
+// handleRefund applies the outcome of a refund. Refunds requested through
+// the refunds service carry its reference; others are matched on Xendit's
+// refund ID.
+func (h *XenditHandler) handleRefund(ctx context.Context, payload XenditWebhookPayload) error {
+	var data XenditRefundData
+	if err := json.Unmarshal(payload.Data, &data); err != nil {
+		return fmt.Errorf("%w: invalid %s data: %v", ErrRejected, payload.Event, err)
+	}
+
+	var status string
+	switch data.Status {
+	case "SUCCEEDED":
+		status = refunds.StatusSucceeded
+	case "FAILED":
+		status = refunds.StatusFailed
+	default:
+		log.Printf("xendit webhook: ignoring refund %s in status %s", data.ID, data.Status)
+		return nil
+	}
+
+	refundID, _ := refunds.ParseReferenceID(data.ReferenceID)
+
+	err := h.refunds.CompleteRefund(ctx, refunds.CompleteRefundInput{
+		RefundID:        refundID,
+		GatewayRefundID: data.ID,
+		Status:          status,
+		FailureCode:     data.FailureCode,
+		Source:          orders.SourceWebhook,
+	})
+	if errors.Is(err, refunds.ErrRefundNotFound) {
+		return fmt.Errorf("%w: unknown refund %s", ErrRejected, data.ID)
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/payments"
+)
+
+func TestVerifyAmount(t *testing.T) {
+	payment := &payments.Payment{ExternalID: "pr-1", Amount: 50000}
+
+	tests := []struct {
+		name    string
+		data    XenditPaymentData
+		wantErr bool
+	}{
+		{"full capture", XenditPaymentData{Currency: "IDR", Captures: []XenditCapture{{CaptureAmount: 50000}}}, false},
+		{"captures summing to the amount", XenditPaymentData{Currency: "IDR", Captures: []XenditCapture{{CaptureAmount: 20000}, {CaptureAmount: 30000}}}, false},
+		{"captures summing to less", XenditPaymentData{Currency: "IDR", Captures: []XenditCapture{{CaptureAmount: 20000}, {CaptureAmount: 20000}}}, true},
+		{"captures summing to more", XenditPaymentData{Currency: "IDR", Captures: []XenditCapture{{CaptureAmount: 50000}, {CaptureAmount: 1}}}, true},
+		{"captures override the requested amount", XenditPaymentData{Currency: "IDR", RequestAmount: 50000, Captures: []XenditCapture{{CaptureAmount: 10000}}}, true},
+		{"requested amount without captures", XenditPaymentData{Currency: "IDR", RequestAmount: 50000, Amount: 1}, false},
+		{"v2 amount", XenditPaymentData{Currency: "IDR", Amount: 50000}, false},
+		{"v2 amount mismatch", XenditPaymentData{Currency: "IDR", Amount: 49999}, true},
+		{"other currency", XenditPaymentData{Currency: "USD", Amount: 50000}, true},
+		{"missing currency", XenditPaymentData{Amount: 50000}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyAmount(payment, tt.data)
+			if tt.wantErr {
+				if !errors.Is(err, ErrRejected) {
+					t.Fatalf("verifyAmount() error = %v, want ErrRejected", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyAmount() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestNewXenditEvent(t *testing.T) {
+	tests := []struct {
+		name          string
+		webhookID     string
+		body          string
+		wantType      string
+		wantReference string
+		// wantKey is the expected dedupe key; a key starting with "sha256:"
+		// only has its prefix checked.
+		wantKey string
+	}{
+		{
+			name:          "v3 payment",
+			body:          `{"event": "payment.capture", "data": {"payment_id": "py-1", "payment_request_id": "pr-1"}}`,
+			wantType:      "payment.capture",
+			wantReference: "pr-1",
+			wantKey:       "payment.capture:py-1",
+		},
+		{
+			name:          "v2 payment",
+			body:          `{"event": "payment.succeeded", "data": {"id": "py-1", "payment_request_id": "pr-1"}}`,
+			wantType:      "payment.succeeded",
+			wantReference: "pr-1",
+			wantKey:       "payment.succeeded:py-1",
+		},
+		{
+			name:          "payment_id preferred over id",
+			body:          `{"event": "payment.capture", "data": {"id": "pr-1", "payment_id": "py-1", "payment_request_id": "pr-1"}}`,
+			wantType:      "payment.capture",
+			wantReference: "pr-1",
+			wantKey:       "payment.capture:py-1",
+		},
+		{
+			name:          "Webhook-Id header overrides the key",
+			webhookID:     "wh-123",
+			body:          `{"event": "payment.capture", "data": {"payment_id": "py-1", "payment_request_id": "pr-1"}}`,
+			wantType:      "payment.capture",
+			wantReference: "pr-1",
+			wantKey:       "wh-123",
+		},
+		{
+			name:     "no object ID",
+			body:     `{"event": "payment.capture", "data": {}}`,
+			wantType: "payment.capture",
+			wantKey:  "sha256:",
+		},
+		{
+			name:    "unparsable body",
+			body:    `not json`,
+			wantKey: "sha256:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.webhookID != "" {
+				header.Set("Webhook-Id", tt.webhookID)
+			}
+
+			event := NewXenditEvent(header, []byte(tt.body))
+
+			if event.Provider != ProviderXendit {
+				t.Errorf("Provider = %q, want %q", event.Provider, ProviderXendit)
+			}
+			if event.EventType != tt.wantType {
+				t.Errorf("EventType = %q, want %q", event.EventType, tt.wantType)
+			}
+			if event.Reference != tt.wantReference {
+				t.Errorf("Reference = %q, want %q", event.Reference, tt.wantReference)
+			}
+			if tt.wantKey == "sha256:" {
+				if !strings.HasPrefix(event.DedupeKey, "sha256:") {
+					t.Errorf("DedupeKey = %q, want a body hash", event.DedupeKey)
+				}
+			} else if event.DedupeKey != tt.wantKey {
+				t.Errorf("DedupeKey = %q, want %q", event.DedupeKey, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestNewXenditEventHashesBody(t *testing.T) {
+	first := NewXenditEvent(http.Header{}, []byte(`not json`))
+	again := NewXenditEvent(http.Header{}, []byte(`not json`))
+	other := NewXenditEvent(http.Header{}, []byte(`still not json`))
+
+	if first.DedupeKey != again.DedupeKey {
+		t.Errorf("same body keyed %q and %q, want the same key", first.DedupeKey, again.DedupeKey)
+	}
+	if first.DedupeKey == other.DedupeKey {
+		t.Errorf("different bodies both keyed %q", first.DedupeKey)
+	}
+}
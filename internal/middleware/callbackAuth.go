@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// CallbackToken is a shared secret a provider sends with its callbacks. A
+// token being rotated out keeps working until ExpiresAt; the zero value never
+// expires.
+type CallbackToken struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+func (t CallbackToken) active(now time.Time) bool {
+	return t.Value != "" && (t.ExpiresAt.IsZero() || now.Before(t.ExpiresAt))
+}
+
+// CallbackAuth rejects provider callbacks that do not carry one of the active
+// tokens in header or, when allowedIPs is not empty, that come from outside
+// the listed addresses and CIDR ranges. The source address is the one left by
+// RealIP, which only takes it from forwarded headers set by a trusted proxy.
+func CallbackAuth(provider, header string, tokens []CallbackToken, allowedIPs []string) (func(http.Handler) http.Handler, error) {
+	allowed := make([]netip.Prefix, 0, len(allowedIPs))
+	for _, entry := range allowedIPs {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, err := parseAllowedIP(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed IP %q: %w", entry, err)
+		}
+		allowed = append(allowed, prefix)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, ipErr := remoteIP(r)
+
+			reject := func(reason string, status int) {
+				slog.Warn("callback rejected",
+					"provider", provider,
+					"reason", reason,
+					"remote_addr", r.RemoteAddr,
+					"path", r.URL.Path,
+					"user_agent", r.UserAgent(),
+					"request_id", middleware.GetReqID(r.Context()),
+				)
+				http.Error(w, http.StatusText(status), status)
+			}
+
+			if len(allowed) > 0 {
+				if ipErr != nil || !containsIP(allowed, ip) {
+					reject("source address not allowed", http.StatusForbidden)
+					return
+				}
+			}
+
+			sent := r.Header.Get(header)
+			if sent == "" {
+				reject("missing token", http.StatusUnauthorized)
+				return
+			}
+			if !matchToken(tokens, sent, time.Now()) {
+				reject("invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// matchToken compares sent against every active token in constant time and
+// without stopping at the first match.
+func matchToken(tokens []CallbackToken, sent string, now time.Time) bool {
+	matched := 0
+	for _, token := range tokens {
+		if !token.active(now) {
+			continue
+		}
+		matched |= subtle.ConstantTimeCompare([]byte(token.Value), []byte(sent))
+	}
+	return matched == 1
+}
+
+func parseAllowedIP(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func remoteIP(r *http.Request) (netip.Addr, error) {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+func containsIP(allowed []netip.Prefix, ip netip.Addr) bool {
+	for _, prefix := range allowed {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
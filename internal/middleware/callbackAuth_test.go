@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchToken(t *testing.T) {
+	now := time.Now()
+	tokens := []CallbackToken{
+		{Value: "current"},
+		{Value: "previous", ExpiresAt: now.Add(time.Hour)},
+		{Value: "retired", ExpiresAt: now.Add(-time.Hour)},
+		{Value: ""},
+	}
+
+	tests := []struct {
+		name string
+		sent string
+		want bool
+	}{
+		{"current token", "current", true},
+		{"previous token before it expires", "previous", true},
+		{"previous token after it expired", "retired", false},
+		{"unknown token", "guess", false},
+		{"prefix of a token", "curr", false},
+		{"empty token", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchToken(tokens, tt.sent, now); got != tt.want {
+				t.Errorf("matchToken(%q) = %v, want %v", tt.sent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallbackAuth(t *testing.T) {
+	tokens := []CallbackToken{
+		{Value: "current"},
+		{Value: "previous", ExpiresAt: time.Now().Add(-time.Minute)},
+	}
+
+	tests := []struct {
+		name       string
+		allowedIPs []string
+		remoteAddr string
+		token      string
+		want       int
+	}{
+		{"valid token", nil, "203.0.113.7:4000", "current", http.StatusOK},
+		{"missing token", nil, "203.0.113.7:4000", "", http.StatusUnauthorized},
+		{"expired previous token", nil, "203.0.113.7:4000", "previous", http.StatusUnauthorized},
+		{"allowed address", []string{"203.0.113.7"}, "203.0.113.7:4000", "current", http.StatusOK},
+		{"allowed range", []string{"198.51.100.1", "203.0.113.0/24"}, "203.0.113.99:4000", "current", http.StatusOK},
+		{"IPv4-mapped address in range", []string{"203.0.113.0/24"}, "[::ffff:203.0.113.7]:4000", "current", http.StatusOK},
+		{"address outside allowlist", []string{"203.0.113.0/24"}, "198.51.100.7:4000", "current", http.StatusForbidden},
+		{"allowlist checked before token", []string{"203.0.113.0/24"}, "198.51.100.7:4000", "", http.StatusForbidden},
+		{"unparsable address", []string{"203.0.113.0/24"}, "somewhere", "current", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := CallbackAuth("xendit", "X-CALLBACK-TOKEN", tokens, tt.allowedIPs)
+			if err != nil {
+				t.Fatalf("CallbackAuth() error = %v", err)
+			}
+			handler := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/xendit", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.token != "" {
+				req.Header.Set("X-CALLBACK-TOKEN", tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallbackAuthRejectsInvalidAllowlist(t *testing.T) {
+	for _, entry := range []string{"203.0.113", "203.0.113.0/33", "example.com"} {
+		if _, err := CallbackAuth("xendit", "X-CALLBACK-TOKEN", nil, []string{entry}); err == nil {
+			t.Errorf("CallbackAuth(%q) error = nil, want an error", entry)
+		}
+	}
+}
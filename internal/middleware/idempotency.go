@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/duniandewon/madkunyah-transactions-service/internal/features/idempotency"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Idempotency replays the stored response when a request is retried with the
+// same Idempotency-Key header. Requests without the header pass through.
+// Only successful responses are stored; anything else releases the key so the
+// client can try again. Keys are scoped to the authenticated user; guests
+// share a single scope.
+func Idempotency(store idempotency.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > 255 {
+				http.Error(w, "idempotency key too long", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			scope := "guest"
+			if claims, ok := GetClaims(r.Context()); ok {
+				scope = "user:" + strconv.Itoa(claims.UserID)
+			}
+
+			hash := sha256.New()
+			hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+			hash.Write(body)
+			requestHash := hex.EncodeToString(hash.Sum(nil))
+
+			record, err := store.Begin(r.Context(), scope, key, requestHash)
+			switch {
+			case errors.Is(err, idempotency.ErrRequestMismatch):
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			case errors.Is(err, idempotency.ErrKeyInProgress):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case err != nil:
+				http.Error(w, "failed to check idempotency key: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if record != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.StatusCode)
+				w.Write(record.Body)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			ctx := context.WithoutCancel(r.Context())
+
+			if rec.status < 200 || rec.status >= 300 {
+				if err := store.Release(ctx, scope, key); err != nil {
+					log.Printf("failed to release idempotency key %q: %v", key, err)
+				}
+				return
+			}
+
+			if err := store.Complete(ctx, scope, key, idempotency.Record{
+				StatusCode: rec.status,
+				Body:       rec.body.Bytes(),
+			}); err != nil {
+				log.Printf("failed to store idempotent response for key %q: %v", key, err)
+			}
+		})
+	}
+}
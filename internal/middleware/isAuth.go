@@ -20,10 +20,16 @@ type Claims struct {
 
 const ClaimsKey contextKey = "claims"
 
+const (
+	RoleAdmin   = "admin"
+	RoleKitchen = "kitchen"
+)
+
 var (
 	ErrMissingToken       = errors.New("missing authorization token")
 	ErrInvalidTokenFormat = errors.New("invalid token format")
 	ErrInvalidToken       = errors.New("invalid token")
+	ErrForbidden          = errors.New("insufficient permissions")
 )
 
 func verifyToken(secretKey, tokenString string) (*Claims, error) {
@@ -52,23 +58,46 @@ func GetClaims(ctx context.Context) (*Claims, bool) {
 	return claims, ok
 }
 
+func authenticate(r *http.Request, jwtSecret string) (*Claims, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, ErrMissingToken
+	}
+
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, ErrInvalidTokenFormat
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+
+	return verifyToken(jwtSecret, tokenString)
+}
+
 func IsAuth(jwtSecret string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, ErrMissingToken.Error(), http.StatusUnauthorized)
+			claims, err := authenticate(r, jwtSecret)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
 
-			if !strings.HasPrefix(authHeader, "Bearer ") {
-				http.Error(w, ErrInvalidTokenFormat.Error(), http.StatusUnauthorized)
+			ctx := context.WithValue(r.Context(), ClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// OptionalAuth attaches claims when a bearer token is sent and lets anonymous
+// requests through. A token that is present but invalid is still rejected.
+func OptionalAuth(jwtSecret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := authenticate(r, jwtSecret)
+			if errors.Is(err, ErrMissingToken) {
+				next.ServeHTTP(w, r)
 				return
 			}
-
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-			claims, err := verifyToken(jwtSecret, tokenString)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
@@ -79,3 +108,24 @@ func IsAuth(jwtSecret string) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+func HasRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaims(r.Context())
+			if !ok {
+				http.Error(w, ErrMissingToken.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			for _, role := range roles {
+				if claims.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+		})
+	}
+}
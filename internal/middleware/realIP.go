@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RealIP replaces the request's RemoteAddr with the client address from
+// X-Forwarded-For or X-Real-IP, but only when the request came through one of
+// trustedProxies. Anyone else could set those headers to any address, so their
+// requests keep the address of the connection.
+func RealIP(trustedProxies []string) (func(http.Handler) http.Handler, error) {
+	trusted := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, err := parseAllowedIP(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		trusted = append(trusted, prefix)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if peer, err := remoteIP(r); err == nil && containsIP(trusted, peer) {
+				if client, ok := forwardedFor(r, trusted); ok {
+					r.RemoteAddr = client.String()
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// forwardedFor walks X-Forwarded-For from the nearest hop back and returns the
+// first address not added by a trusted proxy, since every entry before it
+// could have been made up by the client.
+func forwardedFor(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = addr.Unmap()
+		if !containsIP(trusted, addr) {
+			return addr, true
+		}
+	}
+
+	if value := strings.TrimSpace(r.Header.Get("X-Real-IP")); value != "" {
+		host := value
+		if h, _, err := net.SplitHostPort(value); err == nil {
+			host = h
+		}
+		if addr, err := netip.ParseAddr(host); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "192.0.2.10"}
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  []string
+		realIP        string
+		wantRemoteIP  string
+		wantUnchanged bool
+	}{
+		{
+			name:         "client behind a trusted proxy",
+			remoteAddr:   "10.0.0.5:5000",
+			forwardedFor: []string{"203.0.113.7"},
+			wantRemoteIP: "203.0.113.7",
+		},
+		{
+			name:         "chain of trusted proxies",
+			remoteAddr:   "10.0.0.5:5000",
+			forwardedFor: []string{"203.0.113.7, 192.0.2.10, 10.1.2.3"},
+			wantRemoteIP: "203.0.113.7",
+		},
+		{
+			name:         "spoofed leftmost hop",
+			remoteAddr:   "10.0.0.5:5000",
+			forwardedFor: []string{"198.51.100.1, 203.0.113.7"},
+			wantRemoteIP: "203.0.113.7",
+		},
+		{
+			name:         "hops split across headers",
+			remoteAddr:   "10.0.0.5:5000",
+			forwardedFor: []string{"198.51.100.1", "203.0.113.7, 10.1.2.3"},
+			wantRemoteIP: "203.0.113.7",
+		},
+		{
+			name:         "X-Real-IP from a trusted proxy",
+			remoteAddr:   "10.0.0.5:5000",
+			realIP:       "203.0.113.7",
+			wantRemoteIP: "203.0.113.7",
+		},
+		{
+			name:          "headers from an untrusted peer",
+			remoteAddr:    "198.51.100.9:5000",
+			forwardedFor:  []string{"203.0.113.7"},
+			realIP:        "203.0.113.7",
+			wantUnchanged: true,
+		},
+		{
+			name:          "unparsable hop",
+			remoteAddr:    "10.0.0.5:5000",
+			forwardedFor:  []string{"203.0.113.7, not-an-ip"},
+			wantUnchanged: true,
+		},
+		{
+			name:          "no forwarded headers",
+			remoteAddr:    "10.0.0.5:5000",
+			wantUnchanged: true,
+		},
+	}
+
+	realIP, err := RealIP(trusted)
+	if err != nil {
+		t.Fatalf("RealIP() error = %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			want := tt.wantRemoteIP
+			if tt.wantUnchanged {
+				want = tt.remoteAddr
+			}
+			if got != want {
+				t.Errorf("RemoteAddr = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRealIPRejectsInvalidProxy(t *testing.T) {
+	if _, err := RealIP([]string{"10.0.0.0/40"}); err == nil {
+		t.Error("RealIP() error = nil, want an error")
+	}
+}
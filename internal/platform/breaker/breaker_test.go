@@ -0,0 +1,94 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const testCooldown = 20 * time.Millisecond
+
+// trip fails threshold allowed calls in a row.
+func trip(t *testing.T, b *Breaker, threshold int) {
+	t.Helper()
+
+	for i := 0; i < threshold; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("Allow() before opening = %v, want nil", err)
+		}
+		b.Failure()
+	}
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := New(3, time.Minute)
+
+	trip(t, b, 2)
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("State() after 2 failures = %s, want %s", got, StateClosed)
+	}
+
+	trip(t, b, 1)
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("State() after 3 failures = %s, want %s", got, StateOpen)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Allow() while open = %v, want ErrOpen", err)
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := New(2, time.Minute)
+
+	trip(t, b, 1)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() = %v, want nil", err)
+	}
+	b.Success()
+	trip(t, b, 1)
+
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("State() = %s, want %s, since failures were not consecutive", got, StateClosed)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name   string
+		finish func(b *Breaker)
+		want   string
+		// wantAllow is whether the next call is let through right after the
+		// probe finished.
+		wantAllow bool
+	}{
+		{"probe succeeds", (*Breaker).Success, StateClosed, true},
+		{"probe fails", (*Breaker).Failure, StateOpen, false},
+		{"probe ignored", (*Breaker).Ignore, StateHalfOpen, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(1, testCooldown)
+			trip(t, b, 1)
+			time.Sleep(testCooldown + 5*time.Millisecond)
+
+			if err := b.Allow(); err != nil {
+				t.Fatalf("Allow() after cooldown = %v, want nil", err)
+			}
+			if got := b.State(); got != StateHalfOpen {
+				t.Fatalf("State() while probing = %s, want %s", got, StateHalfOpen)
+			}
+			if err := b.Allow(); !errors.Is(err, ErrOpen) {
+				t.Fatalf("second Allow() while probing = %v, want ErrOpen", err)
+			}
+
+			tt.finish(b)
+			if got := b.State(); got != tt.want {
+				t.Fatalf("State() after probe = %s, want %s", got, tt.want)
+			}
+			if err := b.Allow(); (err == nil) != tt.wantAllow {
+				t.Fatalf("Allow() after probe = %v, want allowed %v", err, tt.wantAllow)
+			}
+		})
+	}
+}
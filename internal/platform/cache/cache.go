@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache stores encoded values for a limited time. A miss is reported as
+// found == false with a nil error.
+type Cache interface {
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+type entry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// Memory is a Cache local to the process. Expired entries are dropped when
+// they are next read or when the cache is swept on write.
+type Memory struct {
+	mu        sync.Mutex
+	items     map[string]entry
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		items:     make(map[string]entry),
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(item.expiresAt) {
+		delete(m.items, key)
+		return nil, false, nil
+	}
+
+	return item.value, true, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, item := range m.items {
+			if now.After(item.expiresAt) {
+				delete(m.items, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	m.items[key] = entry{value: value, expiresAt: now.Add(ttl)}
+	return nil
+}
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if _, found, err := m.Get(ctx, "missing"); found || err != nil {
+		t.Fatalf("Get(missing) = found %v, err %v, want a miss", found, err)
+	}
+
+	if err := m.Set(ctx, "menu:1", []byte("nasi goreng"), time.Minute); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+	value, found, err := m.Get(ctx, "menu:1")
+	if err != nil || !found || string(value) != "nasi goreng" {
+		t.Fatalf("Get(menu:1) = %q, found %v, err %v, want the stored value", value, found, err)
+	}
+
+	if err := m.Set(ctx, "menu:2", []byte("es teh"), time.Millisecond); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, found, err := m.Get(ctx, "menu:2"); found || err != nil {
+		t.Fatalf("Get(menu:2) after its ttl = found %v, err %v, want a miss", found, err)
+	}
+}
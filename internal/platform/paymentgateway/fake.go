@@ -0,0 +1,345 @@
+package paymentgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrInvalidSimulation = errors.New("payment request cannot be moved to that state")
+
+// Simulations accepted by FakeGateway.Simulate.
+const (
+	SimulatePay    = "pay"
+	SimulateExpire = "expire"
+	SimulateFail   = "fail"
+)
+
+// FakePayment is an entry in the fake gateway's ledger.
+type FakePayment struct {
+	GatewayID   string        `json:"gateway_id"`
+	ReferenceID string        `json:"reference_id"`
+	Amount      int           `json:"amount"`
+	Refunded    int           `json:"refunded"`
+	Method      PaymentMethod `json:"method"`
+	Action      PaymentAction `json:"action"`
+	Status      string        `json:"status"`
+	ExpiresAt   time.Time     `json:"expires_at,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// FakeGateway is an in-memory PaymentGateway for local development. IDs and
+// payment codes are derived from the reference ID, and payments only change
+// state through Simulate, which sends the same callback Xendit would to the
+// configured webhook URL.
+type FakeGateway struct {
+	mu          sync.Mutex
+	ledger      map[string]*FakePayment
+	references  map[string][]string
+	idempotency map[string]string
+	refunds     map[string]string
+	callbackURL string
+	token       string
+	client      *http.Client
+}
+
+func NewFakeGateway(callbackURL, callbackToken string) *FakeGateway {
+	return &FakeGateway{
+		ledger:      make(map[string]*FakePayment),
+		references:  make(map[string][]string),
+		idempotency: make(map[string]string),
+		refunds:     make(map[string]string),
+		callbackURL: callbackURL,
+		token:       callbackToken,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (f *FakeGateway) SupportsMethod(method PaymentMethod) error {
+	switch method.Type {
+	case "", MethodQRIS, MethodCard:
+		return nil
+	case MethodVirtualAccount, MethodEWallet:
+		if method.Channel == "" {
+			return fmt.Errorf("%w: %s requires a channel", ErrUnsupportedPaymentMethod, method.Type)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedPaymentMethod, method.Type)
+	}
+}
+
+// CreatePaymentRequest returns the request created earlier with the same
+// idempotency key, whatever its state, mirroring Xendit. Each payment attempt
+// for a reference uses its own key and so gets a new request.
+func (f *FakeGateway) CreatePaymentRequest(ctx context.Context, input CreatePaymentInput) (*PaymentRequest, error) {
+	if err := f.SupportsMethod(input.Method); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := input.idempotencyKey()
+	if id, ok := f.idempotency[key]; ok {
+		existing := f.ledger[id]
+		return &PaymentRequest{GatewayID: existing.GatewayID, Action: existing.Action}, nil
+	}
+
+	ids := f.references[input.ReferenceID]
+
+	id := fmt.Sprintf("pr-fake-%s-%d", input.ReferenceID, len(ids)+1)
+	now := time.Now()
+
+	payment := &FakePayment{
+		GatewayID:   id,
+		ReferenceID: input.ReferenceID,
+		Amount:      input.Amount,
+		Method:      input.Method,
+		Action:      fakeAction(id, input),
+		Status:      StatusPending,
+		ExpiresAt:   input.ExpiresAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	f.ledger[id] = payment
+	f.references[input.ReferenceID] = append(ids, id)
+	f.idempotency[key] = id
+
+	return &PaymentRequest{GatewayID: id, Action: payment.Action}, nil
+}
+
+func fakeAction(id string, input CreatePaymentInput) PaymentAction {
+	switch input.Method.Type {
+	case MethodVirtualAccount:
+		return PaymentAction{
+			Type:          ActionVirtualAccount,
+			BankCode:      input.Method.Channel,
+			AccountNumber: fmt.Sprintf("8808%012s", input.ReferenceID),
+		}
+	case MethodEWallet, MethodCard:
+		return PaymentAction{
+			Type: ActionRedirect,
+			URL:  "https://fake-gateway.local/pay/" + id,
+		}
+	default:
+		return PaymentAction{
+			Type:     ActionQRCode,
+			QRString: fmt.Sprintf("FAKEQRIS|%s|%d", id, input.Amount),
+		}
+	}
+}
+
+func (f *FakeGateway) GetPaymentStatus(ctx context.Context, gatewayID string) (*PaymentStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.ledger[gatewayID]
+	if !ok {
+		return nil, ErrPaymentRequestNotFound
+	}
+	return payment.status(), nil
+}
+
+func (f *FakeGateway) FindPaymentRequest(ctx context.Context, referenceID string) (*PaymentStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := f.references[referenceID]
+	if len(ids) == 0 {
+		return nil, ErrPaymentRequestNotFound
+	}
+	return f.ledger[ids[len(ids)-1]].status(), nil
+}
+
+func (f *FakeGateway) ListPaymentRequests(ctx context.Context, referenceID string) ([]*PaymentStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := f.references[referenceID]
+	requests := make([]*PaymentStatus, 0, len(ids))
+	for _, id := range ids {
+		requests = append(requests, f.ledger[id].status())
+	}
+	return requests, nil
+}
+
+func (f *FakeGateway) CancelPaymentRequest(ctx context.Context, gatewayID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.ledger[gatewayID]
+	if !ok {
+		return ErrPaymentRequestNotFound
+	}
+	if payment.Status != StatusPending {
+		return fmt.Errorf("%w: request is %s", ErrInvalidSimulation, payment.Status)
+	}
+
+	payment.Status = StatusCanceled
+	payment.UpdatedAt = time.Now()
+	return nil
+}
+
+// Refund succeeds immediately. Refunds are keyed on the reference ID, so a
+// retried refund returns the original one.
+func (f *FakeGateway) Refund(ctx context.Context, input RefundInput) (*RefundResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.refunds[input.ReferenceID]; ok {
+		return &RefundResult{ID: id, Status: RefundSucceeded}, nil
+	}
+
+	payment, ok := f.ledger[input.GatewayID]
+	if !ok {
+		return nil, ErrPaymentRequestNotFound
+	}
+	if payment.Status != StatusPaid {
+		return nil, fmt.Errorf("%w: request is %s", ErrRefundRejected, payment.Status)
+	}
+	if payment.Refunded+input.Amount > payment.Amount {
+		return nil, fmt.Errorf("%w: %d exceeds the remaining %d", ErrRefundRejected, input.Amount, payment.Amount-payment.Refunded)
+	}
+
+	id := fmt.Sprintf("rfd-fake-%d", len(f.refunds)+1)
+	f.refunds[input.ReferenceID] = id
+	payment.Refunded += input.Amount
+	payment.UpdatedAt = time.Now()
+
+	return &RefundResult{ID: id, Status: RefundSucceeded}, nil
+}
+
+// Payments lists the ledger, oldest first.
+func (f *FakeGateway) Payments() []FakePayment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payments := make([]FakePayment, 0, len(f.ledger))
+	for _, payment := range f.ledger {
+		payments = append(payments, *payment)
+	}
+	sort.Slice(payments, func(i, j int) bool {
+		return payments[i].CreatedAt.Before(payments[j].CreatedAt)
+	})
+	return payments
+}
+
+// Simulate settles a pending request as paid, expired or failed and delivers
+// the matching callback. The ledger is updated even when the callback is
+// rejected, the same as a real provider. A request past its expiry can only
+// be expired.
+func (f *FakeGateway) Simulate(ctx context.Context, gatewayID, simulation string) (*FakePayment, error) {
+	var status, event, xenditStatus string
+	switch simulation {
+	case SimulatePay:
+		status, event, xenditStatus = StatusPaid, "payment.capture", "SUCCEEDED"
+	case SimulateExpire:
+		status, event, xenditStatus = StatusExpired, "payment_request.expiry", "EXPIRED"
+	case SimulateFail:
+		status, event, xenditStatus = StatusFailed, "payment.failure", "FAILED"
+	default:
+		return nil, fmt.Errorf("%w: unknown simulation %q", ErrInvalidSimulation, simulation)
+	}
+
+	f.mu.Lock()
+	payment, ok := f.ledger[gatewayID]
+	if !ok {
+		f.mu.Unlock()
+		return nil, ErrPaymentRequestNotFound
+	}
+	if simulation != SimulateExpire && payment.overdue(time.Now()) {
+		payment.Status = StatusExpired
+		payment.UpdatedAt = time.Now()
+	}
+	if payment.Status != StatusPending {
+		f.mu.Unlock()
+		return nil, fmt.Errorf("%w: request is %s", ErrInvalidSimulation, payment.Status)
+	}
+	payment.Status = status
+	payment.UpdatedAt = time.Now()
+	snapshot := *payment
+	f.mu.Unlock()
+
+	return &snapshot, f.sendCallback(ctx, event, xenditStatus, snapshot)
+}
+
+func (f *FakeGateway) sendCallback(ctx context.Context, event, xenditStatus string, payment FakePayment) error {
+	channel := payment.Method.Channel
+	if channel == "" {
+		channel = "QRIS"
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"event":       event,
+		"business_id": "fake-business",
+		"created":     payment.UpdatedAt.UTC().Format(time.RFC3339),
+		"data": map[string]any{
+			"id":                 "py-" + payment.GatewayID,
+			"payment_request_id": payment.GatewayID,
+			"reference_id":       payment.ReferenceID,
+			"status":             xenditStatus,
+			"channel_code":       channel,
+			"amount":             payment.Amount,
+			"currency":           "IDR",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal callback: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create callback request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CALLBACK-TOKEN", f.token)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send callback: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("callback rejected with status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// overdue reports whether the request is still pending past its expiry.
+func (p *FakePayment) overdue(now time.Time) bool {
+	return p.Status == StatusPending && !p.ExpiresAt.IsZero() && now.After(p.ExpiresAt)
+}
+
+// status reports a pending request past its expiry as expired, which lets the
+// expiry sweeper be exercised without a callback.
+func (p *FakePayment) status() *PaymentStatus {
+	status := p.Status
+	if p.overdue(time.Now()) {
+		status = StatusExpired
+	}
+
+	result := &PaymentStatus{
+		GatewayID:   p.GatewayID,
+		ReferenceID: p.ReferenceID,
+		Status:      status,
+		Amount:      p.Amount,
+		Currency:    "IDR",
+	}
+	if status == StatusPaid {
+		result.TransactionID = "py-" + p.GatewayID
+	}
+	return result
+}
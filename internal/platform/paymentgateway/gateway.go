@@ -1,7 +1,151 @@
 package paymentgateway
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUnsupportedPaymentMethod = errors.New("unsupported payment method")
+	ErrPaymentRequestNotFound   = errors.New("payment request not found")
+	// ErrRefundRejected means the provider refused a refund outright, so
+	// sending it again cannot succeed. Other refund errors leave its outcome
+	// unknown.
+	ErrRefundRejected = errors.New("refund rejected by provider")
+)
+
+// Payment statuses reported by GetPaymentStatus, normalised across providers
+// to the values used in the payments table.
+const (
+	StatusPending  = "pending"
+	StatusPaid     = "paid"
+	StatusFailed   = "failed"
+	StatusExpired  = "expired"
+	StatusCanceled = "canceled"
+)
+
+const (
+	MethodQRIS           = "qris"
+	MethodVirtualAccount = "virtual_account"
+	MethodEWallet        = "ewallet"
+	MethodCard           = "card"
+)
+
+// PaymentMethod is how the customer chose to pay. Channel picks the bank for
+// virtual accounts and the provider for e-wallets; it is unused for QRIS and
+// cards. Some e-wallets (OVO) charge a phone number instead of redirecting.
+type PaymentMethod struct {
+	Type         string `json:"type"`
+	Channel      string `json:"channel,omitempty"`
+	MobileNumber string `json:"mobile_number,omitempty"`
+}
+
+type CreatePaymentInput struct {
+	Amount      int
+	ReferenceID string
+	// IdempotencyKey makes retries of the same request return the original
+	// payment request. It must differ between payment attempts for the same
+	// reference; empty uses ReferenceID.
+	IdempotencyKey string
+	CustomerName   string
+	Method         PaymentMethod
+	// ExpiresAt is when the request stops accepting payment. The zero value
+	// leaves the provider's default.
+	ExpiresAt time.Time
+}
+
+func (i CreatePaymentInput) idempotencyKey() string {
+	if i.IdempotencyKey != "" {
+		return i.IdempotencyKey
+	}
+	return i.ReferenceID
+}
+
+// Actions tell the client what to do to complete a payment.
+const (
+	ActionQRCode         = "qr_code"
+	ActionVirtualAccount = "virtual_account"
+	ActionRedirect       = "redirect"
+	ActionDeeplink       = "deeplink"
+	// ActionAwaitApproval means the provider pushed the charge to the
+	// customer's app and there is nothing to show.
+	ActionAwaitApproval = "await_approval"
+)
+
+type PaymentAction struct {
+	Type          string `json:"type"`
+	QRString      string `json:"qr_string,omitempty"`
+	BankCode      string `json:"bank_code,omitempty"`
+	AccountNumber string `json:"account_number,omitempty"`
+	URL           string `json:"url,omitempty"`
+}
+
+// PaymentRequest is a created payment request and the action the customer
+// has to take to pay it.
+type PaymentRequest struct {
+	GatewayID string        `json:"gateway_id"`
+	Action    PaymentAction `json:"action"`
+}
+
+// PaymentStatus is the provider's view of a payment request.
+type PaymentStatus struct {
+	GatewayID   string `json:"gateway_id"`
+	ReferenceID string `json:"reference_id"`
+	Status      string `json:"status"`
+	Amount      int    `json:"amount"`
+	Currency    string `json:"currency"`
+	FailureCode string `json:"failure_code,omitempty"`
+	// TransactionID identifies the capture of a paid request, when the
+	// provider reports one.
+	TransactionID string `json:"transaction_id,omitempty"`
+}
+
+// Refund reasons accepted by providers.
+const (
+	RefundReasonRequestedByCustomer = "REQUESTED_BY_CUSTOMER"
+	RefundReasonCancellation        = "CANCELLATION"
+	RefundReasonDuplicate           = "DUPLICATE"
+	RefundReasonFraudulent          = "FRAUDULENT"
+	RefundReasonOthers              = "OTHERS"
+)
+
+// Refund statuses reported by Refund.
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+type RefundInput struct {
+	// GatewayID is the payment request being refunded.
+	GatewayID string
+	// ReferenceID identifies the refund on our side and makes the request
+	// idempotent.
+	ReferenceID string
+	Amount      int
+	Reason      string
+}
+
+type RefundResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
 
 type PaymentGateway interface {
-	CreatePaymentRequest(ctx context.Context, amount int, externalID string) (url string, gatewayID string, err error)
+	// SupportsMethod reports ErrUnsupportedPaymentMethod for methods the
+	// provider cannot charge, so orders can be rejected before they are saved.
+	SupportsMethod(method PaymentMethod) error
+	CreatePaymentRequest(ctx context.Context, input CreatePaymentInput) (*PaymentRequest, error)
+	// GetPaymentStatus returns ErrPaymentRequestNotFound for IDs the provider
+	// does not know.
+	GetPaymentStatus(ctx context.Context, gatewayID string) (*PaymentStatus, error)
+	// FindPaymentRequest returns the most recent payment request created for
+	// referenceID, or ErrPaymentRequestNotFound.
+	FindPaymentRequest(ctx context.Context, referenceID string) (*PaymentStatus, error)
+	// ListPaymentRequests returns every payment request created for
+	// referenceID, including ones that were superseded or never recorded.
+	ListPaymentRequests(ctx context.Context, referenceID string) ([]*PaymentStatus, error)
+	CancelPaymentRequest(ctx context.Context, gatewayID string) error
+	Refund(ctx context.Context, input RefundInput) (*RefundResult, error)
 }
@@ -0,0 +1,55 @@
+package paymentgateway
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownGateway = errors.New("unknown payment gateway")
+
+// Registry holds the configured gateways by name. Orders store the name of
+// the gateway they were paid through, so later calls for a payment resolve
+// the same provider even after the default changes.
+type Registry struct {
+	gateways    map[string]PaymentGateway
+	defaultName string
+}
+
+func NewRegistry(defaultName string) *Registry {
+	return &Registry{
+		gateways:    make(map[string]PaymentGateway),
+		defaultName: defaultName,
+	}
+}
+
+func (r *Registry) Register(name string, gateway PaymentGateway) {
+	r.gateways[name] = gateway
+}
+
+// Get returns the gateway registered under name, or the default gateway when
+// name is empty.
+func (r *Registry) Get(name string) (PaymentGateway, error) {
+	if name == "" {
+		name = r.defaultName
+	}
+
+	gateway, ok := r.gateways[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownGateway, name)
+	}
+	return gateway, nil
+}
+
+// Resolve is Get that also returns the resolved name, for callers that store
+// which gateway was used.
+func (r *Registry) Resolve(name string) (string, PaymentGateway, error) {
+	if name == "" {
+		name = r.defaultName
+	}
+
+	gateway, err := r.Get(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, gateway, nil
+}
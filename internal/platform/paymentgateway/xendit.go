@@ -4,53 +4,363 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/xendit/xendit-go/v7"
 	"github.com/xendit/xendit-go/v7/payment_request"
+	"github.com/xendit/xendit-go/v7/refund"
 )
 
 type XenditGateway struct {
-	client *xendit.APIClient
+	client           *xendit.APIClient
+	successReturnURL string
+	failureReturnURL string
 }
 
-func NewXenditGateway(secretKey string) *XenditGateway {
+// NewXenditGateway creates a gateway for the Xendit payment request API. The
+// return URLs are where e-wallet and card payers are sent after paying.
+func NewXenditGateway(secretKey, successReturnURL, failureReturnURL string) *XenditGateway {
 	return &XenditGateway{
-		client: xendit.NewClient(secretKey),
+		client:           xendit.NewClient(secretKey),
+		successReturnURL: successReturnURL,
+		failureReturnURL: failureReturnURL,
 	}
 }
 
-func (x *XenditGateway) CreatePaymentRequest(ctx context.Context, amount int, externalID string) (string, string, error) {
-	req := *payment_request.NewPaymentRequestParameters(payment_request.PAYMENTREQUESTCURRENCY_IDR)
-	req.SetAmount(float64(amount))
-	req.SetReferenceId(externalID)
+var xenditBanks = map[string]payment_request.VirtualAccountChannelCode{
+	"BCA":     payment_request.VIRTUALACCOUNTCHANNELCODE_BCA,
+	"BNI":     payment_request.VIRTUALACCOUNTCHANNELCODE_BNI,
+	"BRI":     payment_request.VIRTUALACCOUNTCHANNELCODE_BRI,
+	"BSI":     payment_request.VIRTUALACCOUNTCHANNELCODE_BSI,
+	"CIMB":    payment_request.VIRTUALACCOUNTCHANNELCODE_CIMB,
+	"MANDIRI": payment_request.VIRTUALACCOUNTCHANNELCODE_MANDIRI,
+	"PERMATA": payment_request.VIRTUALACCOUNTCHANNELCODE_PERMATA,
+}
 
-	paymentMethod := payment_request.NewPaymentMethodParameters(
-		payment_request.PAYMENTMETHODTYPE_QR_CODE,
-		payment_request.PAYMENTMETHODREUSABILITY_ONE_TIME_USE,
-	)
+// xenditEWallets lists the Indonesian e-wallets offered through payment
+// requests. GoPay is not available on Xendit and is rejected.
+var xenditEWallets = map[string]payment_request.EWalletChannelCode{
+	"DANA":      payment_request.EWALLETCHANNELCODE_DANA,
+	"LINKAJA":   payment_request.EWALLETCHANNELCODE_LINKAJA,
+	"OVO":       payment_request.EWALLETCHANNELCODE_OVO,
+	"SHOPEEPAY": payment_request.EWALLETCHANNELCODE_SHOPEEPAY,
+}
 
-	qrParams := payment_request.NewQRCodeParameters()
-	qrParams.SetChannelCode(payment_request.QRCODECHANNELCODE_QRIS)
+func (x *XenditGateway) SupportsMethod(method PaymentMethod) error {
+	_, err := x.paymentMethodParameters(CreatePaymentInput{Method: method})
+	return err
+}
 
-	paymentMethod.SetQrCode(*qrParams)
+func (x *XenditGateway) CreatePaymentRequest(ctx context.Context, input CreatePaymentInput) (*PaymentRequest, error) {
+	paymentMethod, err := x.paymentMethodParameters(input)
+	if err != nil {
+		return nil, err
+	}
+
+	req := *payment_request.NewPaymentRequestParameters(payment_request.PAYMENTREQUESTCURRENCY_IDR)
+	req.SetAmount(float64(input.Amount))
+	req.SetReferenceId(input.ReferenceID)
 	req.SetPaymentMethod(*paymentMethod)
 
-	resp, r, err := x.client.PaymentRequestApi.CreatePaymentRequest(ctx).
-		IdempotencyKey(externalID).
+	resp, r, xenditErr := x.client.PaymentRequestApi.CreatePaymentRequest(ctx).
+		IdempotencyKey(input.idempotencyKey()).
 		PaymentRequestParameters(req).
 		Execute()
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error when calling `PaymentRequestApi.CreatePaymentRequest``: %v\n", err.Error())
+	if xenditErr != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `PaymentRequestApi.CreatePaymentRequest``: %v\n", xenditErr.Error())
 
-		b, _ := json.Marshal(err.FullError())
+		b, _ := json.Marshal(xenditErr.FullError())
 		fmt.Fprintf(os.Stderr, "Full Error Struct: %v\n", string(b))
 
 		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+
+		return nil, fmt.Errorf("create payment request: %s", xenditErr.Error())
+	}
+
+	return &PaymentRequest{
+		GatewayID: resp.GetId(),
+		Action:    xenditAction(resp),
+	}, nil
+}
+
+func (x *XenditGateway) paymentMethodParameters(input CreatePaymentInput) (*payment_request.PaymentMethodParameters, error) {
+	method := input.Method
+	channel := strings.ToUpper(method.Channel)
+
+	switch method.Type {
+	case "", MethodQRIS:
+		paymentMethod := payment_request.NewPaymentMethodParameters(
+			payment_request.PAYMENTMETHODTYPE_QR_CODE,
+			payment_request.PAYMENTMETHODREUSABILITY_ONE_TIME_USE,
+		)
+
+		qrParams := payment_request.NewQRCodeParameters()
+		qrParams.SetChannelCode(payment_request.QRCODECHANNELCODE_QRIS)
+		if !input.ExpiresAt.IsZero() {
+			qrProps := payment_request.NewQRCodeChannelProperties()
+			qrProps.SetExpiresAt(input.ExpiresAt)
+			qrParams.SetChannelProperties(*qrProps)
+		}
+
+		paymentMethod.SetQrCode(*qrParams)
+		return paymentMethod, nil
+
+	case MethodVirtualAccount:
+		bank, ok := xenditBanks[channel]
+		if !ok {
+			return nil, fmt.Errorf("%w: virtual account bank %q", ErrUnsupportedPaymentMethod, method.Channel)
+		}
+
+		paymentMethod := payment_request.NewPaymentMethodParameters(
+			payment_request.PAYMENTMETHODTYPE_VIRTUAL_ACCOUNT,
+			payment_request.PAYMENTMETHODREUSABILITY_ONE_TIME_USE,
+		)
+
+		vaProps := payment_request.NewVirtualAccountChannelProperties(input.CustomerName)
+		if !input.ExpiresAt.IsZero() {
+			vaProps.SetExpiresAt(input.ExpiresAt)
+		}
+		paymentMethod.SetVirtualAccount(*payment_request.NewVirtualAccountParameters(bank, *vaProps))
+		return paymentMethod, nil
+
+	case MethodEWallet:
+		wallet, ok := xenditEWallets[channel]
+		if !ok {
+			return nil, fmt.Errorf("%w: e-wallet %q", ErrUnsupportedPaymentMethod, method.Channel)
+		}
+
+		paymentMethod := payment_request.NewPaymentMethodParameters(
+			payment_request.PAYMENTMETHODTYPE_EWALLET,
+			payment_request.PAYMENTMETHODREUSABILITY_ONE_TIME_USE,
+		)
+
+		walletProps := payment_request.NewEWalletChannelProperties()
+		if wallet == payment_request.EWALLETCHANNELCODE_OVO {
+			if method.MobileNumber == "" {
+				return nil, fmt.Errorf("%w: OVO requires a mobile number", ErrUnsupportedPaymentMethod)
+			}
+			walletProps.SetMobileNumber(method.MobileNumber)
+		} else {
+			walletProps.SetSuccessReturnUrl(x.successReturnURL)
+			walletProps.SetFailureReturnUrl(x.failureReturnURL)
+		}
+
+		walletParams := payment_request.NewEWalletParameters()
+		walletParams.SetChannelCode(wallet)
+		walletParams.SetChannelProperties(*walletProps)
+
+		paymentMethod.SetEwallet(*walletParams)
+		return paymentMethod, nil
+
+	case MethodCard:
+		paymentMethod := payment_request.NewPaymentMethodParameters(
+			payment_request.PAYMENTMETHODTYPE_CARD,
+			payment_request.PAYMENTMETHODREUSABILITY_ONE_TIME_USE,
+		)
+
+		cardProps := payment_request.NewCardChannelProperties()
+		cardProps.SetSuccessReturnUrl(x.successReturnURL)
+		cardProps.SetFailureReturnUrl(x.failureReturnURL)
+		if !input.ExpiresAt.IsZero() {
+			cardProps.SetExpiresAt(input.ExpiresAt)
+		}
+
+		paymentMethod.SetCard(*payment_request.NewCardParameters(*cardProps))
+		return paymentMethod, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedPaymentMethod, method.Type)
+	}
+}
+
+// xenditAction picks what the customer has to do from a created payment
+// request. Deeplinks are preferred over web redirects since most customers
+// order from their phone.
+func xenditAction(pr *payment_request.PaymentRequest) PaymentAction {
+	paymentMethod := pr.GetPaymentMethod()
+
+	switch paymentMethod.GetType() {
+	case payment_request.PAYMENTMETHODTYPE_QR_CODE:
+		qrCode := paymentMethod.GetQrCode()
+		channelProps := qrCode.GetChannelProperties()
+		return PaymentAction{
+			Type:     ActionQRCode,
+			QRString: channelProps.GetQrString(),
+		}
+
+	case payment_request.PAYMENTMETHODTYPE_VIRTUAL_ACCOUNT:
+		va := paymentMethod.GetVirtualAccount()
+		channelProps := va.GetChannelProperties()
+		return PaymentAction{
+			Type:          ActionVirtualAccount,
+			BankCode:      string(va.GetChannelCode()),
+			AccountNumber: channelProps.GetVirtualAccountNumber(),
+		}
+	}
+
+	var redirect string
+	for _, action := range pr.GetActions() {
+		if action.GetUrl() == "" {
+			continue
+		}
+		if action.GetUrlType() == "DEEPLINK" {
+			return PaymentAction{Type: ActionDeeplink, URL: action.GetUrl()}
+		}
+		if redirect == "" {
+			redirect = action.GetUrl()
+		}
+	}
+
+	if redirect != "" {
+		return PaymentAction{Type: ActionRedirect, URL: redirect}
+	}
+
+	return PaymentAction{Type: ActionAwaitApproval}
+}
+
+func (x *XenditGateway) GetPaymentStatus(ctx context.Context, gatewayID string) (*PaymentStatus, error) {
+	pr, r, err := x.client.PaymentRequestApi.GetPaymentRequestByID(ctx, gatewayID).Execute()
+	if err != nil {
+		if r != nil && r.StatusCode == http.StatusNotFound {
+			return nil, ErrPaymentRequestNotFound
+		}
+		return nil, fmt.Errorf("get payment request: %s", err.Error())
 	}
 
-	qrString := *resp.PaymentMethod.QrCode.Get().ChannelProperties.QrString
+	return toPaymentStatus(*pr), nil
+}
+
+func (x *XenditGateway) FindPaymentRequest(ctx context.Context, referenceID string) (*PaymentStatus, error) {
+	resp, _, err := x.client.PaymentRequestApi.GetAllPaymentRequests(ctx).
+		ReferenceId([]string{referenceID}).
+		Limit(1).
+		Execute()
+	if err != nil {
+		return nil, fmt.Errorf("list payment requests: %s", err.Error())
+	}
+	if len(resp.Data) == 0 {
+		return nil, ErrPaymentRequestNotFound
+	}
+
+	return toPaymentStatus(resp.Data[0]), nil
+}
+
+func (x *XenditGateway) ListPaymentRequests(ctx context.Context, referenceID string) ([]*PaymentStatus, error) {
+	var (
+		requests []*PaymentStatus
+		afterID  string
+	)
+
+	for {
+		req := x.client.PaymentRequestApi.GetAllPaymentRequests(ctx).
+			ReferenceId([]string{referenceID}).
+			Limit(100)
+		if afterID != "" {
+			req = req.AfterId(afterID)
+		}
+
+		resp, _, err := req.Execute()
+		if err != nil {
+			return nil, fmt.Errorf("list payment requests: %s", err.Error())
+		}
+
+		for _, pr := range resp.Data {
+			requests = append(requests, toPaymentStatus(pr))
+		}
+		if !resp.HasMore || len(resp.Data) == 0 {
+			return requests, nil
+		}
+		afterID = resp.Data[len(resp.Data)-1].GetId()
+	}
+}
+
+func toPaymentStatus(pr payment_request.PaymentRequest) *PaymentStatus {
+	return &PaymentStatus{
+		GatewayID:   pr.GetId(),
+		ReferenceID: pr.GetReferenceId(),
+		Status:      xenditStatus(pr.GetStatus()),
+		Amount:      int(pr.GetAmount()),
+		Currency:    string(pr.GetCurrency()),
+		FailureCode: pr.GetFailureCode(),
+	}
+}
 
-	return qrString, resp.GetId(), nil
+// xenditStatus maps a payment request status onto our payment statuses.
+// Requests still waiting on the customer, or in a state we cannot act on,
+// are reported as pending.
+func xenditStatus(status payment_request.PaymentRequestStatus) string {
+	switch status {
+	case payment_request.PAYMENTREQUESTSTATUS_SUCCEEDED:
+		return StatusPaid
+	case payment_request.PAYMENTREQUESTSTATUS_FAILED:
+		return StatusFailed
+	case payment_request.PAYMENTREQUESTSTATUS_EXPIRED:
+		return StatusExpired
+	case payment_request.PAYMENTREQUESTSTATUS_CANCELED, payment_request.PAYMENTREQUESTSTATUS_VOIDED:
+		return StatusCanceled
+	default:
+		return StatusPending
+	}
+}
+
+// CancelPaymentRequest voids an unpaid payment request. Xendit has no cancel
+// call for payment requests, so the one-time payment method behind it is
+// expired instead, which makes the QR code unpayable.
+func (x *XenditGateway) CancelPaymentRequest(ctx context.Context, gatewayID string) error {
+	pr, _, err := x.client.PaymentRequestApi.GetPaymentRequestByID(ctx, gatewayID).Execute()
+	if err != nil {
+		return fmt.Errorf("get payment request: %s", err.Error())
+	}
+
+	_, _, err = x.client.PaymentMethodApi.ExpirePaymentMethod(ctx, pr.PaymentMethod.Id).Execute()
+	if err != nil {
+		return fmt.Errorf("expire payment method: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Refund requests a refund keyed on the caller's reference, so retrying the
+// same refund is safe while separate partial refunds are not collapsed.
+// Xendit settles refunds asynchronously and reports the outcome by callback.
+func (x *XenditGateway) Refund(ctx context.Context, input RefundInput) (*RefundResult, error) {
+	req := *refund.NewCreateRefund()
+	req.SetPaymentRequestId(input.GatewayID)
+	req.SetReferenceId(input.ReferenceID)
+	req.SetAmount(float64(input.Amount))
+	req.SetCurrency(string(payment_request.PAYMENTREQUESTCURRENCY_IDR))
+	if input.Reason != "" {
+		req.SetReason(input.Reason)
+	}
+
+	resp, r, err := x.client.RefundApi.CreateRefund(ctx).
+		IdempotencyKey(input.ReferenceID).
+		CreateRefund(req).
+		Execute()
+	if err != nil {
+		if r != nil && refundRejected(r.StatusCode) {
+			return nil, fmt.Errorf("%w: %s", ErrRefundRejected, err.Error())
+		}
+		return nil, fmt.Errorf("create refund: %s", err.Error())
+	}
+
+	return &RefundResult{
+		ID:     resp.GetId(),
+		Status: RefundPending,
+	}, nil
+}
+
+// refundRejected reports whether a refund response status is a final
+// refusal. Timeouts, conflicts with a request still in flight and rate
+// limits are not.
+func refundRejected(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return false
+	default:
+		return status >= 400 && status < 500
+	}
 }